@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"hackathon/services"
+	"os"
+)
+
+const usage = `Usage: hackathon-app [command]
+
+Without a command the API server is started.
+
+Commands:
+  keys    Manage JWT signing keys
+  roles   Manage user roles
+`
+
+// runCommand runs an administrative command instead of the server and returns the exit code.
+func runCommand(srv *services.Service, args []string) int {
+	switch args[0] {
+	case "keys":
+		return runKeysCommand(srv.Keys, args[1:])
+	case "roles":
+		return runRolesCommand(srv.Role, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+}
@@ -12,13 +12,17 @@ type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
 	JWT      JWTConfig
+	Auth     AuthConfig
 	Storage  StorageConfig
+	Mail     MailConfig
+	OIDC     OIDCConfig
 }
 
 type ServerConfig struct {
-	Port      string
-	LogLevel  string
-	PrettyLog bool
+	Port        string
+	LogLevel    string
+	PrettyLog   bool
+	BehindProxy bool
 }
 
 type DatabaseConfig struct {
@@ -31,14 +35,75 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret          string
-	ExpirationHours int
+	Secret             string
+	SigningKeyFile     string
+	SigningKeyID       string
+	KeysDir            string
+	AccessTokenMinutes int
+	RefreshTokenHours  int
+}
+
+type AuthConfig struct {
+	LoginRequiresVerifiedEmail  bool
+	UploadRequiresVerifiedEmail bool
+	MFAIssuer                   string
+	LoginAttemptStore           string
+	LoginMaxAttempts            int
+	LoginIPMaxAttempts          int
+	LoginLockoutMinutes         int
+	MailMaxRequests             int
+	MailIPMaxRequests           int
+	PasswordHash                string
+	Argon2MemoryKiB             int
+	Argon2Iterations            int
+	Argon2Parallelism           int
+	BcryptCost                  int
+	PasswordMinLength           int
+	PasswordMaxLength           int
+	PasswordMinClasses          int
+	PasswordAllowUsername       bool
+	BreachedPasswordsPath       string
 }
 
 type StorageConfig struct {
-	UploadDir    string
-	MaxSizeMB    int64
-	AllowedTypes string
+	Driver               string
+	UploadDir            string
+	MaxSizeMB            int64
+	AllowedTypes         string
+	S3Endpoint           string
+	S3Region             string
+	S3Bucket             string
+	S3AccessKey          string
+	S3SecretKey          string
+	S3UsePathStyle       bool
+	S3TimeoutSeconds     int
+	TusStagingDir        string
+	TusExpiryHours       int
+	TrashRetentionHours  int
+	PurgeIntervalMinutes int
+	ExportSyncMaxMB      int64
+	ExportRetentionHours int
+}
+
+type MailConfig struct {
+	Driver               string
+	From                 string
+	FileDir              string
+	SMTPHost             string
+	SMTPPort             int
+	SMTPUsername         string
+	SMTPPassword         string
+	PasswordResetURL     string
+	EmailVerificationURL string
+}
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       string
+	AutoRegister bool
 }
 
 func Load() (*Config, error) {
@@ -74,6 +139,7 @@ func populateConfig(config *Config, envMap map[string]string) {
 	config.Server.Port = getString(envMap, "SERVER_PORT", "8080")
 	config.Server.LogLevel = getString(envMap, "SERVER_LOG_LEVEL", "info")
 	config.Server.PrettyLog = getBool(envMap, "SERVER_PRETTY_LOG", false)
+	config.Server.BehindProxy = getBool(envMap, "SERVER_BEHIND_PROXY", false)
 
 	// Database
 	config.Database.Host = getString(envMap, "DATABASE_HOST", "localhost")
@@ -85,12 +151,70 @@ func populateConfig(config *Config, envMap map[string]string) {
 
 	// JWT
 	config.JWT.Secret = getString(envMap, "JWT_SECRET", "your-secret-key")
-	config.JWT.ExpirationHours = getInt(envMap, "JWT_EXPIRATION_HOURS", 24)
+	config.JWT.SigningKeyFile = getString(envMap, "JWT_SIGNING_KEY_FILE", "")
+	config.JWT.SigningKeyID = getString(envMap, "JWT_SIGNING_KEY_ID", "")
+	config.JWT.KeysDir = getString(envMap, "JWT_KEYS_DIR", "")
+	config.JWT.AccessTokenMinutes = getInt(envMap, "JWT_ACCESS_TOKEN_MINUTES", 15)
+	config.JWT.RefreshTokenHours = getInt(envMap, "JWT_REFRESH_TOKEN_HOURS", 720)
+
+	// Auth
+	config.Auth.LoginRequiresVerifiedEmail = getBool(envMap, "AUTH_LOGIN_REQUIRES_VERIFIED_EMAIL", false)
+	config.Auth.UploadRequiresVerifiedEmail = getBool(envMap, "AUTH_UPLOAD_REQUIRES_VERIFIED_EMAIL", false)
+	config.Auth.MFAIssuer = getString(envMap, "AUTH_MFA_ISSUER", "Hackathon")
+	config.Auth.LoginAttemptStore = getString(envMap, "AUTH_LOGIN_ATTEMPT_STORE", "memory")
+	config.Auth.LoginMaxAttempts = getInt(envMap, "AUTH_LOGIN_MAX_ATTEMPTS", 10)
+	config.Auth.LoginIPMaxAttempts = getInt(envMap, "AUTH_LOGIN_IP_MAX_ATTEMPTS", 100)
+	config.Auth.LoginLockoutMinutes = getInt(envMap, "AUTH_LOGIN_LOCKOUT_MINUTES", 15)
+	config.Auth.MailMaxRequests = getInt(envMap, "AUTH_MAIL_MAX_REQUESTS", 3)
+	config.Auth.MailIPMaxRequests = getInt(envMap, "AUTH_MAIL_IP_MAX_REQUESTS", 10)
+	config.Auth.PasswordHash = getString(envMap, "AUTH_PASSWORD_HASH", "argon2id")
+	config.Auth.Argon2MemoryKiB = getInt(envMap, "AUTH_ARGON2_MEMORY_KIB", 19456)
+	config.Auth.Argon2Iterations = getInt(envMap, "AUTH_ARGON2_ITERATIONS", 2)
+	config.Auth.Argon2Parallelism = getInt(envMap, "AUTH_ARGON2_PARALLELISM", 1)
+	config.Auth.BcryptCost = getInt(envMap, "AUTH_BCRYPT_COST", 10)
+	config.Auth.PasswordMinLength = getInt(envMap, "AUTH_PASSWORD_MIN_LENGTH", 8)
+	config.Auth.PasswordMaxLength = getInt(envMap, "AUTH_PASSWORD_MAX_LENGTH", 128)
+	config.Auth.PasswordMinClasses = getInt(envMap, "AUTH_PASSWORD_MIN_CLASSES", 1)
+	config.Auth.PasswordAllowUsername = getBool(envMap, "AUTH_PASSWORD_ALLOW_USERNAME", false)
+	config.Auth.BreachedPasswordsPath = getString(envMap, "AUTH_BREACHED_PASSWORDS_PATH", "")
 
 	// Storage
+	config.Storage.Driver = getString(envMap, "STORAGE_DRIVER", "local")
 	config.Storage.UploadDir = getString(envMap, "STORAGE_UPLOAD_DIR", "uploads")
 	config.Storage.MaxSizeMB = getInt64(envMap, "STORAGE_MAX_SIZE_MB", 10)
 	config.Storage.AllowedTypes = getString(envMap, "STORAGE_ALLOWED_TYPES", "image/jpeg,image/png")
+	config.Storage.S3Endpoint = getString(envMap, "STORAGE_S3_ENDPOINT", "")
+	config.Storage.S3Region = getString(envMap, "STORAGE_S3_REGION", "us-east-1")
+	config.Storage.S3Bucket = getString(envMap, "STORAGE_S3_BUCKET", "")
+	config.Storage.S3AccessKey = getString(envMap, "STORAGE_S3_ACCESS_KEY", "")
+	config.Storage.S3SecretKey = getString(envMap, "STORAGE_S3_SECRET_KEY", "")
+	config.Storage.S3UsePathStyle = getBool(envMap, "STORAGE_S3_USE_PATH_STYLE", true)
+	config.Storage.S3TimeoutSeconds = getInt(envMap, "STORAGE_S3_TIMEOUT_SECONDS", 30)
+	config.Storage.TusStagingDir = getString(envMap, "STORAGE_TUS_STAGING_DIR", "tus-staging")
+	config.Storage.TusExpiryHours = getInt(envMap, "STORAGE_TUS_EXPIRY_HOURS", 24)
+	config.Storage.TrashRetentionHours = getInt(envMap, "STORAGE_TRASH_RETENTION_HOURS", 720)
+	config.Storage.PurgeIntervalMinutes = getInt(envMap, "STORAGE_PURGE_INTERVAL_MINUTES", 60)
+	config.Storage.ExportSyncMaxMB = getInt64(envMap, "STORAGE_EXPORT_SYNC_MAX_MB", 50)
+	config.Storage.ExportRetentionHours = getInt(envMap, "STORAGE_EXPORT_RETENTION_HOURS", 24)
+
+	// Mail
+	config.Mail.Driver = getString(envMap, "MAIL_DRIVER", "log")
+	config.Mail.From = getString(envMap, "MAIL_FROM", "Hackathon <no-reply@localhost>")
+	config.Mail.FileDir = getString(envMap, "MAIL_FILE_DIR", "mail")
+	config.Mail.SMTPHost = getString(envMap, "MAIL_SMTP_HOST", "")
+	config.Mail.SMTPPort = getInt(envMap, "MAIL_SMTP_PORT", 587)
+	config.Mail.SMTPUsername = getString(envMap, "MAIL_SMTP_USERNAME", "")
+	config.Mail.SMTPPassword = getString(envMap, "MAIL_SMTP_PASSWORD", "")
+	config.Mail.PasswordResetURL = getString(envMap, "MAIL_PASSWORD_RESET_URL", "http://localhost:3000/reset-password?token={token}")
+	config.Mail.EmailVerificationURL = getString(envMap, "MAIL_EMAIL_VERIFICATION_URL", "http://localhost:3000/verify-email?token={token}")
+
+	// OIDC
+	config.OIDC.Issuer = getString(envMap, "OIDC_ISSUER", "")
+	config.OIDC.ClientID = getString(envMap, "OIDC_CLIENT_ID", "")
+	config.OIDC.ClientSecret = getString(envMap, "OIDC_CLIENT_SECRET", "")
+	config.OIDC.RedirectURL = getString(envMap, "OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback")
+	config.OIDC.Scopes = getString(envMap, "OIDC_SCOPES", "openid email profile")
+	config.OIDC.AutoRegister = getBool(envMap, "OIDC_AUTO_REGISTER", true)
 }
 
 func getString(envMap map[string]string, key string, defaultValue string) string {
@@ -22,9 +22,15 @@ func Init(cfg config.DatabaseConfig) {
 		log.Fatal().Err(err).Msg("Failed to connect to PostgreSQL")
 	}
 
-	if err := DB.AutoMigrate(&models.User{}, &models.FileMetadata{}); err != nil {
+	// Accounts from before roles existed get the user role once, when the roles are introduced.
+	// Later, a user without roles has had them removed on purpose.
+	backfillRoles := !DB.Migrator().HasTable(&models.Role{})
+	if err := DB.AutoMigrate(&models.Permission{}, &models.Role{}, &models.User{}, &models.Session{}, &models.RefreshToken{}, &models.PersonalAccessToken{}, &models.PasswordResetToken{}, &models.MFAChallenge{}, &models.RecoveryCode{}, &models.LoginAttempt{}, &models.FileMetadata{}, &models.FileGrant{}, &models.Blob{}, &models.Upload{}, &models.SigningKey{}, &models.ExportJob{}, &models.Identity{}, &models.OIDCLoginState{}); err != nil {
 		log.Fatal().Err(err).Msg("Failed to migrate database")
 	}
+	if err := seedRoles(DB, backfillRoles); err != nil {
+		log.Fatal().Err(err).Msg("Failed to seed roles")
+	}
 	log.Info().Msg("PostgreSQL connection established")
 }
 
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"hackathon/models"
+
+	"gorm.io/gorm"
+)
+
+// seedRoles makes sure the built-in roles exist with at least their built-in permissions. With
+// backfill, it also gives the user role to every account without a role, which are the accounts
+// created before roles existed.
+func seedRoles(db *gorm.DB, backfill bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for name, permissionNames := range models.BuiltinRoles {
+			var permissions []models.Permission
+			for _, permissionName := range permissionNames {
+				permission := models.Permission{Name: permissionName}
+				if err := tx.Where(&permission).FirstOrCreate(&permission).Error; err != nil {
+					return err
+				}
+				permissions = append(permissions, permission)
+			}
+
+			role := models.Role{Name: name}
+			if err := tx.Where(&role).FirstOrCreate(&role).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&role).Association("Permissions").Append(permissions); err != nil {
+				return err
+			}
+		}
+
+		if !backfill {
+			return nil
+		}
+		return tx.Exec(`INSERT INTO user_roles (user_id, role_id)
+			SELECT users.id, roles.id FROM users, roles
+			WHERE roles.name = ? AND NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id)`,
+			models.RoleUser).Error
+	})
+}
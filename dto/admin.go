@@ -0,0 +1,11 @@
+package dto
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles" validate:"required" example:"user,admin"`
+}
+
+type ListUsersRequest struct {
+	Q      string `query:"q" example:"dev"`
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=100" example:"20"`
+}
@@ -1,16 +1,131 @@
 package dto
 
+import "time"
+
 type RegisterRequest struct {
 	Username string `json:"username" validate:"required" example:"dev"`
-	Password string `json:"password" validate:"required,min=6" example:"123456"`
+	Email    string `json:"email" validate:"required,email,max=254" example:"dev@example.com"`
+	// Checked against the password policy
+	Password string `json:"password" validate:"required" example:"correct-horse-battery"`
 }
 
 type LoginRequest struct {
 	Username string `json:"username" validate:"required" example:"dev"`
 	Password string `json:"password" validate:"required,min=6" example:"123456"`
+	Device   string `json:"device" validate:"max=100" example:"Pixel 8"`
 }
 
 type TokenResponse struct {
-	Token       string `json:"token"`
-	ExpiredTime int64  `json:"expired_time"`
+	Token              string `json:"token"`
+	ExpiredTime        int64  `json:"expired_time"`
+	RefreshToken       string `json:"refresh_token"`
+	RefreshExpiredTime int64  `json:"refresh_expired_time"`
+}
+
+// LoginResponse holds the tokens or, for accounts with two-factor authentication, the challenge
+// to complete at /api/auth/mfa/verify.
+type LoginResponse struct {
+	*TokenResponse
+	MFARequired    bool   `json:"mfa_required"`
+	MFAToken       string `json:"mfa_token,omitempty"`
+	MFAExpiredTime int64  `json:"mfa_expired_time,omitempty"`
+}
+
+type OIDCLoginRequest struct {
+	Device string `query:"device" validate:"max=100" example:"Pixel 8"`
+}
+
+// OIDCCallbackRequest is the query the identity provider redirects back with.
+type OIDCCallbackRequest struct {
+	State            string `query:"state"`
+	Code             string `query:"code"`
+	Error            string `query:"error"`
+	ErrorDescription string `query:"error_description"`
+}
+
+type OIDCAuthorizationResponse struct {
+	// Open in the browser to sign in at the identity provider
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	// TOTP code or recovery code
+	Code string `json:"code" validate:"required,max=32" example:"123456"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required,max=32" example:"123456"`
+}
+
+type MFAStatusResponse struct {
+	Enabled           bool  `json:"enabled"`
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+}
+
+type MFAEnrollResponse struct {
+	// Secret for manual entry in the authenticator app
+	Secret string `json:"secret"`
+	// OTPAuthURI is the QR code payload
+	OTPAuthURI string `json:"otpauth_uri" example:"otpauth://totp/Hackathon:dev@example.com?algorithm=SHA1&digits=6&issuer=Hackathon&period=30&secret=JBSWY3DPEHPK3PXP"`
+}
+
+// RecoveryCodesResponse is the only response that contains the recovery codes.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	// Username or email address
+	Username string `json:"username" validate:"required" example:"dev@example.com"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email" example:"dev@example.com"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token string `json:"token" validate:"required"`
+	// Checked against the password policy
+	Password string `json:"password" validate:"required" example:"correct-horse-staple"`
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name" validate:"required,max=100" example:"ci-uploader"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,required" example:"files:write"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=3650" example:"90"`
+}
+
+type AccessTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateAccessTokenResponse is the only response that contains the token itself.
+type CreateAccessTokenResponse struct {
+	AccessTokenResponse
+	Token string `json:"token"`
 }
@@ -3,4 +3,17 @@ package dto
 type ErrorResponse struct {
 	Message    string `json:"message" example:"Error description"`
 	StatusCode int    `json:"status_code" example:"500"`
-}
\ No newline at end of file
+}
+
+// PasswordPolicyErrorResponse lists every password policy rule a new password violates.
+type PasswordPolicyErrorResponse struct {
+	Message    string              `json:"message" example:"password must be at least 8 characters long"`
+	StatusCode int                 `json:"status_code" example:"400"`
+	Violations []PasswordViolation `json:"violations"`
+}
+
+type PasswordViolation struct {
+	// One of min_length, max_length, character_classes, contains_username, breached
+	Rule    string `json:"rule" example:"min_length"`
+	Message string `json:"message" example:"must be at least 8 characters long"`
+}
@@ -6,3 +6,19 @@ type UploadResponse struct {
 	ID          uint   `json:"id"`
 	ContentType string `json:"content_type"`
 }
+
+type GrantRequest struct {
+	Username string `json:"username" validate:"required" example:"teammate"`
+}
+
+type ListFilesRequest struct {
+	ContentType string `query:"content_type" example:"image/png"`
+	From        string `query:"from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00" example:"2024-01-01T00:00:00Z"`
+	To          string `query:"to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00" example:"2024-12-31T23:59:59Z"`
+	Q           string `query:"q" example:"avatar"`
+	Trashed     bool   `query:"trashed" example:"false"`
+	Sort        string `query:"sort" validate:"omitempty,oneof=name size uploaded_at" example:"uploaded_at"`
+	Order       string `query:"order" validate:"omitempty,oneof=asc desc" example:"desc"`
+	Cursor      string `query:"cursor"`
+	Limit       int    `query:"limit" validate:"omitempty,min=1,max=100" example:"20"`
+}
@@ -0,0 +1,8 @@
+package dto
+
+// Page is the envelope returned by every collection endpoint. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items         []T    `json:"items"`
+	NextCursor    string `json:"next_cursor,omitempty"`
+	TotalEstimate int64  `json:"total_estimate"`
+}
@@ -0,0 +1,60 @@
+package dto
+
+import "time"
+
+type ProfileResponse struct {
+	ID            uint    `json:"id"`
+	Username      string  `json:"username"`
+	Email         *string `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	DisplayName   string  `json:"display_name"`
+	AvatarFileID  *uint   `json:"avatar_file_id"`
+	MFAEnabled    bool    `json:"mfa_enabled"`
+	// False for accounts created through single sign-on until a password is set
+	HasPassword bool     `json:"has_password"`
+	Roles       []string `json:"roles" example:"user"`
+}
+
+// UpdateProfileRequest changes the fields that are present.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100" example:"Dev"`
+	// ID of one of your files, 0 removes the avatar
+	AvatarFileID *uint `json:"avatar_file_id" example:"42"`
+}
+
+type ChangePasswordRequest struct {
+	// Not needed for accounts without a password, which reauthenticate instead
+	CurrentPassword string `json:"current_password" example:"correct-horse-battery"`
+	// Checked against the password policy
+	NewPassword string `json:"new_password" validate:"required" example:"correct-horse-staple"`
+}
+
+type ChangeEmailRequest struct {
+	Email string `json:"email" validate:"required,email,max=254" example:"dev@example.com"`
+	// Not needed for accounts without a password, which reauthenticate instead
+	Password string `json:"password" example:"correct-horse-battery"`
+}
+
+type DeleteAccountRequest struct {
+	// Not needed for accounts without a password, which reauthenticate instead
+	Password string `json:"password" example:"correct-horse-battery"`
+	// TOTP or recovery code, required if two-factor authentication is enabled
+	Code string `json:"code" example:"123456"`
+}
+
+type ExportRequest struct {
+	// Build the export as a background job even if it is small enough to be downloaded right away
+	Async bool `query:"async" example:"true"`
+}
+
+type ExportJobResponse struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status" example:"done"`
+	Size        int64      `json:"size"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	// Set once the export is done
+	DownloadURL string `json:"download_url,omitempty" example:"/api/me/export/jobs/5f2b.../download"`
+}
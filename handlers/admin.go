@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"hackathon/config"
+	"hackathon/dto"
+	"hackathon/middleware"
+	"hackathon/models"
+	"hackathon/pkg/pagination"
+	"hackathon/services"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AdminHandler struct {
+	roles *services.RoleService
+	users *services.UserService
+	cfg   *config.Config
+}
+
+func NewAdminHandler(g *echo.Group, roles *services.RoleService, users *services.UserService, authMiddleware echo.MiddlewareFunc, cfg *config.Config) *AdminHandler {
+	h := &AdminHandler{roles: roles, users: users, cfg: cfg}
+
+	adminGroup := g.Group("/admin")
+	adminGroup.Use(authMiddleware)
+	adminGroup.Use(middleware.RequirePermission(models.PermissionUsersAdmin))
+	adminGroup.GET("/roles", h.ListRoles)
+	adminGroup.GET("/users", h.ListUsers)
+	adminGroup.GET("/users/:id", h.GetUser)
+	adminGroup.POST("/users/:id/disable", h.DisableUser)
+	adminGroup.POST("/users/:id/enable", h.EnableUser)
+	adminGroup.POST("/users/:id/force-password-reset", h.ForcePasswordReset)
+	adminGroup.POST("/users/:id/revoke-tokens", h.RevokeTokens)
+	adminGroup.GET("/users/:id/roles", h.UserRoles)
+	adminGroup.PUT("/users/:id/roles", h.SetUserRoles)
+
+	return h
+}
+
+// @Summary List roles and their permissions
+// @Security BearerAuth
+// @Tags admin
+// @Success 200 {array} models.Role
+// @Failure 403 {object} map[string]string
+// @Router /api/admin/roles [get]
+func (h *AdminHandler) ListRoles(c echo.Context) error {
+	roles, err := h.roles.ListRoles()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+// @Summary List the roles of a user
+// @Security BearerAuth
+// @Tags admin
+// @Param id path int true "User ID"
+// @Success 200 {array} models.Role
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/admin/users/{id}/roles [get]
+func (h *AdminHandler) UserRoles(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+
+	roles, err := h.roles.UserRoles(id)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+// @Summary Replace the roles of a user
+// @Description The new permissions apply from the user's next login or token refresh.
+// @Security BearerAuth
+// @Tags admin
+// @Param id path int true "User ID"
+// @Param req body dto.SetRolesRequest true "Role names"
+// @Success 200 {array} models.Role
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/admin/users/{id}/roles [put]
+func (h *AdminHandler) SetUserRoles(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+	req := new(dto.SetRolesRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	roles, err := h.roles.SetUserRoles(id, req.Roles)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+// @Summary List users
+// @Description Cursor-paginated listing of all accounts, ordered by ID. Pass next_cursor from the previous page as cursor.
+// @Security BearerAuth
+// @Tags admin
+// @Param q query string false "Username contains"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param limit query int false "Page size (max 100)"
+// @Success 200 {object} dto.Page[models.User]
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /api/admin/users [get]
+func (h *AdminHandler) ListUsers(c echo.Context) error {
+	req := new(dto.ListUsersRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	page, err := h.users.ListUsers(*req)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary View a user
+// @Security BearerAuth
+// @Tags admin
+// @Param id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/admin/users/{id} [get]
+func (h *AdminHandler) GetUser(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+
+	user, err := h.users.GetUser(id)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary Disable a user
+// @Description Blocks login and signs the user out of every session.
+// @Security BearerAuth
+// @Tags admin
+// @Param id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/admin/users/{id}/disable [post]
+func (h *AdminHandler) DisableUser(c echo.Context) error {
+	admin, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+
+	user, err := h.users.DisableUser(id, admin.ID)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary Enable a user
+// @Security BearerAuth
+// @Tags admin
+// @Param id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse "The account has been deleted"
+// @Router /api/admin/users/{id}/enable [post]
+func (h *AdminHandler) EnableUser(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+
+	user, err := h.users.EnableUser(id)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary Force a password reset
+// @Description Signs the user out of every session; login is refused until the password has been reset.
+// @Security BearerAuth
+// @Tags admin
+// @Param id path int true "User ID"
+// @Success 200 {object} models.User
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse "The account has been deleted"
+// @Router /api/admin/users/{id}/force-password-reset [post]
+func (h *AdminHandler) ForcePasswordReset(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+
+	user, err := h.users.ForcePasswordReset(id)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary Revoke all tokens of a user
+// @Security BearerAuth
+// @Tags admin
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/admin/users/{id}/revoke-tokens [post]
+func (h *AdminHandler) RevokeTokens(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.users.RevokeTokens(id); err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Tokens revoked"})
+}
+
+func adminError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusNotFound})
+	case errors.Is(err, services.ErrRoleNotFound), errors.Is(err, services.ErrDisableSelf):
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	case errors.Is(err, services.ErrAccountDeleted):
+		return c.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusConflict})
+	default:
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+}
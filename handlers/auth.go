@@ -1,38 +1,67 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"hackathon/config"
 	"hackathon/dto"
 	"hackathon/middleware"
 	"hackathon/models"
-	"hackathon/repositories"
+	"hackathon/pkg/passhash"
 	"hackathon/services"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
 )
 
 type AuthHandler struct {
-	service  *services.AuthService
-	userRepo repositories.UserRepository
-	cfg      *config.Config
+	service   *services.AuthService
+	tokens    *services.TokenService
+	passwords *services.PasswordService
+	emails    *services.EmailService
+	mails     *services.MailThrottle
+	mfa       *services.MFAService
+	cfg       *config.Config
 }
 
-func NewAuthHandler(g *echo.Group, s *services.AuthService, userRepo repositories.UserRepository, cfg *config.Config) *AuthHandler {
-	h := &AuthHandler{service: s, userRepo: userRepo, cfg: cfg}
+func NewAuthHandler(g *echo.Group, s *services.AuthService, tokens *services.TokenService, passwords *services.PasswordService, emails *services.EmailService, mails *services.MailThrottle, mfa *services.MFAService, authMiddleware echo.MiddlewareFunc, cfg *config.Config) *AuthHandler {
+	h := &AuthHandler{service: s, tokens: tokens, passwords: passwords, emails: emails, mails: mails, mfa: mfa, cfg: cfg}
 	authGroup := g.Group("/auth")
 	authGroup.POST("/register", h.Register)
 	authGroup.POST("/login", h.Login)
-
-	authMiddleware := middleware.NewAuthMiddleware(userRepo, cfg.JWT.Secret)
-	authGroup.POST("/revoke", h.Revoke, authMiddleware)
+	authGroup.POST("/refresh", h.Refresh)
+	authGroup.POST("/mfa/verify", h.VerifyMFA)
+	authGroup.GET("/mfa", h.MFAStatus, authMiddleware)
+	authGroup.POST("/mfa/enroll", h.EnrollMFA, authMiddleware)
+	authGroup.POST("/mfa/confirm", h.ConfirmMFA, authMiddleware)
+	authGroup.POST("/mfa/recovery-codes", h.RegenerateRecoveryCodes, authMiddleware)
+	authGroup.POST("/mfa/disable", h.DisableMFA, authMiddleware)
+	authGroup.POST("/password/forgot", h.ForgotPassword)
+	authGroup.POST("/password/reset", h.ResetPassword)
+	authGroup.POST("/email/verify", h.VerifyEmail)
+	authGroup.POST("/email/resend", h.ResendVerification)
+	// Personal access tokens must not be able to sign the user out or mint and revoke tokens.
+	loginSession := middleware.RequireLoginSession()
+	authGroup.POST("/revoke", h.Revoke, authMiddleware, loginSession)
+	authGroup.GET("/sessions", h.ListSessions, authMiddleware, loginSession)
+	authGroup.DELETE("/sessions/:id", h.RevokeSession, authMiddleware, loginSession)
+	authGroup.GET("/tokens", h.ListTokens, authMiddleware, loginSession)
+	authGroup.POST("/tokens", h.CreateToken, authMiddleware)
+	authGroup.DELETE("/tokens/:id", h.RevokeAccessToken, authMiddleware, loginSession)
 	return h
 }
 
 // @Summary Register user
+// @Description Creates the account and mails a link to confirm the email address.
 // @Tags auth
 // @Param req body dto.RegisterRequest true "Info"
 // @Success 201 {object} map[string]string
+// @Failure 400 {object} dto.PasswordPolicyErrorResponse "The password violates the password policy"
+// @Failure 409 {object} dto.ErrorResponse "Username or email address taken"
 // @Router /api/auth/register [post]
 func (h *AuthHandler) Register(c echo.Context) error {
 	req := new(dto.RegisterRequest)
@@ -42,16 +71,31 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	if err := c.Validate(req); err != nil {
 		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
 	}
-	if err := h.service.Register(req.Username, req.Password); err != nil {
-		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	user, err := h.service.Register(req.Username, req.Email, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrUserExists) || errors.Is(err, services.ErrEmailExists) {
+			return c.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusConflict})
+		}
+		return passwordError(c, err)
 	}
+
+	ctx := context.WithoutCancel(c.Request().Context())
+	go func() {
+		if err := h.emails.SendVerification(ctx, user); err != nil {
+			log.Error().Err(err).Uint("user_id", user.ID).Msg("Failed to send email verification mail")
+		}
+	}()
 	return c.JSON(http.StatusCreated, echo.Map{"message": "User registered"})
 }
 
 // @Summary Login
+// @Description Starts a new session. The optional device name is shown in the session list, together with the user agent and IP address of the request. For accounts with two-factor authentication the response holds an MFA challenge instead of tokens; complete it at /api/auth/mfa/verify within five minutes.
 // @Tags auth
 // @Param req body dto.LoginRequest true "Info"
-// @Success 200 {object} dto.TokenResponse
+// @Success 200 {object} dto.LoginResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse "Account disabled, password reset required or email address not verified"
+// @Failure 429 {object} dto.ErrorResponse "Too many failed attempts for the username or IP address, retry after the Retry-After header (seconds)"
 // @Router /api/auth/login [post]
 func (h *AuthHandler) Login(c echo.Context) error {
 	req := new(dto.LoginRequest)
@@ -61,20 +105,207 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	if err := c.Validate(req); err != nil {
 		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
 	}
-	tokenResponse, err := h.service.Login(req.Username, req.Password)
+	client := services.ClientInfo{Device: req.Device, UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+	loginResponse, err := h.service.Login(req.Username, req.Password, client)
+	if err != nil {
+		var tooMany *services.TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			return tooManyAttempts(c, tooMany)
+		}
+		if errors.Is(err, services.ErrAccountDisabled) || errors.Is(err, services.ErrPasswordResetNeeded) || errors.Is(err, services.ErrEmailNotVerified) {
+			return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusForbidden})
+		}
+		if errors.Is(err, services.ErrInvalidCreds) {
+			return c.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Invalid credentials", StatusCode: http.StatusUnauthorized})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, loginResponse)
+}
+
+// @Summary Complete a two-factor login
+// @Description Exchanges the MFA challenge from the login response and a TOTP or recovery code for tokens. A challenge allows five attempts. Wrong codes count as failed logins of the user.
+// @Tags auth
+// @Param req body dto.MFAVerifyRequest true "Challenge and code"
+// @Success 200 {object} dto.TokenResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse "Account disabled"
+// @Failure 429 {object} dto.ErrorResponse "Too many failed attempts for the user or IP address, retry after the Retry-After header (seconds)"
+// @Router /api/auth/mfa/verify [post]
+func (h *AuthHandler) VerifyMFA(c echo.Context) error {
+	req := new(dto.MFAVerifyRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	tokenResponse, err := h.service.VerifyMFA(req.MFAToken, req.Code, c.RealIP())
+	if err != nil {
+		var tooMany *services.TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			return tooManyAttempts(c, tooMany)
+		}
+		if errors.Is(err, services.ErrInvalidMFAChallenge) || errors.Is(err, services.ErrInvalidMFACode) {
+			return c.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusUnauthorized})
+		}
+		if errors.Is(err, services.ErrAccountDisabled) {
+			return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusForbidden})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, tokenResponse)
+}
+
+// @Summary Refresh access token
+// @Description Exchanges a refresh token for a new access token and a new refresh token. Each refresh token can be used once; reusing one revokes every token issued from the same login.
+// @Tags auth
+// @Param req body dto.RefreshRequest true "Refresh token"
+// @Success 200 {object} dto.TokenResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse "Account disabled"
+// @Router /api/auth/refresh [post]
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	req := new(dto.RefreshRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	tokenResponse, err := h.service.Refresh(req.RefreshToken)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Invalid credentials", StatusCode: http.StatusUnauthorized})
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			return c.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusUnauthorized})
+		}
+		if errors.Is(err, services.ErrAccountDisabled) {
+			return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusForbidden})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
 	}
 	return c.JSON(http.StatusOK, tokenResponse)
 }
 
+// @Summary Request a password reset
+// @Description Mails a single-use link to reset the password, valid for one hour. The response is the same whether or not the account exists.
+// @Tags auth
+// @Param req body dto.ForgotPasswordRequest true "Account"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 429 {object} dto.ErrorResponse "Too many mails requested for the account or from the IP address, retry after the Retry-After header (seconds)"
+// @Router /api/auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c echo.Context) error {
+	req := new(dto.ForgotPasswordRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.mails.Attempt(req.Username, c.RealIP()); err != nil {
+		return mailError(c, err)
+	}
+
+	// Sending in the background keeps the response time from telling whether the account exists.
+	ctx := context.WithoutCancel(c.Request().Context())
+	go func() {
+		if err := h.passwords.ForgotPassword(ctx, req.Username); err != nil {
+			log.Error().Err(err).Msg("Failed to send password reset mail")
+		}
+	}()
+	return c.JSON(http.StatusAccepted, echo.Map{"message": "If the account exists, a password reset link has been sent"})
+}
+
+// @Summary Reset password
+// @Description Sets a new password with the token from the reset mail. Every session and token of the user is revoked.
+// @Tags auth
+// @Param req body dto.ResetPasswordRequest true "Token and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} dto.PasswordPolicyErrorResponse "Invalid or expired token, or the password violates the password policy"
+// @Router /api/auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c echo.Context) error {
+	req := new(dto.ResetPasswordRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.passwords.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		}
+		return passwordError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Password reset"})
+}
+
+// @Summary Confirm email address
+// @Description Marks the email address as verified with the token from the verification mail.
+// @Tags auth
+// @Param req body dto.VerifyEmailRequest true "Token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /api/auth/email/verify [post]
+func (h *AuthHandler) VerifyEmail(c echo.Context) error {
+	req := new(dto.VerifyEmailRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.emails.VerifyEmail(req.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Email address verified"})
+}
+
+// @Summary Resend email verification
+// @Description Mails a new verification link to the address, valid for 24 hours. The response is the same whether or not an unverified account uses the address.
+// @Tags auth
+// @Param req body dto.ResendVerificationRequest true "Email address"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 429 {object} dto.ErrorResponse "Too many mails requested for the address or from the IP address, retry after the Retry-After header (seconds)"
+// @Router /api/auth/email/resend [post]
+func (h *AuthHandler) ResendVerification(c echo.Context) error {
+	req := new(dto.ResendVerificationRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.mails.Attempt(req.Email, c.RealIP()); err != nil {
+		return mailError(c, err)
+	}
+
+	ctx := context.WithoutCancel(c.Request().Context())
+	go func() {
+		if err := h.emails.ResendVerification(ctx, req.Email); err != nil {
+			log.Error().Err(err).Msg("Failed to send email verification mail")
+		}
+	}()
+	return c.JSON(http.StatusAccepted, echo.Map{"message": "If the address needs to be verified, a new link has been sent"})
+}
+
 // @Summary Revoke user token by time
+// @Description Signs the user out of every session.
 // @Tags auth
 // @Security BearerAuth
 // @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string "Called with a personal access token"
 // @Router /api/auth/revoke [post]
 func (h *AuthHandler) Revoke(c echo.Context) error {
-	user, ok := c.Get("user").(*models.User)
+	user, ok := currentUser(c)
 	if !ok {
 		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
 	}
@@ -84,3 +315,336 @@ func (h *AuthHandler) Revoke(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, echo.Map{"message": "Tokens revoked"})
 }
+
+// @Summary List active sessions
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {array} dto.SessionResponse
+// @Failure 403 {object} map[string]string "Called with a personal access token"
+// @Router /api/auth/sessions [get]
+func (h *AuthHandler) ListSessions(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	current, _ := currentSession(c)
+
+	sessions, err := h.service.ListSessions(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	response := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, dto.SessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    current != nil && current.ID == session.ID,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Summary Revoke a session
+// @Description Signs a single session out. Its access and refresh tokens stop working immediately.
+// @Tags auth
+// @Security BearerAuth
+// @Param id path string true "Session ID"
+// @Success 204
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 403 {object} map[string]string "Called with a personal access token"
+// @Router /api/auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+
+	if err := h.service.RevokeSession(user.ID, c.Param("id")); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return c.JSON(http.StatusNotFound, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusNotFound})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Create a personal access token
+// @Description Long-lived token for scripts and CI, sent as a bearer token like an access token. It is only shown in this response. Scopes must be permissions the caller has. Can only be called with a login session.
+// @Tags auth
+// @Security BearerAuth
+// @Param req body dto.CreateAccessTokenRequest true "Token"
+// @Success 201 {object} dto.CreateAccessTokenResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Router /api/auth/tokens [post]
+func (h *AuthHandler) CreateToken(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	if _, ok := currentSession(c); !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "Access tokens can only be created from a login session", StatusCode: http.StatusForbidden})
+	}
+	req := new(dto.CreateAccessTokenRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		exp := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &exp
+	}
+	token, stored, err := h.tokens.Create(user.ID, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidScope) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusCreated, dto.CreateAccessTokenResponse{AccessTokenResponse: accessTokenResponse(stored), Token: token})
+}
+
+// @Summary List personal access tokens
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {array} dto.AccessTokenResponse
+// @Failure 403 {object} map[string]string "Called with a personal access token"
+// @Router /api/auth/tokens [get]
+func (h *AuthHandler) ListTokens(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+
+	tokens, err := h.tokens.List(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	response := make([]dto.AccessTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		response = append(response, accessTokenResponse(&tokens[i]))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// @Summary Revoke a personal access token
+// @Tags auth
+// @Security BearerAuth
+// @Param id path int true "Token ID"
+// @Success 204
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 403 {object} map[string]string "Called with a personal access token"
+// @Router /api/auth/tokens/{id} [delete]
+func (h *AuthHandler) RevokeAccessToken(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid token ID", StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.tokens.Revoke(user.ID, id); err != nil {
+		if errors.Is(err, services.ErrAccessTokenNotFound) {
+			return c.JSON(http.StatusNotFound, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusNotFound})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Two-factor authentication status
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {object} dto.MFAStatusResponse
+// @Router /api/auth/mfa [get]
+func (h *AuthHandler) MFAStatus(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	enabled, left, err := h.mfa.Status(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, dto.MFAStatusResponse{Enabled: enabled, RecoveryCodesLeft: left})
+}
+
+// @Summary Start two-factor enrollment
+// @Description Generates a TOTP secret. Add it to an authenticator app, usually by showing otpauth_uri as a QR code, then confirm with a first code. Starting again replaces a secret that was not confirmed. Can only be called with a login session.
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {object} dto.MFAEnrollResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse "Already enabled"
+// @Router /api/auth/mfa/enroll [post]
+func (h *AuthHandler) EnrollMFA(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	if _, ok := currentSession(c); !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "Two-factor authentication can only be changed from a login session", StatusCode: http.StatusForbidden})
+	}
+
+	secret, uri, err := h.mfa.Enroll(user)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, dto.MFAEnrollResponse{Secret: secret, OTPAuthURI: uri})
+}
+
+// @Summary Confirm two-factor enrollment
+// @Description Enables two-factor authentication with a first code from the authenticator app. The response holds one-time recovery codes, which are only shown here.
+// @Tags auth
+// @Security BearerAuth
+// @Param req body dto.MFACodeRequest true "TOTP code"
+// @Success 200 {object} dto.RecoveryCodesResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse "Already enabled"
+// @Router /api/auth/mfa/confirm [post]
+func (h *AuthHandler) ConfirmMFA(c echo.Context) error {
+	return h.withMFACode(c, func(user *models.User, code string, _ services.ClientInfo) error {
+		codes, err := h.mfa.Confirm(user, code)
+		if err != nil {
+			return mfaError(c, err)
+		}
+		return c.JSON(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes})
+	})
+}
+
+// @Summary Regenerate recovery codes
+// @Description Replaces every recovery code, after checking a TOTP or recovery code.
+// @Tags auth
+// @Security BearerAuth
+// @Param req body dto.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} dto.RecoveryCodesResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 429 {object} dto.ErrorResponse "Too many wrong codes, retry after the Retry-After header (seconds)"
+// @Router /api/auth/mfa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	return h.withMFACode(c, func(user *models.User, code string, client services.ClientInfo) error {
+		codes, err := h.mfa.RegenerateRecoveryCodes(user, code, client)
+		if err != nil {
+			return mfaError(c, err)
+		}
+		return c.JSON(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes})
+	})
+}
+
+// @Summary Disable two-factor authentication
+// @Description Turns two-factor authentication off, after checking a TOTP or recovery code. The recovery codes are deleted.
+// @Tags auth
+// @Security BearerAuth
+// @Param req body dto.MFACodeRequest true "TOTP or recovery code"
+// @Success 204
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 429 {object} dto.ErrorResponse "Too many wrong codes, retry after the Retry-After header (seconds)"
+// @Router /api/auth/mfa/disable [post]
+func (h *AuthHandler) DisableMFA(c echo.Context) error {
+	return h.withMFACode(c, func(user *models.User, code string, client services.ClientInfo) error {
+		if err := h.mfa.Disable(user, code, client); err != nil {
+			return mfaError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// withMFACode runs fn with the current user, the code of the request and the client, which must
+// come from a login session.
+func (h *AuthHandler) withMFACode(c echo.Context, fn func(user *models.User, code string, client services.ClientInfo) error) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	session, ok := currentSession(c)
+	if !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "Two-factor authentication can only be changed from a login session", StatusCode: http.StatusForbidden})
+	}
+	req := new(dto.MFACodeRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	return fn(user, req.Code, services.ClientInfo{Device: session.Device, UserAgent: c.Request().UserAgent(), IP: c.RealIP()})
+}
+
+// mfaError maps the errors of the MFA service to responses.
+func mfaError(c echo.Context, err error) error {
+	var tooMany *services.TooManyAttemptsError
+	switch {
+	case errors.As(err, &tooMany):
+		return tooManyAttempts(c, tooMany)
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrMFANotEnrolled), errors.Is(err, services.ErrMFANotEnabled):
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		return c.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusConflict})
+	default:
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+}
+
+// tooManyAttempts tells the client when it may try to login again.
+func tooManyAttempts(c echo.Context, err *services.TooManyAttemptsError) error {
+	setRetryAfter(c, err.RetryAfter)
+	return c.JSON(http.StatusTooManyRequests, dto.ErrorResponse{Message: services.ErrTooManyAttempts.Error(), StatusCode: http.StatusTooManyRequests})
+}
+
+// mailError maps errors of the mail throttle to responses.
+func mailError(c echo.Context, err error) error {
+	var tooMany *services.TooManyAttemptsError
+	if errors.As(err, &tooMany) {
+		setRetryAfter(c, tooMany.RetryAfter)
+		return c.JSON(http.StatusTooManyRequests, dto.ErrorResponse{Message: services.ErrTooManyMails.Error(), StatusCode: http.StatusTooManyRequests})
+	}
+	return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+}
+
+func setRetryAfter(c echo.Context, wait time.Duration) {
+	retryAfter := (wait + time.Second - 1) / time.Second
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(int64(retryAfter), 10))
+}
+
+// passwordError maps errors of setting a new password to responses. Policy violations are listed
+// rule by rule.
+func passwordError(c echo.Context, err error) error {
+	var weak *services.PasswordPolicyError
+	switch {
+	case errors.As(err, &weak):
+		violations := make([]dto.PasswordViolation, len(weak.Violations))
+		for i, violation := range weak.Violations {
+			violations[i] = dto.PasswordViolation{Rule: violation.Rule, Message: violation.Message}
+		}
+		return c.JSON(http.StatusBadRequest, dto.PasswordPolicyErrorResponse{Message: weak.Error(), StatusCode: http.StatusBadRequest, Violations: violations})
+	case errors.Is(err, passhash.ErrPasswordTooLong):
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	default:
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+}
+
+func accessTokenResponse(token *models.PersonalAccessToken) dto.AccessTokenResponse {
+	return dto.AccessTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     strings.Split(token.Scopes, ","),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
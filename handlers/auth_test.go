@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"hackathon/config"
+	"hackathon/middleware"
+	"hackathon/models"
+	"hackathon/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// personalTokenStub authenticates every personal access token as a user without a login session,
+// with every permission.
+type personalTokenStub struct{}
+
+func (personalTokenStub) Authenticate(c echo.Context, token string) (*middleware.Principal, error) {
+	if !strings.HasPrefix(token, services.PersonalTokenPrefix) {
+		return nil, middleware.ErrNotHandled
+	}
+	return &middleware.Principal{User: &models.User{ID: 1, Username: "alice"}, Permissions: []string{models.PermissionFilesRead, models.PermissionFilesWrite, models.PermissionUsersAdmin}}, nil
+}
+
+func TestAccountRoutesRequireLoginSession(t *testing.T) {
+	e := echo.New()
+	g := e.Group("/api")
+	authMiddleware := middleware.NewAuthMiddleware(personalTokenStub{})
+	cfg := &config.Config{}
+	NewAuthHandler(g, nil, nil, nil, nil, nil, nil, authMiddleware, cfg)
+	NewMeHandler(g, nil, nil, nil, nil, nil, authMiddleware, cfg)
+
+	routes := []struct{ method, path string }{
+		{http.MethodPost, "/api/auth/revoke"},
+		{http.MethodGet, "/api/auth/sessions"},
+		{http.MethodDelete, "/api/auth/sessions/abc"},
+		{http.MethodGet, "/api/auth/tokens"},
+		{http.MethodDelete, "/api/auth/tokens/1"},
+		{http.MethodPatch, "/api/me"},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{}`))
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+services.PersonalTokenPrefix+"secret")
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		})
+	}
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"hackathon/config"
+	"hackathon/middleware"
+	"hackathon/models"
+	"hackathon/pkg/keyring"
 	"hackathon/repositories"
 	"hackathon/services"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 )
@@ -13,13 +17,51 @@ type Handler struct {
 	services *services.Service
 	cfg      *config.Config
 	repos    *repositories.Repository
+	keys     *keyring.Keyring
 }
 
-func NewHandler(g *echo.Group, srv *services.Service, cfg *config.Config, repos *repositories.Repository) *Handler {
-	return &Handler{group: g, services: srv, cfg: cfg, repos: repos}
+func NewHandler(g *echo.Group, srv *services.Service, cfg *config.Config, repos *repositories.Repository, keys *keyring.Keyring) *Handler {
+	return &Handler{group: g, services: srv, cfg: cfg, repos: repos, keys: keys}
 }
 
 func (h *Handler) RegisterRoutes() {
-	NewAuthHandler(h.group, h.services.Auth, h.repos.User, h.cfg)
-	NewFileHandler(h.group, *h.services.File, h.repos.User, h.cfg)
+	authMiddleware := middleware.NewAuthMiddleware(
+		middleware.NewPersonalTokenAuthenticator(h.services.Token),
+		middleware.NewJWTAuthenticator(h.repos.User, h.repos.Session, h.keys),
+	)
+	NewAuthHandler(h.group, h.services.Auth, h.services.Token, h.services.Password, h.services.Email, h.services.Mail, h.services.MFA, authMiddleware, h.cfg)
+	NewFileHandler(h.group, *h.services.File, h.repos.User, authMiddleware, h.cfg)
+	NewTusHandler(h.group, h.services.Upload, authMiddleware, h.cfg)
+	NewAdminHandler(h.group, h.services.Role, h.services.User, authMiddleware, h.cfg)
+	NewMeHandler(h.group, h.services.Auth, h.services.Email, h.services.Profile, h.services.Export, h.services.Account, authMiddleware, h.cfg)
+	NewOIDCHandler(h.group, h.services.OIDC, authMiddleware, h.cfg)
+}
+
+// currentUser returns the authenticated user stored in the context by the auth middleware.
+func currentUser(c echo.Context) (*models.User, bool) {
+	user, ok := c.Get("user").(*models.User)
+	return user, ok
+}
+
+// currentSession returns the session of the access token, stored in the context by the auth middleware.
+func currentSession(c echo.Context) (*models.Session, bool) {
+	session, ok := c.Get("session").(*models.Session)
+	return session, ok
+}
+
+// requireVerifiedEmail guards the upload routes when uploads are restricted to users with a
+// verified email address. It must run after the auth middleware.
+func requireVerifiedEmail(cfg *config.Config) echo.MiddlewareFunc {
+	if !cfg.Auth.UploadRequiresVerifiedEmail {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	return middleware.RequireVerifiedEmail()
+}
+
+func parseIDParam(c echo.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
 }
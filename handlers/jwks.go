@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"hackathon/pkg/keyring"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type JWKSHandler struct {
+	keys *keyring.Keyring
+}
+
+func NewJWKSHandler(g *echo.Group, keys *keyring.Keyring) *JWKSHandler {
+	h := &JWKSHandler{keys: keys}
+	g.GET("/jwks.json", h.JWKS)
+	return h
+}
+
+// @Summary Public keys for verifying access tokens
+// @Description JSON Web Key Set of the keys access tokens are signed with, selected by the kid token header. Empty while tokens are signed with the shared HS256 secret.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} keyring.JWKSet
+// @Router /.well-known/jwks.json [get]
+func (h *JWKSHandler) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, h.keys.JWKS())
+}
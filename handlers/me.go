@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"hackathon/config"
+	"hackathon/dto"
+	"hackathon/middleware"
+	"hackathon/models"
+	"hackathon/services"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+type MeHandler struct {
+	auth     *services.AuthService
+	emails   *services.EmailService
+	profile  *services.ProfileService
+	exports  *services.ExportService
+	accounts *services.AccountService
+	cfg      *config.Config
+}
+
+func NewMeHandler(g *echo.Group, auth *services.AuthService, emails *services.EmailService, profile *services.ProfileService, exports *services.ExportService, accounts *services.AccountService, authMiddleware echo.MiddlewareFunc, cfg *config.Config) *MeHandler {
+	h := &MeHandler{auth: auth, emails: emails, profile: profile, exports: exports, accounts: accounts, cfg: cfg}
+
+	// The export holds the content of every file, so it needs the same permission as downloads.
+	canRead := middleware.RequirePermission(models.PermissionFilesRead)
+
+	meGroup := g.Group("/me")
+	meGroup.Use(authMiddleware)
+	meGroup.GET("", h.GetProfile)
+	meGroup.PATCH("", h.UpdateProfile, middleware.RequireLoginSession())
+	meGroup.DELETE("", h.DeleteAccount)
+	meGroup.POST("/password", h.ChangePassword)
+	meGroup.PUT("/email", h.ChangeEmail)
+	meGroup.GET("/export", h.Export, canRead)
+	meGroup.GET("/export/jobs/:id", h.GetExportJob, canRead)
+	meGroup.GET("/export/jobs/:id/download", h.DownloadExport, canRead)
+
+	return h
+}
+
+// @Summary Get your profile
+// @Tags me
+// @Security BearerAuth
+// @Success 200 {object} dto.ProfileResponse
+// @Router /api/me [get]
+func (h *MeHandler) GetProfile(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	user, err := h.profile.GetProfile(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, profileResponse(user))
+}
+
+// @Summary Update your profile
+// @Description Changes the fields present in the request. The avatar must be one of your files; 0 removes it.
+// @Tags me
+// @Security BearerAuth
+// @Param req body dto.UpdateProfileRequest true "Profile fields"
+// @Success 200 {object} dto.ProfileResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} map[string]string "Called with a personal access token"
+// @Router /api/me [patch]
+func (h *MeHandler) UpdateProfile(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	req := new(dto.UpdateProfileRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	user, err := h.profile.UpdateProfile(user, req.DisplayName, req.AvatarFileID)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAvatar) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, profileResponse(user))
+}
+
+// @Summary Change your password
+// @Description Requires the current password. Accounts created through single sign-on have none and reauthenticate with POST /api/me/reauthenticate first instead. Every session and token of the user is revoked; the response holds the tokens of a new session for this client. Can only be called with a login session.
+// @Tags me
+// @Security BearerAuth
+// @Param req body dto.ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} dto.TokenResponse
+// @Failure 400 {object} dto.PasswordPolicyErrorResponse "Wrong current password, or the new password violates the password policy"
+// @Failure 403 {object} dto.ErrorResponse "Not a login session, or no recent reauthentication"
+// @Failure 429 {object} dto.ErrorResponse "Too many wrong passwords, retry after the Retry-After header (seconds)"
+// @Router /api/me/password [post]
+func (h *MeHandler) ChangePassword(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	session, ok := currentSession(c)
+	if !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "The password can only be changed from a login session", StatusCode: http.StatusForbidden})
+	}
+	req := new(dto.ChangePasswordRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	client := services.ClientInfo{Device: session.Device, UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+	tokenResponse, err := h.auth.ChangePassword(user, session, req.CurrentPassword, req.NewPassword, client)
+	if err != nil {
+		var tooMany *services.TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			return tooManyAttempts(c, tooMany)
+		}
+		if errors.Is(err, services.ErrWrongPassword) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		}
+		if errors.Is(err, services.ErrReauthRequired) {
+			return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusForbidden})
+		}
+		return passwordError(c, err)
+	}
+	return c.JSON(http.StatusOK, tokenResponse)
+}
+
+// @Summary Change your email address
+// @Description Requires the password; accounts created through single sign-on have none and reauthenticate with POST /api/me/reauthenticate first instead. The new address is not verified until the link mailed to it is opened. Can only be called with a login session.
+// @Tags me
+// @Security BearerAuth
+// @Param req body dto.ChangeEmailRequest true "New email address and password"
+// @Success 200 {object} dto.ProfileResponse
+// @Failure 400 {object} dto.ErrorResponse "Wrong password"
+// @Failure 403 {object} dto.ErrorResponse "Not a login session, or no recent reauthentication"
+// @Failure 409 {object} dto.ErrorResponse "Email address taken"
+// @Failure 429 {object} dto.ErrorResponse "Too many wrong passwords, retry after the Retry-After header (seconds)"
+// @Router /api/me/email [put]
+func (h *MeHandler) ChangeEmail(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	session, ok := currentSession(c)
+	if !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "The email address can only be changed from a login session", StatusCode: http.StatusForbidden})
+	}
+	req := new(dto.ChangeEmailRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	client := services.ClientInfo{Device: session.Device, UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+	if err := h.auth.ChangeEmail(user, session, req.Password, req.Email, client); err != nil {
+		var tooMany *services.TooManyAttemptsError
+		switch {
+		case errors.As(err, &tooMany):
+			return tooManyAttempts(c, tooMany)
+		case errors.Is(err, services.ErrWrongPassword):
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		case errors.Is(err, services.ErrReauthRequired):
+			return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusForbidden})
+		case errors.Is(err, services.ErrEmailExists):
+			return c.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusConflict})
+		default:
+			return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+		}
+	}
+
+	if !user.EmailVerified {
+		ctx := context.WithoutCancel(c.Request().Context())
+		mailed := *user
+		go func() {
+			if err := h.emails.SendVerification(ctx, &mailed); err != nil {
+				log.Error().Err(err).Uint("user_id", mailed.ID).Msg("Failed to send email verification mail")
+			}
+		}()
+	}
+	user, err := h.profile.GetProfile(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, profileResponse(user))
+}
+
+// @Summary Delete your account
+// @Description Requires the password, and a TOTP or recovery code if two-factor authentication is enabled. Accounts created through single sign-on have no password and reauthenticate with POST /api/me/reauthenticate first instead. Every file is deleted for good and every session and token is revoked; the account is anonymized and cannot be used again. Can only be called with a login session.
+// @Tags me
+// @Security BearerAuth
+// @Param req body dto.DeleteAccountRequest true "Password and two-factor code"
+// @Success 204
+// @Failure 400 {object} dto.ErrorResponse "Wrong password or two-factor code"
+// @Failure 403 {object} dto.ErrorResponse "Not a login session, or no recent reauthentication"
+// @Failure 429 {object} dto.ErrorResponse "Too many wrong passwords or codes, retry after the Retry-After header (seconds)"
+// @Router /api/me [delete]
+func (h *MeHandler) DeleteAccount(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	session, ok := currentSession(c)
+	if !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "The account can only be deleted from a login session", StatusCode: http.StatusForbidden})
+	}
+	req := new(dto.DeleteAccountRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	client := services.ClientInfo{Device: session.Device, UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+	if err := h.accounts.DeleteAccount(c.Request().Context(), user, session, req.Password, req.Code, client); err != nil {
+		var tooMany *services.TooManyAttemptsError
+		switch {
+		case errors.As(err, &tooMany):
+			return tooManyAttempts(c, tooMany)
+		case errors.Is(err, services.ErrWrongPassword), errors.Is(err, services.ErrInvalidMFACode):
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		case errors.Is(err, services.ErrReauthRequired):
+			return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusForbidden})
+		default:
+			return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Export your data
+// @Description Returns a ZIP archive with your profile (profile.json), the metadata of your files including trashed ones (files.json) and the files themselves (files/). Large exports, or any export with async=true, are built in the background: the response is then 202 with the job, whose status can be polled at the Location header until it has a download_url.
+// @Tags me
+// @Security BearerAuth
+// @Produce application/zip
+// @Param async query bool false "Always build the export in the background"
+// @Success 200 {file} file
+// @Success 202 {object} dto.ExportJobResponse
+// @Failure 403 {object} dto.ErrorResponse "Missing the files:read permission"
+// @Router /api/me/export [get]
+func (h *MeHandler) Export(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	req := new(dto.ExportRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	background := req.Async
+	if !background {
+		exceeds, err := h.exports.ExceedsSyncLimit(user.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+		}
+		background = exceeds
+	}
+
+	if background {
+		job, created, err := h.exports.StartJob(user)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+		}
+		if created {
+			ctx := context.WithoutCancel(c.Request().Context())
+			go func() {
+				if err := h.exports.RunJob(ctx, job); err != nil {
+					log.Error().Err(err).Str("export_id", job.ID).Uint("user_id", job.UserID).Msg("Failed to export user data")
+				}
+			}()
+		}
+		c.Response().Header().Set(echo.HeaderLocation, exportJobURL(job))
+		return c.JSON(http.StatusAccepted, exportJobResponse(job))
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "application/zip")
+	header.Set(echo.HeaderContentDisposition, contentDisposition(exportFilename(user)))
+	header.Set("Cache-Control", "no-store")
+	c.Response().WriteHeader(http.StatusOK)
+	if err := h.exports.Write(c.Request().Context(), user, c.Response()); err != nil {
+		// The status is sent already; the client notices the truncated archive.
+		log.Error().Err(err).Uint("user_id", user.ID).Msg("Failed to export user data")
+	}
+	return nil
+}
+
+// @Summary Get an export job
+// @Tags me
+// @Security BearerAuth
+// @Param id path string true "Export job ID"
+// @Success 200 {object} dto.ExportJobResponse
+// @Failure 403 {object} dto.ErrorResponse "Missing the files:read permission"
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/me/export/jobs/{id} [get]
+func (h *MeHandler) GetExportJob(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	job, err := h.exports.GetJob(user.ID, c.Param("id"))
+	if err != nil {
+		return exportError(c, err)
+	}
+	return c.JSON(http.StatusOK, exportJobResponse(job))
+}
+
+// @Summary Download an export
+// @Description Available until the expires_at of the job.
+// @Tags me
+// @Security BearerAuth
+// @Produce application/zip
+// @Param id path string true "Export job ID"
+// @Success 200 {file} file
+// @Failure 403 {object} dto.ErrorResponse "Missing the files:read permission"
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse "The export is not done"
+// @Router /api/me/export/jobs/{id}/download [get]
+func (h *MeHandler) DownloadExport(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	job, content, err := h.exports.OpenArchive(c.Request().Context(), user.ID, c.Param("id"))
+	if err != nil {
+		return exportError(c, err)
+	}
+	defer content.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "application/zip")
+	header.Set(echo.HeaderContentDisposition, contentDisposition(exportFilename(user)))
+	header.Set("Cache-Control", "no-store")
+	http.ServeContent(c.Response(), c.Request(), "", *job.CompletedAt, content)
+	return nil
+}
+
+func exportError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrExportNotFound):
+		return c.JSON(http.StatusNotFound, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusNotFound})
+	case errors.Is(err, services.ErrExportNotReady):
+		return c.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusConflict})
+	default:
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+}
+
+func exportJobURL(job *models.ExportJob) string {
+	return "/api/me/export/jobs/" + job.ID
+}
+
+func exportJobResponse(job *models.ExportJob) dto.ExportJobResponse {
+	resp := dto.ExportJobResponse{
+		ID:          job.ID,
+		Status:      job.Status,
+		Size:        job.Size,
+		Error:       job.Error,
+		CreatedAt:   job.CreatedAt,
+		CompletedAt: job.CompletedAt,
+		ExpiresAt:   job.ExpiresAt,
+	}
+	if job.Status == models.ExportDone {
+		resp.DownloadURL = exportJobURL(job) + "/download"
+	}
+	return resp
+}
+
+func exportFilename(user *models.User) string {
+	return "export-" + user.Username + ".zip"
+}
+
+func profileResponse(user *models.User) dto.ProfileResponse {
+	roles := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		roles[i] = role.Name
+	}
+	return dto.ProfileResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		DisplayName:   user.DisplayName,
+		AvatarFileID:  user.AvatarFileID,
+		MFAEnabled:    user.MFAEnabled,
+		HasPassword:   !user.NoPassword,
+		Roles:         roles,
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"hackathon/config"
+	"hackathon/dto"
+	"hackathon/services"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// oidcStateCookie binds a sign-in to the browser that started it, so that a callback URL with
+// someone else's state and code cannot be used to sign in to their account.
+const oidcStateCookie = "oidc_state"
+
+type OIDCHandler struct {
+	service *services.OIDCService
+	cfg     *config.Config
+}
+
+func NewOIDCHandler(g *echo.Group, service *services.OIDCService, authMiddleware echo.MiddlewareFunc, cfg *config.Config) *OIDCHandler {
+	h := &OIDCHandler{service: service, cfg: cfg}
+
+	oidcGroup := g.Group("/auth/oidc")
+	oidcGroup.GET("/login", h.Login)
+	oidcGroup.GET("/callback", h.Callback)
+
+	identityGroup := g.Group("/me/identities")
+	identityGroup.Use(authMiddleware)
+	identityGroup.GET("", h.ListIdentities)
+	identityGroup.POST("", h.LinkIdentity)
+
+	g.POST("/me/reauthenticate", h.Reauthenticate, authMiddleware)
+
+	return h
+}
+
+// @Summary Login with single sign-on
+// @Description Redirects the browser to the OpenID Connect provider. After signing in there, the provider redirects back to /api/auth/oidc/callback. The optional device name is shown in the session list.
+// @Tags auth
+// @Param device query string false "Device name"
+// @Success 302
+// @Failure 404 {object} dto.ErrorResponse "Single sign-on is not configured"
+// @Router /api/auth/oidc/login [get]
+func (h *OIDCHandler) Login(c echo.Context) error {
+	req := new(dto.OIDCLoginRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	authURL, state, err := h.service.Begin(req.Device, nil)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	setOIDCStateCookie(c, state)
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// @Summary Complete a single sign-on
+// @Description The OpenID Connect provider redirects the browser here. Must be called by the browser that started the sign-in. Responds like /api/auth/login; if the sign-in was started with POST /api/me/identities or POST /api/me/reauthenticate, it responds with the identity used instead. An identity whose email address belongs to an existing account is not linked automatically: login with the password and link it first.
+// @Tags auth
+// @Param state query string true "State"
+// @Param code query string true "Authorization code"
+// @Success 200 {object} dto.LoginResponse
+// @Failure 400 {object} dto.ErrorResponse "Invalid or expired sign-in, or the provider rejected it"
+// @Failure 403 {object} dto.ErrorResponse "Account disabled, email address not verified or registration disabled"
+// @Failure 404 {object} dto.ErrorResponse "Single sign-on is not configured"
+// @Failure 409 {object} dto.ErrorResponse "The email address belongs to an account the identity is not linked to, or the identity is linked to another account or not to yours"
+// @Router /api/auth/oidc/callback [get]
+func (h *OIDCHandler) Callback(c echo.Context) error {
+	if !h.service.Enabled() {
+		return oidcError(c, services.ErrOIDCDisabled)
+	}
+	req := new(dto.OIDCCallbackRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	cookie, err := c.Cookie(oidcStateCookie)
+	clearOIDCStateCookie(c)
+	if req.Error != "" {
+		message := "The identity provider returned an error: " + req.Error
+		if req.ErrorDescription != "" {
+			message += " (" + req.ErrorDescription + ")"
+		}
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: message, StatusCode: http.StatusBadRequest})
+	}
+	if err != nil || req.State == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.State)) != 1 {
+		return oidcError(c, services.ErrInvalidOIDCState)
+	}
+
+	client := services.ClientInfo{UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
+	result, err := h.service.Complete(c.Request().Context(), req.State, req.Code, client)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	if result.Linked != nil {
+		return c.JSON(http.StatusOK, result.Linked)
+	}
+	if result.Reauthenticated != nil {
+		return c.JSON(http.StatusOK, result.Reauthenticated)
+	}
+	return c.JSON(http.StatusOK, result.Login)
+}
+
+// @Summary List your linked identities
+// @Tags me
+// @Security BearerAuth
+// @Success 200 {array} models.Identity
+// @Router /api/me/identities [get]
+func (h *OIDCHandler) ListIdentities(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	identities, err := h.service.ListIdentities(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, identities)
+}
+
+// @Summary Link a single sign-on identity
+// @Description Starts a sign-in at the OpenID Connect provider that links the identity to your account. Open the returned URL in the same browser; the callback responds with the linked identity. Can only be called with a login session.
+// @Tags me
+// @Security BearerAuth
+// @Success 200 {object} dto.OIDCAuthorizationResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse "Single sign-on is not configured"
+// @Router /api/me/identities [post]
+func (h *OIDCHandler) LinkIdentity(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	if _, ok := currentSession(c); !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "Identities can only be linked from a login session", StatusCode: http.StatusForbidden})
+	}
+
+	authURL, state, err := h.service.Begin("", user)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	setOIDCStateCookie(c, state)
+	return c.JSON(http.StatusOK, dto.OIDCAuthorizationResponse{AuthorizationURL: authURL})
+}
+
+// @Summary Reauthenticate with single sign-on
+// @Description Starts a sign-in at the OpenID Connect provider with an identity linked to your account. Open the returned URL in the same browser; the callback responds with the identity. For a few minutes afterwards, accounts created through single sign-on can change their password or delete the account from this session without the password they do not have. Can only be called with a login session.
+// @Tags me
+// @Security BearerAuth
+// @Success 200 {object} dto.OIDCAuthorizationResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse "Single sign-on is not configured"
+// @Router /api/me/reauthenticate [post]
+func (h *OIDCHandler) Reauthenticate(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	session, ok := currentSession(c)
+	if !ok {
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "Only login sessions can be reauthenticated", StatusCode: http.StatusForbidden})
+	}
+
+	authURL, state, err := h.service.BeginReauth(user, session)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	setOIDCStateCookie(c, state)
+	return c.JSON(http.StatusOK, dto.OIDCAuthorizationResponse{AuthorizationURL: authURL})
+}
+
+func oidcError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrOIDCDisabled):
+		return c.JSON(http.StatusNotFound, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusNotFound})
+	case errors.Is(err, services.ErrInvalidOIDCState), errors.Is(err, services.ErrOIDCFailed), errors.Is(err, services.ErrSessionNotFound):
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	case errors.Is(err, services.ErrAccountDisabled), errors.Is(err, services.ErrEmailNotVerified), errors.Is(err, services.ErrOIDCRegistrationDisabled):
+		return c.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusForbidden})
+	case errors.Is(err, services.ErrIdentityNotLinked), errors.Is(err, services.ErrIdentityLinked), errors.Is(err, services.ErrIdentityNotYours), errors.Is(err, services.ErrUserExists):
+		return c.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusConflict})
+	default:
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+}
+
+// setOIDCStateCookie remembers the state in the browser until the provider redirects back. The
+// cookie is Lax so that it is sent on that top-level redirect.
+func setOIDCStateCookie(c echo.Context, state string) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(services.OIDCStateTTL.Seconds()),
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearOIDCStateCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/auth/oidc",
+		MaxAge:   -1,
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"hackathon/config"
+	"hackathon/dto"
+	"hackathon/middleware"
+	"hackathon/models"
+	"hackathon/services"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination,checksum"
+	// statusChecksumMismatch is the tus checksum extension's "460 Checksum Mismatch".
+	statusChecksumMismatch = 460
+)
+
+// TusHandler serves resumable uploads following the tus 1.0 core protocol with the
+// creation, expiration, termination and checksum extensions. See https://tus.io/protocols/resumable-upload.
+type TusHandler struct {
+	service *services.UploadService
+	cfg     *config.Config
+}
+
+func NewTusHandler(g *echo.Group, s *services.UploadService, authMiddleware echo.MiddlewareFunc, cfg *config.Config) *TusHandler {
+	h := &TusHandler{service: s, cfg: cfg}
+
+	tusGroup := g.Group("/uploads/tus", h.tusHeaders)
+	tusGroup.OPTIONS("", h.Options)
+	canWrite := middleware.RequirePermission(models.PermissionFilesWrite)
+	tusGroup.POST("", h.Create, h.requireResumable, authMiddleware, canWrite, requireVerifiedEmail(cfg))
+	tusGroup.HEAD("/:id", h.Head, h.requireResumable, authMiddleware, canWrite)
+	tusGroup.PATCH("/:id", h.Patch, h.requireResumable, authMiddleware, canWrite)
+	tusGroup.DELETE("/:id", h.Terminate, h.requireResumable, authMiddleware, canWrite)
+
+	return h
+}
+
+// tusHeaders adds the Tus-Resumable header to every response.
+func (h *TusHandler) tusHeaders(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Tus-Resumable", tusVersion)
+		return next(c)
+	}
+}
+
+// requireResumable rejects requests made with a protocol version the server does not support.
+func (h *TusHandler) requireResumable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.Request().Header.Get("Tus-Resumable") != tusVersion {
+			c.Response().Header().Set("Tus-Version", tusVersion)
+			return c.JSON(http.StatusPreconditionFailed, dto.ErrorResponse{Message: "Unsupported Tus-Resumable version", StatusCode: http.StatusPreconditionFailed})
+		}
+		return next(c)
+	}
+}
+
+// @Summary Discover tus server capabilities
+// @Tags upload
+// @Success 204
+// @Header 204 {string} Tus-Version "Supported protocol versions"
+// @Header 204 {string} Tus-Extension "Supported extensions"
+// @Header 204 {integer} Tus-Max-Size "Largest accepted Upload-Length"
+// @Header 204 {string} Tus-Checksum-Algorithm "Supported checksum algorithms"
+// @Router /api/uploads/tus [options]
+func (h *TusHandler) Options(c echo.Context) error {
+	header := c.Response().Header()
+	header.Set("Tus-Version", tusVersion)
+	header.Set("Tus-Extension", tusExtensions)
+	header.Set("Tus-Max-Size", strconv.FormatInt(h.service.MaxSize(), 10))
+	header.Set("Tus-Checksum-Algorithm", strings.Join(services.SupportedChecksums, ","))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Create a resumable upload
+// @Security BearerAuth
+// @Tags upload
+// @Param Tus-Resumable header string true "Protocol version" default(1.0.0)
+// @Param Upload-Length header int true "Total size in bytes"
+// @Param Upload-Metadata header string false "Comma separated key/base64 value pairs, e.g. filename ZXhhbXBsZS5wbmc="
+// @Success 201
+// @Header 201 {string} Location "URL of the created upload"
+// @Header 201 {string} Upload-Expires "When the upload expires unless more bytes are received"
+// @Header 201 {integer} X-File-Id "ID of the stored file, set right away for an empty upload"
+// @Failure 413 {object} dto.ErrorResponse
+// @Failure 415 {object} dto.ErrorResponse "Empty upload of a type that is not allowed"
+// @Router /api/uploads/tus [post]
+func (h *TusHandler) Create(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	req := c.Request()
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid or missing Upload-Length", StatusCode: http.StatusBadRequest})
+	}
+	rawMetadata := req.Header.Get("Upload-Metadata")
+	metadata, err := parseUploadMetadata(rawMetadata)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid Upload-Metadata", StatusCode: http.StatusBadRequest})
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = "upload"
+	}
+
+	upload, err := h.service.Create(req.Context(), user.ID, length, filename, rawMetadata)
+	if err != nil {
+		return tusError(c, err)
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderLocation, strings.TrimSuffix(req.URL.Path, "/")+"/"+upload.ID)
+	setUploadHeaders(header, upload)
+	return c.NoContent(http.StatusCreated)
+}
+
+// @Summary Get the offset of a resumable upload
+// @Security BearerAuth
+// @Tags upload
+// @Param Tus-Resumable header string true "Protocol version" default(1.0.0)
+// @Param id path string true "Upload ID"
+// @Success 200
+// @Header 200 {integer} Upload-Offset "Bytes received so far"
+// @Header 200 {integer} Upload-Length "Total size in bytes"
+// @Header 200 {string} Upload-Expires "When the upload expires unless more bytes are received"
+// @Header 200 {integer} X-File-Id "ID of the stored file once the upload is complete"
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/uploads/tus/{id} [head]
+func (h *TusHandler) Head(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	upload, err := h.service.Get(user.ID, c.Param("id"))
+	if err != nil {
+		// HEAD responses have no body.
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	header := c.Response().Header()
+	header.Set("Cache-Control", "no-store")
+	setUploadHeaders(header, upload)
+	if upload.Metadata != "" {
+		header.Set("Upload-Metadata", upload.Metadata)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// @Summary Upload a chunk of a resumable upload
+// @Security BearerAuth
+// @Tags upload
+// @Accept application/offset+octet-stream
+// @Param Tus-Resumable header string true "Protocol version" default(1.0.0)
+// @Param id path string true "Upload ID"
+// @Param Upload-Offset header int true "Offset the chunk starts at"
+// @Param Upload-Checksum header string false "Checksum of the chunk, e.g. sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0="
+// @Success 204
+// @Header 204 {integer} Upload-Offset "Bytes received so far"
+// @Header 204 {string} Upload-Expires "When the upload expires unless more bytes are received"
+// @Header 204 {integer} X-File-Id "ID of the stored file once the upload is complete"
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 460 {object} dto.ErrorResponse
+// @Router /api/uploads/tus/{id} [patch]
+func (h *TusHandler) Patch(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	req := c.Request()
+	if req.Header.Get(echo.HeaderContentType) != "application/offset+octet-stream" {
+		return c.JSON(http.StatusUnsupportedMediaType, dto.ErrorResponse{Message: "Content-Type must be application/offset+octet-stream", StatusCode: http.StatusUnsupportedMediaType})
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid or missing Upload-Offset", StatusCode: http.StatusBadRequest})
+	}
+	var checksum *services.Checksum
+	if value := req.Header.Get("Upload-Checksum"); value != "" {
+		if checksum, err = parseUploadChecksum(value); err != nil {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid Upload-Checksum", StatusCode: http.StatusBadRequest})
+		}
+	}
+
+	upload, err := h.service.Append(req.Context(), user.ID, c.Param("id"), offset, req.Body, checksum)
+	if err != nil {
+		return tusError(c, err)
+	}
+	setUploadHeaders(c.Response().Header(), upload)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Cancel a resumable upload
+// @Security BearerAuth
+// @Tags upload
+// @Param Tus-Resumable header string true "Protocol version" default(1.0.0)
+// @Param id path string true "Upload ID"
+// @Success 204
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/uploads/tus/{id} [delete]
+func (h *TusHandler) Terminate(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	if err := h.service.Terminate(user.ID, c.Param("id")); err != nil {
+		return tusError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func setUploadHeaders(header http.Header, upload *models.Upload) {
+	header.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	header.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.FileID != nil {
+		header.Set("X-File-Id", strconv.FormatUint(uint64(*upload.FileID), 10))
+	} else {
+		header.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma separated pairs of a key and
+// an optional base64 encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			metadata[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			metadata[parts[0]] = string(value)
+		default:
+			return nil, errors.New("malformed metadata pair")
+		}
+	}
+	return metadata, nil
+}
+
+// parseUploadChecksum decodes the Upload-Checksum header: an algorithm and a base64 encoded digest.
+func parseUploadChecksum(header string) (*services.Checksum, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed checksum")
+	}
+	sum, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &services.Checksum{Algorithm: parts[0], Sum: sum}, nil
+}
+
+// tusError maps UploadService errors to the status codes defined by the tus protocol.
+func tusError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrOffsetMismatch), errors.Is(err, services.ErrUploadAlreadyDone):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrFileTooLarge), errors.Is(err, services.ErrUploadTooLong):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrChecksumMismatch):
+		status = statusChecksumMismatch
+	case errors.Is(err, services.ErrUnsupportedChecksum):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrInvalidType):
+		status = http.StatusUnsupportedMediaType
+	}
+	return c.JSON(status, dto.ErrorResponse{Message: err.Error(), StatusCode: status})
+}
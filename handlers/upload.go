@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"hackathon/config"
 	"hackathon/dto"
 	"hackathon/middleware"
+	"hackathon/models"
+	"hackathon/pkg/pagination"
 	"hackathon/repositories"
 	"hackathon/services"
+	"mime"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -17,13 +22,29 @@ type FileHandler struct {
 	cfg      *config.Config
 }
 
-func NewFileHandler(g *echo.Group, s services.FileService, userRepo repositories.UserRepository, cfg *config.Config) *FileHandler {
+func NewFileHandler(g *echo.Group, s services.FileService, userRepo repositories.UserRepository, authMiddleware echo.MiddlewareFunc, cfg *config.Config) *FileHandler {
 	h := &FileHandler{service: s, userRepo: userRepo, cfg: cfg}
+
+	canRead := middleware.RequirePermission(models.PermissionFilesRead)
+	canWrite := middleware.RequirePermission(models.PermissionFilesWrite)
+
 	uploadGroup := g.Group("/upload")
-	uploadGroup.Use(middleware.NewAuthMiddleware(userRepo, cfg.JWT.Secret))
+	uploadGroup.Use(authMiddleware)
+	uploadGroup.Use(canWrite)
+	uploadGroup.Use(requireVerifiedEmail(cfg))
 	uploadGroup.Use(middleware.BodySizeLimit(cfg.Storage.MaxSizeMB))
 	uploadGroup.POST("", h.Upload)
 
+	filesGroup := g.Group("/files")
+	filesGroup.Use(authMiddleware)
+	filesGroup.GET("", h.List, canRead)
+	filesGroup.GET("/:id", h.Download, canRead)
+	filesGroup.DELETE("/:id", h.Delete, canWrite)
+	filesGroup.POST("/:id/restore", h.Restore, canWrite)
+	filesGroup.GET("/:id/grants", h.ListGrants, canRead)
+	filesGroup.POST("/:id/grants", h.Grant, canWrite)
+	filesGroup.DELETE("/:id/grants/:user_id", h.RevokeGrant, canWrite)
+
 	return h
 }
 
@@ -34,11 +55,15 @@ func NewFileHandler(g *echo.Group, s services.FileService, userRepo repositories
 // @Success 200 {object} dto.UploadResponse
 // @Router /api/upload [post]
 func (h *FileHandler) Upload(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
 	file, err := c.FormFile("data")
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "File 'data' is required", StatusCode: http.StatusBadRequest})
 	}
-	metadata, err := h.service.UploadFile(file)
+	metadata, err := h.service.UploadFile(c.Request().Context(), file, user.ID)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
 	}
@@ -46,3 +71,245 @@ func (h *FileHandler) Upload(c echo.Context) error {
 		Message: "File uploaded successfully", Filename: metadata.Filename, ID: metadata.ID, ContentType: metadata.ContentType,
 	})
 }
+
+// @Summary List my files
+// @Description Cursor-paginated listing of the caller's files. Pass next_cursor from the previous page as cursor.
+// @Security BearerAuth
+// @Tags file
+// @Param content_type query string false "Exact content type"
+// @Param from query string false "Uploaded at or after (RFC 3339)"
+// @Param to query string false "Uploaded before (RFC 3339)"
+// @Param q query string false "Filename contains"
+// @Param trashed query bool false "List files in the trash instead"
+// @Param sort query string false "Sort key" Enums(name, size, uploaded_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param cursor query string false "Cursor from the previous page"
+// @Param limit query int false "Page size (max 100)"
+// @Success 200 {object} dto.Page[models.FileMetadata]
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /api/files [get]
+func (h *FileHandler) List(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	req := new(dto.ListFilesRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	page, err := h.service.ListFiles(user.ID, *req)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+		}
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Download file
+// @Description Streams the stored file. Supports Range/If-Range and conditional requests via ETag and Last-Modified.
+// @Security BearerAuth
+// @Tags file
+// @Produce octet-stream
+// @Param id path int true "File ID"
+// @Param Range header string false "Byte range, e.g. bytes=0-1023"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Success 304
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/files/{id} [get]
+func (h *FileHandler) Download(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid file ID", StatusCode: http.StatusBadRequest})
+	}
+
+	metadata, content, err := h.service.OpenFile(c.Request().Context(), id, user.ID)
+	if err != nil {
+		return fileError(c, err)
+	}
+	defer content.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, metadata.ContentType)
+	header.Set(echo.HeaderContentDisposition, contentDisposition(metadata.Filename))
+	header.Set("ETag", fileETag(metadata))
+	header.Set("Cache-Control", "private, no-cache")
+
+	// ServeContent takes care of Range, If-Range, If-None-Match, If-Modified-Since and HEAD requests.
+	http.ServeContent(c.Response(), c.Request(), metadata.Filename, metadata.UploadedAt, content)
+	return nil
+}
+
+// @Summary Move a file to the trash
+// @Description The file is hidden from every read path and purged for good after the retention window.
+// @Security BearerAuth
+// @Tags file
+// @Param id path int true "File ID"
+// @Success 204
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/files/{id} [delete]
+func (h *FileHandler) Delete(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid file ID", StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.service.DeleteFile(id, user.ID); err != nil {
+		return fileError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Restore a file from the trash
+// @Security BearerAuth
+// @Tags file
+// @Param id path int true "File ID"
+// @Success 200 {object} models.FileMetadata
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/files/{id}/restore [post]
+func (h *FileHandler) Restore(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid file ID", StatusCode: http.StatusBadRequest})
+	}
+
+	metadata, err := h.service.RestoreFile(id, user.ID)
+	if err != nil {
+		return fileError(c, err)
+	}
+	return c.JSON(http.StatusOK, metadata)
+}
+
+// @Summary List users a file is shared with
+// @Security BearerAuth
+// @Tags file
+// @Param id path int true "File ID"
+// @Success 200 {array} models.FileGrant
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/files/{id}/grants [get]
+func (h *FileHandler) ListGrants(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid file ID", StatusCode: http.StatusBadRequest})
+	}
+
+	grants, err := h.service.ListGrants(id, user.ID)
+	if err != nil {
+		return fileError(c, err)
+	}
+	return c.JSON(http.StatusOK, grants)
+}
+
+// @Summary Share a file with another user
+// @Security BearerAuth
+// @Tags file
+// @Param id path int true "File ID"
+// @Param req body dto.GrantRequest true "Grantee"
+// @Success 201 {object} map[string]string
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/files/{id}/grants [post]
+func (h *FileHandler) Grant(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid file ID", StatusCode: http.StatusBadRequest})
+	}
+	req := new(dto.GrantRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+	if err := c.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	}
+
+	grantee, err := h.userRepo.FindByUsername(req.Username)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "User not found", StatusCode: http.StatusNotFound})
+	}
+	if err := h.service.GrantAccess(id, user.ID, grantee.ID); err != nil {
+		return fileError(c, err)
+	}
+	return c.JSON(http.StatusCreated, echo.Map{"message": "Access granted"})
+}
+
+// @Summary Stop sharing a file with a user
+// @Security BearerAuth
+// @Tags file
+// @Param id path int true "File ID"
+// @Param user_id path int true "Grantee user ID"
+// @Success 204
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /api/files/{id}/grants/{user_id} [delete]
+func (h *FileHandler) RevokeGrant(c echo.Context) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to get user from context", StatusCode: http.StatusInternalServerError})
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid file ID", StatusCode: http.StatusBadRequest})
+	}
+	granteeID, err := parseIDParam(c, "user_id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID", StatusCode: http.StatusBadRequest})
+	}
+
+	if err := h.service.RevokeAccess(id, user.ID, granteeID); err != nil {
+		return fileError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// fileError maps FileService errors to HTTP responses.
+func fileError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrFileNotFound):
+		return c.JSON(http.StatusNotFound, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusNotFound})
+	case errors.Is(err, services.ErrGrantToOwner):
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusBadRequest})
+	default:
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error(), StatusCode: http.StatusInternalServerError})
+	}
+}
+
+// fileETag derives a strong validator from the content digest, or for files stored before
+// content addressing, from fields that never change once a file is stored.
+func fileETag(metadata *models.FileMetadata) string {
+	if metadata.Digest != "" {
+		return `"` + metadata.Digest + `"`
+	}
+	return fmt.Sprintf(`"%x-%x-%x"`, metadata.ID, metadata.Size, metadata.UploadedAt.UnixNano())
+}
+
+func contentDisposition(filename string) string {
+	if value := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); value != "" {
+		return value
+	}
+	return "attachment"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"hackathon/models"
+	"hackathon/services"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const keysUsage = `Usage: hackathon-app keys <command>
+
+Commands:
+  list                 List signing keys and their status
+  generate [ALG]       Create a pending key (ALG: ES256 (default), RS256 or EdDSA)
+  promote KID          Sign new tokens with the key; the current key keeps verifying
+  retire               Retire replaced keys whose tokens have all expired
+
+A rotation is: generate, wait until verifiers have refreshed the JWKS, promote, and run
+retire once the previous key's tokens have expired.
+`
+
+// runKeysCommand runs the signing key rotation command line and returns the exit code.
+func runKeysCommand(keys *services.KeyService, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		var records []models.SigningKey
+		if records, err = keys.List(); err == nil {
+			printKeys(records)
+		}
+	case "generate":
+		algorithm := "ES256"
+		if len(args) > 1 {
+			algorithm = args[1]
+		}
+		var record *models.SigningKey
+		if record, err = keys.Generate(algorithm); err == nil {
+			fmt.Printf("Generated %s key %s\n", record.Algorithm, record.ID)
+		}
+	case "promote":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, keysUsage)
+			return 2
+		}
+		var record *models.SigningKey
+		if record, err = keys.Promote(args[1]); err == nil {
+			fmt.Printf("Key %s is now active\n", record.ID)
+		}
+	case "retire":
+		var records []models.SigningKey
+		if records, err = keys.RetireExpired(); err == nil {
+			fmt.Printf("Retired %d key(s)\n", len(records))
+			printKeys(records)
+		}
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func printKeys(records []models.SigningKey) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSTATUS\tCREATED\tACTIVATED\tDEACTIVATED")
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", record.ID, record.Algorithm, record.Status,
+			record.CreatedAt.Format(time.RFC3339), formatTime(record.ActivatedAt), formatTime(record.DeactivatedAt))
+	}
+	w.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FileMailer writes every message to its own .eml file in a directory instead of sending it.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFile(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	f, err := os.CreateTemp(m.dir, now.Format("20060102-150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(encode(m.from, msg, now)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LogMailer logs messages, body included, instead of sending them.
+type LogMailer struct{}
+
+func NewLog() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Info().Str("to", msg.To).Str("subject", msg.Subject).Str("body", msg.Body).Msg("Mail not sent (log mailer)")
+	return nil
+}
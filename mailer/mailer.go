@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hackathon/config"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New creates the mailer selected by cfg.Driver. The file and log drivers do not deliver
+// anything and are meant for local development and tests.
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "", "log":
+		return NewLog(), nil
+	case "file":
+		return NewFile(cfg.FileDir, cfg.From)
+	case "smtp":
+		return NewSMTP(SMTPOptions{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		})
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// encode renders the message in RFC 5322 format.
+func encode(from string, msg Message, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// headerValue strips line breaks, which would let a value inject headers.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTP accepts a single SMTP session and records the envelope and data it receives.
+type fakeSMTP struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	f := &fakeSMTP{listener: listener, done: make(chan struct{})}
+	go f.serve()
+	t.Cleanup(func() { listener.Close() })
+	return f
+}
+
+func (f *fakeSMTP) serve() {
+	defer close(f.done)
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			f.from = strings.Trim(strings.TrimPrefix(command, "MAIL FROM:"), "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			f.to = append(f.to, strings.Trim(strings.TrimPrefix(command, "RCPT TO:"), "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			f.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTP_Send(t *testing.T) {
+	server := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	m, err := NewSMTP(SMTPOptions{Host: host, Port: portNumber, From: "Hackathon <no-reply@example.com>"})
+	assert.NoError(t, err)
+	err = m.Send(context.Background(), Message{To: "dev@example.com", Subject: "Hello", Body: "line one\nline two\n"})
+	assert.NoError(t, err)
+	<-server.done
+
+	assert.Equal(t, "no-reply@example.com", server.from)
+	assert.Equal(t, []string{"dev@example.com"}, server.to)
+	assert.Contains(t, server.data, "From: Hackathon <no-reply@example.com>\r\n")
+	assert.Contains(t, server.data, "To: dev@example.com\r\n")
+	assert.Contains(t, server.data, "Subject: Hello\r\n")
+	assert.True(t, strings.HasSuffix(server.data, "\r\n\r\nline one\r\nline two\r\n"))
+}
+
+func TestSMTP_RejectsInvalidAddresses(t *testing.T) {
+	_, err := NewSMTP(SMTPOptions{Host: "localhost", Port: 25, From: "not an address"})
+	assert.Error(t, err)
+
+	m, err := NewSMTP(SMTPOptions{Host: "localhost", Port: 25, From: "no-reply@example.com"})
+	assert.NoError(t, err)
+	assert.Error(t, m.Send(context.Background(), Message{To: "dev@example.com\r\nBcc: victim@example.com"}))
+}
+
+func TestFile_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFile(dir, "no-reply@example.com")
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Send(context.Background(), Message{To: "dev@example.com", Subject: "Réinitialisation", Body: "hello"}))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.True(t, strings.HasSuffix(entries[0].Name(), ".eml"))
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "To: dev@example.com\r\n")
+	assert.Contains(t, string(data), "Subject: =?utf-8?q?R=C3=A9initialisation?=\r\n")
+	assert.True(t, strings.HasSuffix(string(data), "\r\n\r\nhello"))
+}
@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPOptions configures the SMTP mailer. Username and Password are optional; STARTTLS is used
+// when the server offers it.
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer delivers mail through an SMTP relay.
+type SMTPMailer struct {
+	opts SMTPOptions
+	from string
+}
+
+func NewSMTP(opts SMTPOptions) (*SMTPMailer, error) {
+	if opts.Host == "" {
+		return nil, errors.New("smtp mailer: host is required")
+	}
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, errors.New("smtp mailer: invalid from address")
+	}
+	return &SMTPMailer{opts: opts, from: from.Address}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.opts.Username != "" {
+		auth = smtp.PlainAuth("", m.opts.Username, m.opts.Password, m.opts.Host)
+	}
+	addr := net.JoinHostPort(m.opts.Host, strconv.Itoa(m.opts.Port))
+	return smtp.SendMail(addr, auth, m.from, []string{to.Address}, encode(m.opts.From, msg, time.Now()))
+}
@@ -11,11 +11,17 @@ import (
 	"hackathon/config"
 	"hackathon/database"
 	"hackathon/handlers"
+	"hackathon/mailer"
 	"hackathon/middleware"
+	"hackathon/pkg/keyring"
 	"hackathon/pkg/logger"
+	"hackathon/pkg/oidc"
+	"hackathon/pkg/passhash"
+	"hackathon/pkg/pwned"
 	customValidator "hackathon/pkg/validator"
 	"hackathon/repositories"
 	"hackathon/services"
+	"hackathon/storage"
 
 	_ "hackathon/docs"
 	"github.com/labstack/echo/v4"
@@ -43,17 +49,134 @@ func main() {
 	database.Init(cfg.Database)
 	repos := repositories.NewRepository(database.DB)
 
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize storage")
+	}
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize mailer")
+	}
+
+	keys, err := keyring.Load(cfg.JWT.SigningKeyFile, cfg.JWT.SigningKeyID, []byte(cfg.JWT.Secret))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load JWT signing key")
+	}
+
 	allowedTypes := strings.Split(cfg.Storage.AllowedTypes, ",")
-	srv := services.NewService(repos, []byte(cfg.JWT.Secret), cfg.JWT.ExpirationHours, cfg.Storage.UploadDir, cfg.Storage.MaxSizeMB, allowedTypes)
+
+	switch cfg.Auth.LoginAttemptStore {
+	case "memory":
+		repos.LoginAttempt = repositories.NewMemoryLoginAttemptRepository()
+	case "postgres":
+	default:
+		log.Fatal().Str("store", cfg.Auth.LoginAttemptStore).Msg("Unknown login attempt store")
+	}
+
+	// Passwords hashed with the other scheme keep working and are rehashed on login.
+	argon2id := passhash.Argon2id{Memory: uint32(cfg.Auth.Argon2MemoryKiB), Iterations: uint32(cfg.Auth.Argon2Iterations), Parallelism: uint8(cfg.Auth.Argon2Parallelism)}
+	bcrypt := passhash.Bcrypt{Cost: cfg.Auth.BcryptCost}
+	var hasher *passhash.Hasher
+	switch cfg.Auth.PasswordHash {
+	case "argon2id":
+		hasher = passhash.New(argon2id, bcrypt)
+	case "bcrypt":
+		hasher = passhash.New(bcrypt, argon2id)
+	default:
+		log.Fatal().Str("scheme", cfg.Auth.PasswordHash).Msg("Unknown password hash scheme")
+	}
+
+	policy := services.PasswordPolicy{
+		MinLength:     cfg.Auth.PasswordMinLength,
+		MaxLength:     cfg.Auth.PasswordMaxLength,
+		MinClasses:    cfg.Auth.PasswordMinClasses,
+		AllowUsername: cfg.Auth.PasswordAllowUsername,
+	}
+	if cfg.Auth.BreachedPasswordsPath != "" {
+		policy.Breached, err = pwned.Open(cfg.Auth.BreachedPasswordsPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load breached password list")
+		}
+	}
+
+	lockout := time.Duration(cfg.Auth.LoginLockoutMinutes) * time.Minute
+	authOpts := services.AuthOptions{
+		AccessTTL:                  time.Duration(cfg.JWT.AccessTokenMinutes) * time.Minute,
+		RefreshTTL:                 time.Duration(cfg.JWT.RefreshTokenHours) * time.Hour,
+		LoginRequiresVerifiedEmail: cfg.Auth.LoginRequiresVerifiedEmail,
+		MFAIssuer:                  cfg.Auth.MFAIssuer,
+		Hasher:                     hasher,
+		PasswordPolicy:             policy,
+		UsernameThrottle:           services.ThrottlePolicy{FreeAttempts: cfg.Auth.LoginMaxAttempts / 2, MaxAttempts: cfg.Auth.LoginMaxAttempts, Lockout: lockout},
+		IPThrottle:                 services.ThrottlePolicy{FreeAttempts: cfg.Auth.LoginIPMaxAttempts / 2, MaxAttempts: cfg.Auth.LoginIPMaxAttempts, Lockout: lockout},
+		MailThrottle:               services.ThrottlePolicy{FreeAttempts: cfg.Auth.MailMaxRequests, MaxAttempts: cfg.Auth.MailMaxRequests, Lockout: lockout},
+		MailIPThrottle:             services.ThrottlePolicy{FreeAttempts: cfg.Auth.MailIPMaxRequests, MaxAttempts: cfg.Auth.MailIPMaxRequests, Lockout: lockout},
+	}
+
+	var oidcProvider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		oidcProvider, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       strings.Fields(cfg.OIDC.Scopes),
+			HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		})
+		cancel()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to discover OpenID provider")
+		}
+	}
+
+	srv := services.NewService(repos, keys, cfg.JWT.KeysDir, authOpts,
+		store, cfg.Storage.MaxSizeMB, allowedTypes,
+		cfg.Storage.TusStagingDir, time.Duration(cfg.Storage.TusExpiryHours)*time.Hour,
+		cfg.Storage.ExportSyncMaxMB, time.Duration(cfg.Storage.ExportRetentionHours)*time.Hour,
+		oidcProvider, services.OIDCOptions{AutoRegister: cfg.OIDC.AutoRegister},
+		mail, cfg.Mail.PasswordResetURL, cfg.Mail.EmailVerificationURL)
+
+	if len(os.Args) > 1 {
+		code := runCommand(srv, os.Args[1:])
+		database.Close()
+		os.Exit(code)
+	}
+	if err := srv.Keys.Reload(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load signing keys")
+	}
 
 	e := echo.New()
+	if cfg.Server.BehindProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
 	e.Validator = customValidator.NewCustomValidator()
 	e.Use(middleware.RequestLogger())
 	e.Use(echoMiddleware.Recover())
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
+	handlers.NewJWKSHandler(e.Group("/.well-known"), keys)
+
+	handlers.NewHandler(e.Group("/api"), srv, cfg, repos, keys).RegisterRoutes()
 
-	handlers.NewHandler(e.Group("/api"), srv, cfg, repos).RegisterRoutes()
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	if purgeInterval := time.Duration(cfg.Storage.PurgeIntervalMinutes) * time.Minute; purgeInterval > 0 {
+		go srv.File.RunPurger(bgCtx, time.Duration(cfg.Storage.TrashRetentionHours)*time.Hour, purgeInterval)
+		go srv.Export.RunPurger(bgCtx, purgeInterval)
+	} else {
+		log.Warn().Msg("Purging is disabled: trashed files and expired exports are kept")
+	}
+	go srv.Throttle.RunPruner(bgCtx, time.Hour)
+	go srv.Upload.RunPruner(bgCtx, time.Hour)
+	if srv.OIDC.Enabled() {
+		go srv.OIDC.RunPruner(bgCtx, time.Hour)
+	}
+	if srv.Keys.Enabled() {
+		go srv.Keys.RunReloader(bgCtx, services.KeyReloadInterval)
+	}
 
 	go func() {
 		log.Info().Str("port", cfg.Server.Port).Msg("Server started")
@@ -65,6 +188,7 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -1,62 +1,108 @@
 package middleware
 
 import (
-	"hackathon/repositories"
-	"hackathon/services"
+	"errors"
+	"hackathon/models"
 	"net/http"
-	"strconv"
+	"slices"
+	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
-	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 )
 
-func NewAuthMiddleware(userRepo repositories.UserRepository, secret string) echo.MiddlewareFunc {
-	jwtConfig := echojwt.Config{
-		NewClaimsFunc: func(c echo.Context) jwt.Claims { return new(services.JwtCustomClaims) },
-		SigningKey:    []byte(secret),
-		ErrorHandler: func(c echo.Context, err error) error {
-			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Missing or invalid token"})
-		},
-	}
+// ErrNotHandled is returned by an Authenticator for tokens of a kind it does not handle, so the
+// next authenticator of the chain gets a chance.
+var ErrNotHandled = errors.New("token not handled by this authenticator")
+
+// Principal is who a request is made by.
+type Principal struct {
+	User *models.User
+	// Session is the login session of the access token, nil for other kinds of credentials.
+	Session     *models.Session
+	Permissions []string
+}
 
-	jwtMiddleware := echojwt.WithConfig(jwtConfig)
+// Authenticator resolves a bearer token to a principal. Rejections are *echo.HTTPError values
+// whose message is sent to the client.
+type Authenticator interface {
+	Authenticate(c echo.Context, token string) (*Principal, error)
+}
 
+// NewAuthMiddleware authenticates the bearer token of the request with the first authenticator
+// that handles it, and stores the principal in the context.
+func NewAuthMiddleware(authenticators ...Authenticator) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
-
-		return jwtMiddleware(func(c echo.Context) error {
-			token, ok := c.Get("user").(*jwt.Token)
-			if !ok {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid token format"})
+		return func(c echo.Context) error {
+			token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !ok || token == "" {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Missing or invalid token"})
 			}
 
-			claims, ok := token.Claims.(*services.JwtCustomClaims)
-			if !ok {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid token claims"})
-			}
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(c, token)
+				if errors.Is(err, ErrNotHandled) {
+					continue
+				}
+				if err != nil {
+					var httpErr *echo.HTTPError
+					if errors.As(err, &httpErr) {
+						return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+					}
+					return err
+				}
 
-			userID, err := strconv.ParseUint(claims.ID, 10, 32)
-			if err != nil {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid user ID in token"})
+				c.Set("user", principal.User)
+				if principal.Session != nil {
+					c.Set("session", principal.Session)
+				}
+				c.Set("permissions", principal.Permissions)
+				return next(c)
 			}
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Missing or invalid token"})
+		}
+	}
+}
 
-			user, err := userRepo.FindByID(uint(userID))
-			if err != nil {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "User not found"})
+// RequirePermission only lets requests through whose credentials carry every given
+// permission. It must run after the auth middleware.
+func RequirePermission(permissions ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			granted, _ := c.Get("permissions").([]string)
+			for _, permission := range permissions {
+				if !slices.Contains(granted, permission) {
+					return c.JSON(http.StatusForbidden, echo.Map{"error": "Insufficient permissions"})
+				}
 			}
+			return next(c)
+		}
+	}
+}
 
-			issuedAt, err := claims.GetIssuedAt()
-			if err != nil {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid token timestamp"})
+// RequireVerifiedEmail only lets users with a verified email address through. It must run after
+// the auth middleware.
+func RequireVerifiedEmail() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get("user").(*models.User)
+			if !ok || !user.EmailVerified {
+				return c.JSON(http.StatusForbidden, echo.Map{"error": "Email address not verified"})
 			}
+			return next(c)
+		}
+	}
+}
 
-			if user.RevokeTokensBefore > 0 && issuedAt.Time.Unix() < user.RevokeTokensBefore {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Token has been revoked, please login again"})
+// RequireLoginSession only lets requests through that are made with the access token of a login
+// session, so personal access tokens cannot manage the account. It must run after the auth
+// middleware.
+func RequireLoginSession() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, ok := c.Get("session").(*models.Session); !ok {
+				return c.JSON(http.StatusForbidden, echo.Map{"error": "Only allowed from a login session"})
 			}
-
-			c.Set("user", user)
-
 			return next(c)
-		})
+		}
 	}
 }
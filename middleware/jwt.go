@@ -1 +1,67 @@
 package middleware
+
+import (
+	"hackathon/pkg/keyring"
+	"hackathon/repositories"
+	"hackathon/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// sessionTouchInterval throttles how often the last seen time of a session is written.
+const sessionTouchInterval = time.Minute
+
+// JWTAuthenticator authenticates the access tokens issued at login. The token must belong to a
+// session that is still active.
+type JWTAuthenticator struct {
+	userRepo    repositories.UserRepository
+	sessionRepo repositories.SessionRepository
+	keys        *keyring.Keyring
+}
+
+func NewJWTAuthenticator(userRepo repositories.UserRepository, sessionRepo repositories.SessionRepository, keys *keyring.Keyring) *JWTAuthenticator {
+	return &JWTAuthenticator{userRepo: userRepo, sessionRepo: sessionRepo, keys: keys}
+}
+
+func (a *JWTAuthenticator) Authenticate(c echo.Context, token string) (*Principal, error) {
+	claims := new(services.JwtCustomClaims)
+	if _, err := jwt.ParseWithClaims(token, claims, a.keys.Keyfunc, jwt.WithExpirationRequired()); err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Missing or invalid token")
+	}
+
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid user ID in token")
+	}
+
+	user, err := a.userRepo.FindByID(uint(userID))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+	}
+	if user.Disabled {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "Account is disabled")
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid token timestamp")
+	}
+
+	if user.RevokeTokensBefore > 0 && issuedAt.Time.Unix() < user.RevokeTokensBefore {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Token has been revoked, please login again")
+	}
+
+	session, err := a.sessionRepo.FindByID(claims.ID)
+	if err != nil || session.UserID != user.ID || session.RevokedAt != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Session has ended, please login again")
+	}
+	if now := time.Now(); now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		_ = a.sessionRepo.Touch(session, now) // Best effort, the request is already authenticated
+	}
+
+	return &Principal{User: user, Session: session, Permissions: claims.Permissions}, nil
+}
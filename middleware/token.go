@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"errors"
+	"hackathon/services"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PersonalTokenAuthenticator authenticates personal access tokens, recognised by their prefix.
+type PersonalTokenAuthenticator struct {
+	tokens *services.TokenService
+}
+
+func NewPersonalTokenAuthenticator(tokens *services.TokenService) *PersonalTokenAuthenticator {
+	return &PersonalTokenAuthenticator{tokens: tokens}
+}
+
+func (a *PersonalTokenAuthenticator) Authenticate(c echo.Context, token string) (*Principal, error) {
+	if !strings.HasPrefix(token, services.PersonalTokenPrefix) {
+		return nil, ErrNotHandled
+	}
+
+	user, permissions, err := a.tokens.Authenticate(token)
+	if err != nil {
+		if errors.Is(err, services.ErrAccountDisabled) {
+			return nil, echo.NewHTTPError(http.StatusForbidden, "Account is disabled")
+		}
+		if errors.Is(err, services.ErrInvalidAccessToken) {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid, expired or revoked access token")
+		}
+		return nil, err
+	}
+	return &Principal{User: user, Permissions: permissions}, nil
+}
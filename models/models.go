@@ -1,20 +1,285 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
-	ID                 uint   `gorm:"primaryKey" json:"id"`
-	Username           string `gorm:"unique;not null" json:"username"`
-	Password           string `gorm:"not null" json:"-"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Username string `gorm:"unique;not null" json:"username"`
+	Password string `gorm:"not null" json:"-"`
+	// Email is stored lowercased and unique regardless of case. Accounts created before email
+	// addresses were collected have none.
+	Email         *string `gorm:"type:text;uniqueIndex:idx_users_email,expression:lower(email)" json:"email"`
+	EmailVerified bool    `gorm:"not null;default:false" json:"email_verified"`
+	// DisplayName and AvatarFileID are edited by the user. The avatar is one of the user's files.
+	DisplayName        string `gorm:"type:text;not null;default:''" json:"display_name"`
+	AvatarFileID       *uint  `json:"avatar_file_id"`
 	RevokeTokensBefore int64  `gorm:"type:integer" json:"revoke_tokens_before"`
+	// Disabled accounts cannot login and their tokens are rejected.
+	Disabled bool `gorm:"not null;default:false" json:"disabled"`
+	// PasswordResetRequired blocks login until the password has been reset.
+	PasswordResetRequired bool `gorm:"not null;default:false" json:"password_reset_required"`
+	// NoPassword marks accounts created through single sign-on, whose random password nobody
+	// knows. They confirm sensitive changes by signing in again instead, until they set a password.
+	NoPassword bool `gorm:"not null;default:false" json:"-"`
+	// MFAEnabled requires a TOTP or recovery code after the password. TOTPSecret is set at
+	// enrollment, before the first code confirms it; TOTPLastStep is the time step of the last
+	// accepted code, which cannot be used again.
+	MFAEnabled   bool   `gorm:"not null;default:false" json:"mfa_enabled"`
+	TOTPSecret   string `gorm:"type:text" json:"-"`
+	TOTPLastStep int64  `gorm:"not null;default:0" json:"-"`
+	// AnonymizedAt is set when the user deleted their account. The row is kept, disabled and
+	// stripped of personal data, so IDs stay unique.
+	AnonymizedAt *time.Time `json:"anonymized_at,omitempty"`
+	Roles        []Role     `gorm:"many2many:user_roles" json:"roles,omitempty"`
+}
+
+// Permissions known to the application. They are granted to users through roles and carried
+// in the access token.
+const (
+	PermissionFilesRead  = "files:read"
+	PermissionFilesWrite = "files:write"
+	PermissionUsersAdmin = "users:admin"
+)
+
+// Built-in roles, seeded at startup. New users get the user role.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// BuiltinRoles maps the seeded roles to the permissions they grant.
+var BuiltinRoles = map[string][]string{
+	RoleAdmin: {PermissionFilesRead, PermissionFilesWrite, PermissionUsersAdmin},
+	RoleUser:  {PermissionFilesRead, PermissionFilesWrite},
+}
+
+type Role struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	Name        string       `gorm:"unique;not null" json:"name"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions,omitempty"`
+}
+
+type Permission struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `gorm:"unique;not null" json:"name"`
+}
+
+// Session is created on every login. Its ID is carried in the jti claim of the access tokens
+// issued for it, so a single device can be signed out by revoking its session.
+type Session struct {
+	ID         string     `gorm:"primaryKey;type:text" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Device     string     `gorm:"type:text" json:"device"`
+	UserAgent  string     `gorm:"type:text" json:"user_agent"`
+	IP         string     `gorm:"type:text" json:"ip"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	// ReauthenticatedAt is when the user last confirmed their identity for this session by signing
+	// in again through a linked single sign-on identity.
+	ReauthenticatedAt *time.Time `json:"-"`
+}
+
+// PersonalAccessToken is a long-lived token for scripts and CI jobs. Only a SHA-256 hash of the
+// token is stored; Prefix is kept so users can tell their tokens apart. Scopes is a comma separated
+// list of permissions, further limited to the permissions the user has when the token is used.
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"type:text;not null" json:"name"`
+	Prefix     string     `gorm:"type:text;not null" json:"prefix"`
+	TokenHash  string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"type:text;not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// RefreshToken is an opaque, single-use token that is exchanged for a new access token and a new
+// refresh token. Only a SHA-256 hash of the token is stored. Every token issued from the same
+// login shares a FamilyID, which is the ID of that login's Session; presenting a token that was
+// already used revokes the whole family.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	FamilyID  string     `gorm:"type:text;not null;index" json:"family_id"`
+	TokenHash string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// MFAChallenge is issued instead of tokens when a user with two-factor authentication logs in
+// with their password. The session is started once a code is verified against it. Only a SHA-256
+// hash of the challenge token is stored; it is single-use and allows a few attempts.
+type MFAChallenge struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	Device    string     `gorm:"type:text" json:"device"`
+	UserAgent string     `gorm:"type:text" json:"user_agent"`
+	IP        string     `gorm:"type:text" json:"ip"`
+	Attempts  int        `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// RecoveryCode replaces a TOTP code once, for users who lost their device. Only a SHA-256 hash
+// of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"type:text;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// LoginAttempt counts the consecutive failed logins of a key: a username or a client IP.
+type LoginAttempt struct {
+	Key           string    `gorm:"primaryKey;type:text" json:"key"`
+	Failures      int       `gorm:"not null" json:"failures"`
+	LastFailureAt time.Time `gorm:"not null;index" json:"last_failure_at"`
+}
+
+// Identity links a user to an account at an external OpenID provider, identified by the issuer
+// URL of the provider and the subject it assigned.
+type Identity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Issuer      string     `gorm:"type:text;not null;uniqueIndex:idx_identities_issuer_subject" json:"issuer"`
+	Subject     string     `gorm:"type:text;not null;uniqueIndex:idx_identities_issuer_subject" json:"subject"`
+	Email       *string    `gorm:"type:text" json:"email"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// OIDCLoginState remembers a sign-in at an external OpenID provider until the provider redirects
+// back. Only a SHA-256 hash of the state parameter is stored; the nonce and the PKCE verifier are
+// checked against the tokens of the provider. UserID is set when a signed in user links a new
+// identity instead of signing in, and SessionID as well when they reauthenticate that session
+// with an identity already linked. The state can be used once, before it expires.
+type OIDCLoginState struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	StateHash string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	Nonce     string     `gorm:"type:text;not null" json:"-"`
+	Verifier  string     `gorm:"type:text;not null" json:"-"`
+	UserID    *uint      `gorm:"index" json:"user_id"`
+	SessionID *string    `gorm:"type:text" json:"-"`
+	Device    string     `gorm:"type:text" json:"device"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (OIDCLoginState) TableName() string { return "oidc_login_states" }
+
+// PasswordResetToken is sent by mail to let a user choose a new password. Only a SHA-256 hash of
+// the token is stored and it can be used once, before it expires.
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
 }
 
 type FileMetadata struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
+	OwnerID     uint      `gorm:"index" json:"owner_id"`
 	Filename    string    `gorm:"type:text" json:"filename"`
 	Size        int64     `gorm:"type:integer" json:"size"`
 	ContentType string    `gorm:"type:text" json:"content_type"`
+	Digest      string    `gorm:"type:text;index" json:"digest"`
 	UploadedAt  time.Time `gorm:"autoCreateTime" json:"uploaded_at"`
+	// DeletedAt is set when the file is moved to the trash. Trashed files are hidden from every
+	// query and purged for good once the retention window has passed.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at" swaggertype:"string" format:"date-time"`
 }
 
 func (FileMetadata) TableName() string { return "file_metadata" }
+
+// FileGrant gives a user other than the owner read access to a file.
+type FileGrant struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	FileID    uint      `gorm:"not null;uniqueIndex:idx_file_grants_file_user" json:"file_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_file_grants_file_user;index" json:"user_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Blob is a piece of content stored once under its SHA-256 digest and shared by every
+// FileMetadata with the same Digest. RefCount is the number of such files; the content is
+// deleted from storage when it drops to zero.
+type Blob struct {
+	Digest    string    `gorm:"primaryKey;type:text" json:"digest"`
+	Size      int64     `gorm:"type:bigint" json:"size"`
+	RefCount  int64     `gorm:"not null;default:0" json:"ref_count"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Upload is a resumable (tus) upload in progress. Received bytes are staged on local disk;
+// Offset is how many of the Length bytes have been received. FileID is set once the upload
+// is complete and has been turned into a FileMetadata. The upload and its staged bytes are deleted
+// once ExpiresAt has passed, which each received chunk moves forward.
+type Upload struct {
+	ID        string    `gorm:"primaryKey;type:text" json:"id"`
+	OwnerID   uint      `gorm:"not null;index" json:"owner_id"`
+	Filename  string    `gorm:"type:text" json:"filename"`
+	Metadata  string    `gorm:"type:text" json:"-"`
+	Length    int64     `gorm:"not null" json:"length"`
+	Offset    int64     `gorm:"column:upload_offset;not null;default:0" json:"offset"`
+	FileID    *uint     `json:"file_id"`
+	ExpiresAt time.Time `gorm:"not null;default:now();index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Export job states. A job is pending until a worker picks it up and ends up done or failed.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportJob builds a ZIP archive of a user's data in the background. The archive of a done job is
+// stored under exports/<ID>.zip; the job and its archive are deleted once ExpiresAt has passed.
+type ExportJob struct {
+	ID          string     `gorm:"primaryKey;type:text" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Status      string     `gorm:"type:text;not null" json:"status"`
+	Size        int64      `gorm:"not null;default:0" json:"size"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"`
+}
+
+// Signing key states. A pending key is published in the JWKS but not used yet, so verifiers can
+// fetch it before the first token signed with it shows up. Exactly one key is active. A key that
+// was replaced keeps verifying until the tokens it signed have expired, after which it is retired.
+const (
+	SigningKeyPending = "pending"
+	SigningKeyActive  = "active"
+	SigningKeyVerify  = "verify"
+	SigningKeyRetired = "retired"
+)
+
+// SigningKey records the state of a JWT signing key. The private key itself is a PEM file
+// named after the key ID in the keys directory.
+type SigningKey struct {
+	ID            string     `gorm:"primaryKey;type:text" json:"id"`
+	Algorithm     string     `gorm:"type:text;not null" json:"algorithm"`
+	Status        string     `gorm:"type:text;not null;index" json:"status"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	ActivatedAt   *time.Time `json:"activated_at"`
+	DeactivatedAt *time.Time `json:"deactivated_at"`
+	RetiredAt     *time.Time `json:"retired_at"`
+}
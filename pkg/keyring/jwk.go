@@ -0,0 +1,159 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// JWK is the public part of a signing key as a JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public key as a JSON Web Key. It returns false for symmetric keys.
+func (k *Key) JWK() (JWK, bool) {
+	jwk, ok := publicJWK(k.verifyKey)
+	if !ok {
+		return JWK{}, false
+	}
+	jwk.Use = "sig"
+	jwk.Alg = k.Algorithm
+	jwk.Kid = k.ID
+	return jwk, true
+}
+
+// PublicKey decodes the public key of a JWK published by someone else, e.g. an OpenID provider.
+// Like signing keys, it must be RSA, ECDSA P-256 or Ed25519.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch {
+	case j.Kty == "RSA":
+		n, err := decodeInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA public exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case j.Kty == "EC" && j.Crv == "P-256":
+		x, err := decodeInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the P-256 curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// publicJWK fills in the key type specific members of a JWK.
+func publicJWK(public interface{}) (JWK, bool) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: encodeInt(pub.N, 0), E: encodeInt(big.NewInt(int64(pub.E)), 0)}, true
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return JWK{Kty: "EC", Crv: pub.Curve.Params().Name, X: encodeInt(pub.X, size), Y: encodeInt(pub.Y, size)}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}, true
+	default:
+		return JWK{}, false
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint: the SHA-256 of the required members of the
+// key, serialized in lexicographic order.
+func thumbprint(public crypto.PublicKey) (string, error) {
+	jwk, ok := publicJWK(public)
+	if !ok {
+		return "", ErrUnsupportedKey
+	}
+
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// encodeInt encodes an unsigned integer as base64url, left-padded with zeros to size bytes.
+func encodeInt(n *big.Int, size int) string {
+	data := n.Bytes()
+	if len(data) < size {
+		data = append(make([]byte, size-len(data)), data...)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,229 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey        = errors.New("unknown signing key")
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match its key")
+	ErrUnsupportedKey    = errors.New("unsupported key type, use RSA, ECDSA P-256 or Ed25519")
+)
+
+// Key is a JWT signing key. Asymmetric keys are identified by the RFC 7638 thumbprint of their
+// public key unless an explicit ID is given; the ID is carried in the kid header of every token.
+type Key struct {
+	ID        string
+	Algorithm string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey returns an HS256 key. HMAC keys can only be used inside this service and are never
+// published in the JWKS.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: jwt.SigningMethodHS256.Alg(), signKey: secret, verifyKey: secret}
+}
+
+// ParsePEM reads a PEM encoded private key (PKCS #8, PKCS #1 or SEC 1). The signing algorithm
+// follows from the key type: RS256 for RSA, ES256 for ECDSA P-256 and EdDSA for Ed25519.
+func ParsePEM(data []byte, id string) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q, a private key is required", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id, signKey: private}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+		key.verifyKey = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedKey
+		}
+		key.Algorithm = jwt.SigningMethodES256.Alg()
+		key.verifyKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Algorithm = jwt.SigningMethodEdDSA.Alg()
+		key.verifyKey = k.Public()
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	if key.ID == "" {
+		key.ID, err = thumbprint(key.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// LoadPEMFile reads a private key from a PEM file, see ParsePEM.
+func LoadPEMFile(path, id string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePEM(data, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Keyring holds the key new tokens are signed with and the keys tokens are verified with.
+// It is safe for concurrent use, and its keys can be replaced while tokens are being issued.
+type Keyring struct {
+	mu     sync.RWMutex
+	active *Key
+	keys   map[string]*Key
+}
+
+// New returns a keyring that signs with active and also accepts tokens signed with the
+// verification keys.
+func New(active *Key, verification ...*Key) *Keyring {
+	r := &Keyring{}
+	r.Replace(active, verification...)
+	return r
+}
+
+// Replace swaps the keys of the keyring.
+func (r *Keyring) Replace(active *Key, verification ...*Key) {
+	keys := map[string]*Key{active.ID: active}
+	for _, key := range verification {
+		if _, ok := keys[key.ID]; !ok {
+			keys[key.ID] = key
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = active
+	r.keys = keys
+}
+
+// Load builds the keyring from configuration. Tokens are signed with the private key in
+// signingKeyFile when one is set, and with the shared HS256 secret otherwise.
+func Load(signingKeyFile, signingKeyID string, secret []byte) (*Keyring, error) {
+	if signingKeyFile == "" {
+		if signingKeyID == "" {
+			signingKeyID = "hs256"
+		}
+		return New(NewHMACKey(signingKeyID, secret)), nil
+	}
+	key, err := LoadPEMFile(signingKeyFile, signingKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return New(key), nil
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (r *Keyring) Sign(claims jwt.Claims) (string, error) {
+	active := r.Active()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(active.Algorithm), claims)
+	token.Header["kid"] = active.ID
+	return token.SignedString(active.signKey)
+}
+
+// Active returns the key new tokens are signed with.
+func (r *Keyring) Active() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+// Keyfunc returns the key a token must be verified with, selected by its kid header. Tokens
+// without a kid predate key IDs and are checked against the active key. The algorithm of the
+// token has to match the key, so a public key can never be used as an HMAC secret.
+func (r *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key := r.active
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = r.keys[kid]
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys of the keyring as a JSON Web Key Set.
+func (r *Keyring) JWKS() JWKSet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range r.keys {
+		if jwk, ok := key.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// Generate creates a new private key for the algorithm (RS256, ES256 or EdDSA) and returns it
+// together with its PKCS #8 PEM encoding.
+func Generate(algorithm string) (*Key, []byte, error) {
+	var private interface{}
+	var err error
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported algorithm %q, use RS256, ES256 or EdDSA", algorithm)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	key, err := ParsePEM(data, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, data, nil
+}
@@ -0,0 +1,140 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func pkcs8PEM(t *testing.T, private interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func testClaims() jwt.Claims {
+	return jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func TestKeyring_SignAndVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name      string
+		pem       []byte
+		algorithm string
+		kty       string
+	}{
+		{"RSA PKCS #1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), "RS256", "RSA"},
+		{"RSA PKCS #8", pkcs8PEM(t, rsaKey), "RS256", "RSA"},
+		{"ECDSA P-256", pkcs8PEM(t, ecKey), "ES256", "EC"},
+		{"Ed25519", pkcs8PEM(t, edKey), "EdDSA", "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePEM(tt.pem, "")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.algorithm, key.Algorithm)
+			assert.NotEmpty(t, key.ID, "the ID defaults to the key thumbprint")
+
+			ring := New(key)
+			signed, err := ring.Sign(testClaims())
+			assert.NoError(t, err)
+
+			token, err := jwt.Parse(signed, ring.Keyfunc)
+			assert.NoError(t, err)
+			assert.Equal(t, key.ID, token.Header["kid"])
+			assert.Equal(t, tt.algorithm, token.Method.Alg())
+
+			set := ring.JWKS()
+			assert.Len(t, set.Keys, 1)
+			assert.Equal(t, key.ID, set.Keys[0].Kid)
+			assert.Equal(t, tt.kty, set.Keys[0].Kty)
+			assert.Equal(t, tt.algorithm, set.Keys[0].Alg)
+		})
+	}
+}
+
+func TestKeyring_Keyfunc(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := ParsePEM(pkcs8PEM(t, rsaKey), "primary")
+	assert.NoError(t, err)
+	ring := New(key)
+
+	t.Run("unknown kid", func(t *testing.T) {
+		other := New(NewHMACKey("other", []byte("secret")))
+		signed, err := other.Sign(testClaims())
+		assert.NoError(t, err)
+
+		_, err = jwt.Parse(signed, ring.Keyfunc)
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+
+	t.Run("public key used as an HMAC secret", func(t *testing.T) {
+		der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+		assert.NoError(t, err)
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+		forged.Header["kid"] = "primary"
+		signed, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		assert.NoError(t, err)
+
+		_, err = jwt.Parse(signed, ring.Keyfunc)
+		assert.ErrorIs(t, err, ErrAlgorithmMismatch)
+	})
+
+	t.Run("HMAC keys are not published", func(t *testing.T) {
+		assert.Empty(t, New(NewHMACKey("hs256", []byte("secret"))).JWKS().Keys)
+	})
+}
+
+func TestParsePEM_UnsupportedCurve(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, err := ParsePEM(pkcs8PEM(t, ecKey), "")
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+}
+
+func TestThumbprint(t *testing.T) {
+	// Example from RFC 7638, section 3.1.
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	kid, err := thumbprint(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	assert.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", kid)
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, _, err := Generate(algorithm)
+			assert.NoError(t, err)
+			jwk, ok := key.JWK()
+			assert.True(t, ok)
+
+			public, err := jwk.PublicKey()
+			assert.NoError(t, err)
+			signed, err := New(key).Sign(testClaims())
+			assert.NoError(t, err)
+			_, err = jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return public, nil })
+			assert.NoError(t, err, "the decoded key verifies tokens of the original")
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := JWK{Kty: "oct"}.PublicKey()
+		assert.ErrorIs(t, err, ErrUnsupportedKey)
+		_, err = JWK{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}.PublicKey()
+		assert.Error(t, err, "the point must be on the curve")
+	})
+}
@@ -0,0 +1,365 @@
+// Package oidc implements the relying party side of OpenID Connect: provider discovery, the
+// authorization code flow with PKCE (RFC 7636) and the verification of ID tokens against the
+// keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hackathon/pkg/keyring"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrUnknownKey     = errors.New("ID token signed with an unknown key")
+)
+
+const (
+	// maxResponseSize limits the documents read from the provider.
+	maxResponseSize = 1 << 20
+	// keysRefreshInterval is how often the JWKS may be fetched again for a token signed with an
+	// unknown key, so forged kids cannot make us hammer the provider.
+	keysRefreshInterval = time.Minute
+	// leeway allows for clock skew between us and the provider.
+	leeway = time.Minute
+)
+
+// supportedAlgorithms are the ID token signing algorithms accepted, if the provider supports them.
+var supportedAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// Config identifies the provider and this application as a client registered with it.
+type Config struct {
+	// Issuer is the issuer URL of the provider; the discovery document is read from
+	// <Issuer>/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider.
+	RedirectURL string
+	Scopes      []string
+	// HTTPClient is used for every request to the provider, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Discovery is the part of the provider metadata (OpenID Connect Discovery 1.0) that is used.
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Token is the response of the token endpoint.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Error is an OAuth 2.0 error returned by the provider.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oidc: " + e.Code
+	}
+	return "oidc: " + e.Code + ": " + e.Description
+}
+
+// Claims are the verified claims of an ID token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     Bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// Bool is a boolean claim. Some providers send booleans as the strings "true" and "false".
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `true`, `"true"`:
+		*b = true
+	case `false`, `"false"`, `null`:
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// Provider is an OpenID provider found through discovery. It is safe for concurrent use.
+type Provider struct {
+	cfg        Config
+	discovery  Discovery
+	client     *http.Client
+	algorithms []string
+
+	mu          sync.Mutex
+	keys        map[string]keyring.JWK
+	keysFetched time.Time
+}
+
+// Discover reads the discovery document of the provider at cfg.Issuer. The provider must
+// support the authorization code flow with PKCE using S256.
+func Discover(ctx context.Context, cfg Config) (*Provider, error) {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	p := &Provider{cfg: cfg, client: client}
+
+	var discovery Discovery
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if discovery.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", discovery.Issuer, cfg.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+	// Providers that do not list their PKCE methods are trusted to support S256.
+	if len(discovery.CodeChallengeMethods) > 0 && !contains(discovery.CodeChallengeMethods, "S256") {
+		return nil, errors.New("oidc discovery: the provider does not support PKCE with S256")
+	}
+	p.discovery = discovery
+
+	// Without a list, RS256 is the only algorithm a provider must support.
+	offered := discovery.SigningAlgorithms
+	if len(offered) == 0 {
+		offered = []string{"RS256"}
+	}
+	for _, algorithm := range supportedAlgorithms {
+		if contains(offered, algorithm) {
+			p.algorithms = append(p.algorithms, algorithm)
+		}
+	}
+	if len(p.algorithms) == 0 {
+		return nil, fmt.Errorf("oidc discovery: none of the ID token algorithms %v is supported", offered)
+	}
+	return p, nil
+}
+
+// Issuer returns the issuer URL of the provider.
+func (p *Provider) Issuer() string {
+	return p.discovery.Issuer
+}
+
+// AuthCodeURL returns the URL to send the user to. state is returned unchanged in the callback,
+// nonce ends up in the ID token and verifier is the PKCE code verifier, see NewVerifier.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	endpoint := p.discovery.AuthorizationEndpoint
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+	return endpoint + separator + query.Encode()
+}
+
+// Exchange trades the authorization code of the callback for tokens.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// client_secret_basic, with the credentials form-encoded first (RFC 6749, section 2.3.1).
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr Error
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code != "" {
+			return nil, &oauthErr
+		}
+		return nil, fmt.Errorf("oidc: token endpoint returned %s", resp.Status)
+	}
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("oidc: invalid token response: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: the token response has no ID token")
+	}
+	return &token, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, lifetime and nonce of an ID token and
+// returns its claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(p.algorithms),
+		jwt.WithIssuer(p.discovery.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		return p.key(ctx, token)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: issued to another party", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// key returns the public key a token is signed with, selected by its kid header. The JWKS is
+// fetched again when the kid is unknown, as the provider may have rotated its keys.
+func (p *Provider) key(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	jwk, ok := p.findKey(kid)
+	if !ok && time.Since(p.keysFetched) >= keysRefreshInterval {
+		if err := p.fetchKeys(ctx); err != nil {
+			return nil, err
+		}
+		jwk, ok = p.findKey(kid)
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if jwk.Alg != "" && jwk.Alg != token.Method.Alg() {
+		return nil, keyring.ErrAlgorithmMismatch
+	}
+	return jwk.PublicKey()
+}
+
+// findKey looks up a key by ID. Tokens without a kid are accepted if the provider has a single key.
+func (p *Provider) findKey(kid string) (keyring.JWK, bool) {
+	if kid == "" {
+		if len(p.keys) != 1 {
+			return keyring.JWK{}, false
+		}
+		for _, jwk := range p.keys {
+			return jwk, true
+		}
+	}
+	jwk, ok := p.keys[kid]
+	return jwk, ok
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	var set keyring.JWKSet
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, &set); err != nil {
+		return fmt.Errorf("oidc: fetching keys: %w", err)
+	}
+	keys := make(map[string]keyring.JWK, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use == "" || jwk.Use == "sig" {
+			keys[jwk.Kid] = jwk
+		}
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+	return nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Challenge returns the S256 code challenge of a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
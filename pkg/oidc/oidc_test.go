@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"hackathon/pkg/oidc/oidctest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const redirectURL = "https://app.example.com/api/auth/oidc/callback"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	mock, err := oidctest.New("client", "s3cret/+")
+	assert.NoError(t, err)
+	t.Cleanup(mock.Close)
+	provider, err := Discover(context.Background(), Config{
+		Issuer:       mock.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "s3cret/+",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+	})
+	assert.NoError(t, err)
+	return provider, mock
+}
+
+// signIn runs the authorization code flow and returns the code of the callback.
+func signIn(t *testing.T, provider *Provider, mock *oidctest.Provider, verifier string) string {
+	callback, err := mock.Authorize(provider.AuthCodeURL("the-state", "the-nonce", verifier))
+	assert.NoError(t, err)
+	assert.Equal(t, "the-state", callback.Query().Get("state"))
+	return callback.Query().Get("code")
+}
+
+func TestDiscover(t *testing.T) {
+	provider, mock := newTestProvider(t)
+	assert.Equal(t, mock.Issuer(), provider.Issuer())
+	assert.Equal(t, []string{"RS256"}, provider.algorithms)
+
+	authURL, err := url.Parse(provider.AuthCodeURL("state", "nonce", "verifier"))
+	assert.NoError(t, err)
+	query := authURL.Query()
+	assert.Equal(t, "client", query.Get("client_id"))
+	assert.Equal(t, redirectURL, query.Get("redirect_uri"))
+	assert.Equal(t, "openid email", query.Get("scope"))
+	assert.Equal(t, Challenge("verifier"), query.Get("code_challenge"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	t.Run("issuer must match", func(t *testing.T) {
+		_, err := Discover(context.Background(), Config{Issuer: mock.Issuer() + "/"})
+		assert.ErrorContains(t, err, "does not match")
+	})
+}
+
+func TestProvider_CodeFlow(t *testing.T) {
+	ctx := context.Background()
+	provider, mock := newTestProvider(t)
+	mock.SetUser(oidctest.User{Subject: "42", Email: "dev@example.com", EmailVerified: true, PreferredUsername: "dev"})
+	verifier, err := NewVerifier()
+	assert.NoError(t, err)
+
+	token, err := provider.Exchange(ctx, signIn(t, provider, mock, verifier), verifier)
+	assert.NoError(t, err)
+	claims, err := provider.VerifyIDToken(ctx, token.IDToken, "the-nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, "dev@example.com", claims.Email)
+	assert.True(t, bool(claims.EmailVerified))
+	assert.Equal(t, "dev", claims.PreferredUsername)
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		_, err := provider.VerifyIDToken(ctx, token.IDToken, "other-nonce")
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+
+	t.Run("codes are single-use", func(t *testing.T) {
+		code := signIn(t, provider, mock, verifier)
+		_, err := provider.Exchange(ctx, code, verifier)
+		assert.NoError(t, err)
+		_, err = provider.Exchange(ctx, code, verifier)
+		var oauthErr *Error
+		assert.ErrorAs(t, err, &oauthErr)
+		assert.Equal(t, "invalid_grant", oauthErr.Code)
+	})
+
+	t.Run("wrong PKCE verifier", func(t *testing.T) {
+		code := signIn(t, provider, mock, verifier)
+		_, err := provider.Exchange(ctx, code, "another-verifier")
+		assert.ErrorContains(t, err, "PKCE")
+	})
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	ctx := context.Background()
+	provider, mock := newTestProvider(t)
+	verifier, err := NewVerifier()
+	assert.NoError(t, err)
+	idToken := func(hook func(claims jwt.MapClaims)) string {
+		mock.ClaimsHook = hook
+		defer func() { mock.ClaimsHook = nil }()
+		token, err := provider.Exchange(ctx, signIn(t, provider, mock, verifier), verifier)
+		assert.NoError(t, err)
+		return token.IDToken
+	}
+
+	tests := []struct {
+		name string
+		hook func(claims jwt.MapClaims)
+	}{
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"issued to another party", func(c jwt.MapClaims) { c["aud"] = []string{"client", "other"}; c["azp"] = "other" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(ctx, idToken(tt.hook), "the-nonce")
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("string email_verified", func(t *testing.T) {
+		claims, err := provider.VerifyIDToken(ctx, idToken(func(c jwt.MapClaims) { c["email_verified"] = "true" }), "the-nonce")
+		assert.NoError(t, err)
+		assert.True(t, bool(claims.EmailVerified))
+	})
+
+	t.Run("keys are fetched again after a rotation", func(t *testing.T) {
+		assert.NoError(t, mock.RotateKey())
+		rotated := idToken(nil)
+		_, err := provider.VerifyIDToken(ctx, rotated, "the-nonce")
+		assert.ErrorIs(t, err, ErrUnknownKey, "not before the refresh interval has passed")
+
+		provider.keysFetched = time.Time{}
+		_, err = provider.VerifyIDToken(ctx, rotated, "the-nonce")
+		assert.NoError(t, err)
+	})
+}
+
+func TestBool_UnmarshalJSON(t *testing.T) {
+	var claims struct {
+		A, B, C Bool
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"A": true, "B": "true", "C": "false"}`), &claims))
+	assert.Equal(t, Bool(true), claims.A)
+	assert.Equal(t, Bool(true), claims.B)
+	assert.Equal(t, Bool(false), claims.C)
+	assert.Error(t, json.Unmarshal([]byte(`{"A": 1}`), &claims))
+}
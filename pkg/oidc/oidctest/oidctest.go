@@ -0,0 +1,277 @@
+// Package oidctest runs an in-process OpenID provider for tests. It serves discovery, a JWKS, an
+// authorization endpoint that signs in a preset user without any UI, and a token endpoint that
+// checks the client credentials and the PKCE verifier before issuing an ID token.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hackathon/pkg/keyring"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// User is the account the provider signs in at the authorization endpoint.
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type authRequest struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        User
+}
+
+// Provider is a running mock provider. Close it when done.
+type Provider struct {
+	ClientID     string
+	ClientSecret string
+	// ClaimsHook, if set, can change the claims of ID tokens before they are signed.
+	ClaimsHook func(claims jwt.MapClaims)
+
+	server *httptest.Server
+	keys   *keyring.Keyring
+
+	mu    sync.Mutex
+	user  User
+	codes map[string]authRequest
+}
+
+// New starts a provider for a single client. Its ID tokens are signed with a fresh RS256 key.
+func New(clientID, clientSecret string) (*Provider, error) {
+	key, _, err := keyring.Generate("RS256")
+	if err != nil {
+		return nil, err
+	}
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		keys:         keyring.New(key),
+		user:         User{Subject: "subject-1", Email: "user@example.com", EmailVerified: true, Name: "Test User", PreferredUsername: "user"},
+		codes:        make(map[string]authRequest),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	return p, nil
+}
+
+// Issuer returns the issuer URL of the provider.
+func (p *Provider) Issuer() string {
+	return p.server.URL
+}
+
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// SetUser changes the user signed in by later authorization requests.
+func (p *Provider) SetUser(user User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = user
+}
+
+// RotateKey replaces the signing key; tokens signed with the old one no longer verify.
+func (p *Provider) RotateKey() error {
+	key, _, err := keyring.Generate("RS256")
+	if err != nil {
+		return err
+	}
+	p.keys.Replace(key)
+	return nil
+}
+
+// Authorize plays the part of the browser: it opens the authorization URL and returns the
+// callback URL the provider redirects to, with the code and state in its query.
+func (p *Provider) Authorize(authURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("authorization failed: %s", resp.Status)
+	}
+	return url.Parse(resp.Header.Get("Location"))
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.keys.JWKS())
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case query.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case query.Get("client_id") != p.ClientID:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case query.Get("redirect_uri") == "":
+		http.Error(w, "redirect_uri is required", http.StatusBadRequest)
+		return
+	case query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256":
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomHex()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = authRequest{
+		redirectURI: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		user:        p.user,
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	callback := redirect.Query()
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	redirect.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.authenticateClient(r); err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": err.Error()})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	p.mu.Lock()
+	code := r.PostForm.Get("code")
+	request, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown or used code"})
+		return
+	case r.PostForm.Get("redirect_uri") != request.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "redirect_uri mismatch"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != request.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                p.Issuer(),
+		"sub":                request.user.Subject,
+		"aud":                p.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              request.nonce,
+		"email":              request.user.Email,
+		"email_verified":     request.user.EmailVerified,
+		"name":               request.user.Name,
+		"preferred_username": request.user.PreferredUsername,
+	}
+	if p.ClaimsHook != nil {
+		p.ClaimsHook(claims)
+	}
+	idToken, err := p.keys.Sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	accessToken, err := randomHex()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+		"expires_in":   300,
+	})
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post.
+func (p *Provider) authenticateClient(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != p.ClientID || secret != p.ClientSecret {
+		return errors.New("wrong client credentials")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomHex() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,55 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points just after the last item of a page in a keyset-paginated collection.
+// Sort and Order record how the collection was sorted so a cursor cannot be reused
+// with a different ordering.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// Encode turns the cursor into an opaque URL-safe token.
+func Encode(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode parses a token produced by Encode.
+func Decode(token string) (Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Limit returns the requested page size bounded to [1, MaxLimit], or DefaultLimit when unset.
+func Limit(requested int) int {
+	switch {
+	case requested <= 0:
+		return DefaultLimit
+	case requested > MaxLimit:
+		return MaxLimit
+	default:
+		return requested
+	}
+}
@@ -0,0 +1,87 @@
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Argon2id hashes with argon2id (RFC 9106) into the PHC string format:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+type Argon2id struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2id follows the OWASP minimum recommendation.
+var DefaultArgon2id = Argon2id{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	if a.Memory == 0 || a.Iterations == 0 || a.Parallelism == 0 {
+		return "", fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", a.Memory, a.Iterations, a.Parallelism)
+	}
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a Argon2id) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func (a Argon2id) Current(encoded string) bool {
+	params, _, key, err := decodeArgon2id(encoded)
+	return err == nil && params == a && len(key) == argon2KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2id{}, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	var params Argon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("invalid argon2id parameters %q: %w", parts[3], err)
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2id{}, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2id{}, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,39 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt hashes with bcrypt. It only uses the first 72 bytes of a password, so longer passwords
+// are rejected with ErrPasswordTooLong instead of being truncated.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", ErrPasswordTooLong
+	}
+	return string(hash), err
+}
+
+func (b Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b Bcrypt) Current(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost == b.Cost
+}
@@ -0,0 +1,72 @@
+// Package passhash hashes passwords into self-describing strings: the algorithm and its parameters
+// are stored with the hash, so stored passwords keep verifying after the algorithm or its cost
+// changes and can be upgraded on the next login.
+package passhash
+
+import "errors"
+
+var (
+	// ErrUnknownHash is returned for a stored hash that no scheme of the Hasher recognizes.
+	ErrUnknownHash = errors.New("unknown password hash format")
+	// ErrPasswordTooLong is returned by schemes that only hash a limited number of bytes.
+	ErrPasswordTooLong = errors.New("password is too long")
+)
+
+// Scheme hashes and verifies passwords with one algorithm.
+type Scheme interface {
+	// Hash returns the encoded hash of the password with a new random salt.
+	Hash(password string) (string, error)
+	// Recognizes reports whether encoded was produced by this algorithm.
+	Recognizes(encoded string) bool
+	// Verify reports whether the password matches encoded.
+	Verify(password, encoded string) (bool, error)
+	// Current reports whether encoded was produced with the scheme's configured parameters.
+	Current(encoded string) bool
+}
+
+// Hasher hashes new passwords with its current scheme and verifies stored hashes of any of its
+// schemes.
+type Hasher struct {
+	current Scheme
+	schemes []Scheme
+}
+
+// New returns a Hasher that hashes with current and still verifies hashes of the legacy schemes.
+func New(current Scheme, legacy ...Scheme) *Hasher {
+	return &Hasher{current: current, schemes: append([]Scheme{current}, legacy...)}
+}
+
+// Hash returns the encoded hash of the password with the current scheme.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Dummies returns a hash of a throwaway password for every scheme, the current one first. Checking
+// a password against one costs as much as checking a stored hash of that scheme.
+func (h *Hasher) Dummies() ([]string, error) {
+	dummies := make([]string, 0, len(h.schemes))
+	for _, scheme := range h.schemes {
+		encoded, err := scheme.Hash("dummy password")
+		if err != nil {
+			return nil, err
+		}
+		dummies = append(dummies, encoded)
+	}
+	return dummies, nil
+}
+
+// Verify reports whether the password matches encoded and, if it does, whether encoded should be
+// replaced by a new Hash because it uses another scheme or outdated parameters.
+func (h *Hasher) Verify(password, encoded string) (ok, rehash bool, err error) {
+	for _, scheme := range h.schemes {
+		if !scheme.Recognizes(encoded) {
+			continue
+		}
+		ok, err := scheme.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, scheme != h.current || !scheme.Current(encoded), nil
+	}
+	return false, false, ErrUnknownHash
+}
@@ -0,0 +1,136 @@
+package passhash
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small parameters keep the tests fast.
+var testArgon2id = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestArgon2id(t *testing.T) {
+	encoded, err := testArgon2id.Hash("password")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$"))
+	assert.True(t, testArgon2id.Recognizes(encoded))
+	assert.True(t, testArgon2id.Current(encoded))
+
+	ok, err := testArgon2id.Verify("password", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = testArgon2id.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	other, err := testArgon2id.Hash("password")
+	assert.NoError(t, err)
+	assert.NotEqual(t, encoded, other, "salt must be random")
+
+	stronger := Argon2id{Memory: 128, Iterations: 1, Parallelism: 1}
+	assert.False(t, stronger.Current(encoded))
+	ok, err = stronger.Verify("password", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok, "hashes verify with their own parameters")
+}
+
+// Hashes built outside of Hash, as other PHC implementations store them, verify with the
+// parameters and key length they contain.
+func TestArgon2id_PHCString(t *testing.T) {
+	key := argon2.IDKey([]byte("password"), []byte("somesalt"), 2, 256, 4, 24)
+	encoded := "$argon2id$v=19$m=256,t=2,p=4$c29tZXNhbHQ$" + base64.RawStdEncoding.EncodeToString(key)
+
+	ok, err := DefaultArgon2id.Verify("password", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, DefaultArgon2id.Current(encoded))
+}
+
+func TestArgon2id_Malformed(t *testing.T) {
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$aGFzaA",
+	} {
+		_, err := testArgon2id.Verify("password", encoded)
+		assert.Error(t, err, encoded)
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	scheme := Bcrypt{Cost: bcrypt.MinCost}
+	encoded, err := scheme.Hash("password")
+	assert.NoError(t, err)
+	assert.True(t, scheme.Recognizes(encoded))
+	assert.True(t, scheme.Current(encoded))
+	assert.False(t, Bcrypt{Cost: bcrypt.MinCost + 1}.Current(encoded))
+
+	ok, err := scheme.Verify("password", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = scheme.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = scheme.Hash(strings.Repeat("a", 73))
+	assert.ErrorIs(t, err, ErrPasswordTooLong)
+}
+
+func TestHasher(t *testing.T) {
+	legacy := Bcrypt{Cost: bcrypt.MinCost}
+	hasher := New(testArgon2id, legacy)
+
+	t.Run("hashes with the current scheme", func(t *testing.T) {
+		encoded, err := hasher.Hash(strings.Repeat("a", 100))
+		assert.NoError(t, err)
+		assert.True(t, testArgon2id.Recognizes(encoded))
+
+		ok, rehash, err := hasher.Verify(strings.Repeat("a", 100), encoded)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.False(t, rehash)
+	})
+
+	t.Run("verifies and upgrades legacy hashes", func(t *testing.T) {
+		encoded, err := legacy.Hash("password")
+		assert.NoError(t, err)
+
+		ok, rehash, err := hasher.Verify("password", encoded)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, rehash)
+
+		ok, rehash, err = hasher.Verify("wrong", encoded)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.False(t, rehash)
+	})
+
+	t.Run("upgrades outdated parameters", func(t *testing.T) {
+		encoded, err := Argon2id{Memory: 32, Iterations: 1, Parallelism: 1}.Hash("password")
+		assert.NoError(t, err)
+
+		ok, rehash, err := hasher.Verify("password", encoded)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, rehash)
+	})
+
+	t.Run("dummies of every scheme", func(t *testing.T) {
+		dummies, err := hasher.Dummies()
+		assert.NoError(t, err)
+		assert.Len(t, dummies, 2)
+		assert.True(t, testArgon2id.Recognizes(dummies[0]))
+		assert.True(t, legacy.Recognizes(dummies[1]))
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, _, err := hasher.Verify("password", "plaintext")
+		assert.ErrorIs(t, err, ErrUnknownHash)
+	})
+}
@@ -0,0 +1,127 @@
+// Package pwned checks passwords against an offline copy of the Have I Been Pwned password list,
+// which identifies passwords by their uppercase hex SHA-1 hash.
+package pwned
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prefixLength is the length of the hash prefixes the range files are split by.
+const prefixLength = 5
+
+// List tells whether a password appeared in a data breach.
+type List interface {
+	Contains(password string) (bool, error)
+}
+
+// Open loads the list at path, which is either
+//   - a directory of range files as written by the HIBP downloader: one <PREFIX>.txt file per
+//     5 character hash prefix, with one SUFFIX:COUNT line per hash. Files are read on lookup, so
+//     the directory can hold the whole list.
+//   - a file with one HASH:COUNT (or just HASH) line per hash, loaded into memory.
+func Open(path string) (List, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return rangeDir(path), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load reads a list of HASH:COUNT lines into memory.
+func Load(r io.Reader) (List, error) {
+	set := make(hashSet)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		hash, breached, err := parseLine(scanner.Text(), sha1.Size*2)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if breached {
+			set[hash] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+type hashSet map[string]struct{}
+
+func (s hashSet) Contains(password string) (bool, error) {
+	_, ok := s[hashPassword(password)]
+	return ok, nil
+}
+
+type rangeDir string
+
+func (d rangeDir) Contains(password string) (bool, error) {
+	hash := hashPassword(password)
+	f, err := os.Open(filepath.Join(string(d), hash[:prefixLength]+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	suffix := hash[prefixLength:]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(strings.ToUpper(line), suffix) {
+			continue
+		}
+		_, breached, err := parseLine(line, len(suffix))
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		return breached, nil
+	}
+	return false, scanner.Err()
+}
+
+// parseLine parses a HASH[:COUNT] line. Entries with a count of 0 are padding and not breached.
+func parseLine(line string, hashLength int) (string, bool, error) {
+	line = strings.TrimSpace(line)
+	hash, count, hasCount := strings.Cut(line, ":")
+	if len(hash) != hashLength || strings.Trim(hash, "0123456789abcdefABCDEF") != "" {
+		return "", false, fmt.Errorf("invalid hash %q", hash)
+	}
+	if hasCount {
+		n, err := strconv.ParseUint(strings.TrimSpace(count), 10, 64)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid count %q", count)
+		}
+		if n == 0 {
+			return strings.ToUpper(hash), false, nil
+		}
+	}
+	return strings.ToUpper(hash), true, nil
+}
+
+func hashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
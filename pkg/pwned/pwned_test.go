@@ -0,0 +1,70 @@
+package pwned
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8,
+// of "123456" 7C4A8D09CA3762AF61E59520943DC26494F8941B.
+
+func TestLoad(t *testing.T) {
+	list, err := Load(strings.NewReader(
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n" +
+			"7c4a8d09ca3762af61e59520943dc26494f8941b\n" +
+			"0000000000000000000000000000000000000000:0\n"))
+	assert.NoError(t, err)
+
+	for password, expected := range map[string]bool{"password": true, "123456": true, "correct horse battery staple": false} {
+		breached, err := list.Contains(password)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, breached, password)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load(strings.NewReader("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\nnot a hash\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestOpen_RangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(
+		"003D68EB55068C33ACE09247EE4C639306B:3\r\n"+
+			"1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "7C4A8.txt"), []byte(
+		"D09CA3762AF61E59520943DC26494F8941B:0\r\n"), 0o644))
+
+	list, err := Open(dir)
+	assert.NoError(t, err)
+
+	breached, err := list.Contains("password")
+	assert.NoError(t, err)
+	assert.True(t, breached)
+
+	breached, err = list.Contains("123456")
+	assert.NoError(t, err)
+	assert.False(t, breached, "padding entries have a count of 0")
+
+	breached, err = list.Contains("correct horse battery staple")
+	assert.NoError(t, err)
+	assert.False(t, breached, "missing range files are empty")
+}
+
+func TestOpen_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n"), 0o644))
+
+	list, err := Open(path)
+	assert.NoError(t, err)
+	breached, err := list.Contains("password")
+	assert.NoError(t, err)
+	assert.True(t, breached)
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
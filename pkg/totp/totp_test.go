@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 4226, appendix D.
+func TestHOTP_RFC4226Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		assert.Equal(t, code, hotp(key, uint64(counter), 6))
+	}
+}
+
+// RFC 6238, appendix B (SHA-1).
+func TestTOTP_RFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, code := range vectors {
+		assert.Equal(t, code, hotp(key, uint64(Step(time.Unix(unix, 0))), 8), "T=%d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	code, err := Code(secret, now)
+	assert.NoError(t, err)
+	step, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok, "codes of the previous step are accepted")
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	assert.False(t, ok)
+	_, ok = Validate(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = Validate("not base32!", code, now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("JBSWY3DPEHPK3PXP", "Hackathon", "dev@example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Hackathon:dev@example.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "Hackathon", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AccessTokenRepository interface {
+	Create(token *models.PersonalAccessToken) error
+	FindByHash(hash string) (*models.PersonalAccessToken, error)
+	FindByIDAndUser(id, userID uint) (*models.PersonalAccessToken, error)
+	ListActiveByUser(userID uint) ([]models.PersonalAccessToken, error)
+	Touch(token *models.PersonalAccessToken, usedAt time.Time) error
+	Revoke(token *models.PersonalAccessToken) error
+	RevokeAllForUser(userID uint) error
+}
+
+type accessTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAccessTokenRepository(db *gorm.DB) AccessTokenRepository {
+	return &accessTokenRepository{db: db}
+}
+
+func (r *accessTokenRepository) Create(token *models.PersonalAccessToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *accessTokenRepository) FindByHash(hash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *accessTokenRepository) FindByIDAndUser(id, userID uint) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	err := r.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ListActiveByUser returns the tokens of the user that are neither revoked nor expired, newest first.
+func (r *accessTokenRepository) ListActiveByUser(userID uint) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *accessTokenRepository) Touch(token *models.PersonalAccessToken, usedAt time.Time) error {
+	if err := r.db.Model(token).Update("last_used_at", usedAt).Error; err != nil {
+		return err
+	}
+	token.LastUsedAt = &usedAt
+	return nil
+}
+
+func (r *accessTokenRepository) Revoke(token *models.PersonalAccessToken) error {
+	now := time.Now()
+	if err := r.db.Model(token).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	token.RevokedAt = &now
+	return nil
+}
+
+func (r *accessTokenRepository) RevokeAllForUser(userID uint) error {
+	return r.db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ExportJobRepository interface {
+	Create(job *models.ExportJob) error
+	FindByIDAndUser(id string, userID uint) (*models.ExportJob, error)
+	FindActiveByUser(userID uint) (*models.ExportJob, error)
+	ListByUser(userID uint) ([]models.ExportJob, error)
+	MarkRunning(job *models.ExportJob) error
+	Complete(job *models.ExportJob, size int64, expiresAt time.Time) error
+	Fail(job *models.ExportJob, message string, expiresAt time.Time) error
+	FailStale(createdBefore time.Time, message string, expiresAt time.Time) (int64, error)
+	ListExpired(now time.Time, limit int) ([]models.ExportJob, error)
+	Delete(job *models.ExportJob) error
+}
+
+type exportJobRepository struct {
+	db *gorm.DB
+}
+
+func NewExportJobRepository(db *gorm.DB) ExportJobRepository {
+	return &exportJobRepository{db: db}
+}
+
+func (r *exportJobRepository) Create(job *models.ExportJob) error {
+	return r.db.Create(job).Error
+}
+
+func (r *exportJobRepository) FindByIDAndUser(id string, userID uint) (*models.ExportJob, error) {
+	var job models.ExportJob
+	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FindActiveByUser returns the pending or running job of the user, if there is one.
+func (r *exportJobRepository) FindActiveByUser(userID uint) (*models.ExportJob, error) {
+	var job models.ExportJob
+	err := r.db.Where("user_id = ? AND status IN ?", userID, []string{models.ExportPending, models.ExportRunning}).
+		Order("created_at DESC").
+		First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *exportJobRepository) ListByUser(userID uint) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *exportJobRepository) MarkRunning(job *models.ExportJob) error {
+	if err := r.db.Model(job).Update("status", models.ExportRunning).Error; err != nil {
+		return err
+	}
+	job.Status = models.ExportRunning
+	return nil
+}
+
+// Complete marks the job done. It returns ErrRecordNotFound if the job was deleted meanwhile, as
+// happens when its user deletes their account.
+func (r *exportJobRepository) Complete(job *models.ExportJob, size int64, expiresAt time.Time) error {
+	now := time.Now()
+	result := r.db.Model(job).Updates(map[string]interface{}{
+		"status":       models.ExportDone,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	job.Status = models.ExportDone
+	job.Size = size
+	job.CompletedAt = &now
+	job.ExpiresAt = &expiresAt
+	return nil
+}
+
+func (r *exportJobRepository) Fail(job *models.ExportJob, message string, expiresAt time.Time) error {
+	now := time.Now()
+	err := r.db.Model(job).Updates(map[string]interface{}{
+		"status":       models.ExportFailed,
+		"error":        message,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error
+	if err != nil {
+		return err
+	}
+	job.Status = models.ExportFailed
+	job.Error = message
+	job.CompletedAt = &now
+	job.ExpiresAt = &expiresAt
+	return nil
+}
+
+// FailStale fails the pending and running jobs created before the cutoff, whose worker is
+// assumed to be gone. It returns how many jobs were failed.
+func (r *exportJobRepository) FailStale(createdBefore time.Time, message string, expiresAt time.Time) (int64, error) {
+	result := r.db.Model(&models.ExportJob{}).
+		Where("status IN ? AND created_at < ?", []string{models.ExportPending, models.ExportRunning}, createdBefore).
+		Updates(map[string]interface{}{
+			"status":       models.ExportFailed,
+			"error":        message,
+			"completed_at": time.Now(),
+			"expires_at":   expiresAt,
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (r *exportJobRepository) ListExpired(now time.Time, limit int) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	err := r.db.Where("expires_at < ?", now).
+		Order("expires_at").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *exportJobRepository) Delete(job *models.ExportJob) error {
+	return r.db.Delete(job).Error
+}
@@ -1,13 +1,51 @@
 package repositories
 
 import (
+	"fmt"
 	"hackathon/models"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// FileListQuery describes a keyset-paginated listing of one owner's files.
+// SortColumn must be one of the keys of fileSortColumns; After, when set, holds the
+// sort value and ID of the last item of the previous page.
+type FileListQuery struct {
+	OwnerID      uint
+	ContentType  string
+	UploadedFrom *time.Time
+	UploadedTo   *time.Time
+	NameContains string
+	Trashed      bool
+	SortColumn   string
+	Descending   bool
+	AfterValue   interface{}
+	AfterID      uint
+	Limit        int
+}
+
+var fileSortColumns = map[string]bool{"filename": true, "size": true, "uploaded_at": true}
+
 type FileRepository interface {
 	Create(metadata *models.FileMetadata) error
+	FindByIDAndOwner(id, ownerID uint) (*models.FileMetadata, error)
+	List(query FileListQuery) ([]models.FileMetadata, error)
+	Count(query FileListQuery) (int64, error)
+	FindAccessibleByID(id, userID uint) (*models.FileMetadata, error)
+	FindTrashedByIDAndOwner(id, ownerID uint) (*models.FileMetadata, error)
+	Trash(metadata *models.FileMetadata) error
+	Restore(metadata *models.FileMetadata) error
+	ListTrashedBefore(cutoff time.Time, limit int) ([]models.FileMetadata, error)
+	ListAllByOwner(ownerID, afterID uint, limit int) ([]models.FileMetadata, error)
+	Purge(metadata *models.FileMetadata, deleteContent func() error) error
+	AcquireBlob(digest string, size int64, ensureContent func() error) error
+	ReleaseBlob(digest string, deleteContent func() error) error
+	CreateGrant(grant *models.FileGrant) error
+	DeleteGrant(fileID, userID uint) error
+	ListGrants(fileID uint) ([]models.FileGrant, error)
 }
 
 type fileRepository struct {
@@ -21,3 +59,192 @@ func NewFileRepository(db *gorm.DB) FileRepository {
 func (r *fileRepository) Create(metadata *models.FileMetadata) error {
 	return r.db.Create(metadata).Error
 }
+
+func (r *fileRepository) FindByIDAndOwner(id, ownerID uint) (*models.FileMetadata, error) {
+	var metadata models.FileMetadata
+	err := r.db.Where("id = ? AND owner_id = ?", id, ownerID).First(&metadata).Error
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (r *fileRepository) List(query FileListQuery) ([]models.FileMetadata, error) {
+	if !fileSortColumns[query.SortColumn] {
+		return nil, fmt.Errorf("unsupported sort column %q", query.SortColumn)
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	db := r.filtered(query)
+	if query.AfterValue != nil {
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", query.SortColumn, comparison), query.AfterValue, query.AfterID)
+	}
+
+	var files []models.FileMetadata
+	err := db.Order(fmt.Sprintf("%s %s, id %s", query.SortColumn, direction, direction)).
+		Limit(query.Limit).
+		Find(&files).Error
+	return files, err
+}
+
+// Count returns the number of files matching the query filters, ignoring pagination.
+func (r *fileRepository) Count(query FileListQuery) (int64, error) {
+	var total int64
+	err := r.filtered(query).Count(&total).Error
+	return total, err
+}
+
+func (r *fileRepository) filtered(query FileListQuery) *gorm.DB {
+	db := r.db.Model(&models.FileMetadata{}).Where("owner_id = ?", query.OwnerID)
+	if query.Trashed {
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if query.ContentType != "" {
+		db = db.Where("content_type = ?", query.ContentType)
+	}
+	if query.UploadedFrom != nil {
+		db = db.Where("uploaded_at >= ?", *query.UploadedFrom)
+	}
+	if query.UploadedTo != nil {
+		db = db.Where("uploaded_at < ?", *query.UploadedTo)
+	}
+	if query.NameContains != "" {
+		db = db.Where(`filename ILIKE ? ESCAPE '\'`, "%"+escapeLike(query.NameContains)+"%")
+	}
+	return db
+}
+
+// escapeLike escapes the LIKE wildcards so user input is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// FindAccessibleByID returns the file if the user owns it or has been granted access to it.
+func (r *fileRepository) FindAccessibleByID(id, userID uint) (*models.FileMetadata, error) {
+	var metadata models.FileMetadata
+	err := r.db.Where("id = ?", id).
+		Where("owner_id = ? OR EXISTS (SELECT 1 FROM file_grants WHERE file_grants.file_id = file_metadata.id AND file_grants.user_id = ?)", userID, userID).
+		First(&metadata).Error
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (r *fileRepository) FindTrashedByIDAndOwner(id, ownerID uint) (*models.FileMetadata, error) {
+	var metadata models.FileMetadata
+	err := r.db.Unscoped().Where("id = ? AND owner_id = ? AND deleted_at IS NOT NULL", id, ownerID).First(&metadata).Error
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// Trash soft-deletes the file by setting its DeletedAt.
+func (r *fileRepository) Trash(metadata *models.FileMetadata) error {
+	return r.db.Delete(metadata).Error
+}
+
+func (r *fileRepository) Restore(metadata *models.FileMetadata) error {
+	if err := r.db.Unscoped().Model(metadata).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	metadata.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (r *fileRepository) ListTrashedBefore(cutoff time.Time, limit int) ([]models.FileMetadata, error) {
+	var files []models.FileMetadata
+	err := r.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Order("deleted_at").
+		Limit(limit).
+		Find(&files).Error
+	return files, err
+}
+
+// ListAllByOwner returns one page of every file of the owner, trashed ones included, ordered by
+// ID. AfterID is the last ID of the previous page.
+func (r *fileRepository) ListAllByOwner(ownerID, afterID uint, limit int) ([]models.FileMetadata, error) {
+	var files []models.FileMetadata
+	err := r.db.Unscoped().Where("owner_id = ? AND id > ?", ownerID, afterID).
+		Order("id").
+		Limit(limit).
+		Find(&files).Error
+	return files, err
+}
+
+// Purge removes the file row and its grants for good, together with its reference to the blob.
+// deleteContent runs when nothing uses the content anymore, before the removal commits; if it
+// fails nothing is removed, so the purge can be retried without releasing the blob twice.
+func (r *fileRepository) Purge(metadata *models.FileMetadata, deleteContent func() error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id = ?", metadata.ID).Delete(&models.FileGrant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(metadata).Error; err != nil {
+			return err
+		}
+		if metadata.Digest == "" {
+			return deleteContent()
+		}
+		return releaseBlob(tx, metadata.Digest, deleteContent)
+	})
+}
+
+// AcquireBlob adds a reference to the blob with the given digest, creating its row if needed.
+// ensureContent runs while the row is locked and must make sure the content is in storage;
+// if it fails the reference is not taken.
+func (r *fileRepository) AcquireBlob(digest string, size int64, ensureContent func() error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "digest"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("blobs.ref_count + 1")}),
+		}).Create(&models.Blob{Digest: digest, Size: size, RefCount: 1}).Error
+		if err != nil {
+			return err
+		}
+		return ensureContent()
+	})
+}
+
+// ReleaseBlob drops a reference to the blob. When the last reference goes away deleteContent
+// runs while the row is still locked, so a concurrent AcquireBlob cannot reuse content that is
+// being deleted, and the row is removed.
+func (r *fileRepository) ReleaseBlob(digest string, deleteContent func() error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return releaseBlob(tx, digest, deleteContent)
+	})
+}
+
+func releaseBlob(tx *gorm.DB, digest string, deleteContent func() error) error {
+	var blob models.Blob
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("digest = ?", digest).First(&blob).Error
+	if err != nil {
+		return err
+	}
+	if blob.RefCount > 1 {
+		return tx.Model(&blob).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	}
+	if err := deleteContent(); err != nil {
+		return err
+	}
+	return tx.Delete(&blob).Error
+}
+
+func (r *fileRepository) CreateGrant(grant *models.FileGrant) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(grant).Error
+}
+
+func (r *fileRepository) DeleteGrant(fileID, userID uint) error {
+	return r.db.Where("file_id = ? AND user_id = ?", fileID, userID).Delete(&models.FileGrant{}).Error
+}
+
+func (r *fileRepository) ListGrants(fileID uint) ([]models.FileGrant, error) {
+	var grants []models.FileGrant
+	err := r.db.Where("file_id = ?", fileID).Order("id").Find(&grants).Error
+	return grants, err
+}
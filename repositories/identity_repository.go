@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"errors"
+	"hackathon/models"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// ErrDuplicateIdentity is returned by Create when the external account is linked already.
+var ErrDuplicateIdentity = errors.New("identity already linked")
+
+type IdentityRepository interface {
+	Create(identity *models.Identity) error
+	FindByIssuerAndSubject(issuer, subject string) (*models.Identity, error)
+	ListByUser(userID uint) ([]models.Identity, error)
+	Touch(identity *models.Identity, loginAt time.Time) error
+	CreateLoginState(state *models.OIDCLoginState) error
+	FindLoginStateByHash(hash string) (*models.OIDCLoginState, error)
+	ConsumeLoginState(state *models.OIDCLoginState) (bool, error)
+	DeleteLoginStatesBefore(cutoff time.Time) (int64, error)
+}
+
+type identityRepository struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+func (r *identityRepository) Create(identity *models.Identity) error {
+	err := r.db.Create(identity).Error
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrDuplicateIdentity
+	}
+	return err
+}
+
+func (r *identityRepository) FindByIssuerAndSubject(issuer, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	err := r.db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *identityRepository) ListByUser(userID uint) ([]models.Identity, error) {
+	var identities []models.Identity
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+func (r *identityRepository) Touch(identity *models.Identity, loginAt time.Time) error {
+	if err := r.db.Model(identity).Update("last_login_at", loginAt).Error; err != nil {
+		return err
+	}
+	identity.LastLoginAt = &loginAt
+	return nil
+}
+
+func (r *identityRepository) CreateLoginState(state *models.OIDCLoginState) error {
+	return r.db.Create(state).Error
+}
+
+func (r *identityRepository) FindLoginStateByHash(hash string) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	err := r.db.Where("state_hash = ?", hash).First(&state).Error
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// ConsumeLoginState atomically marks the state used. It returns false if it was already used.
+func (r *identityRepository) ConsumeLoginState(state *models.OIDCLoginState) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&models.OIDCLoginState{}).
+		Where("id = ? AND used_at IS NULL", state.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	state.UsedAt = &now
+	return true, nil
+}
+
+// DeleteLoginStatesBefore deletes the states that expired before the cutoff and returns how many
+// were deleted.
+func (r *identityRepository) DeleteLoginStatesBefore(cutoff time.Time) (int64, error) {
+	result := r.db.Where("expires_at < ?", cutoff).Delete(&models.OIDCLoginState{})
+	return result.RowsAffected, result.Error
+}
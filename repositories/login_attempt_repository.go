@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"errors"
+	"hackathon/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttemptRepository tracks failed logins. The Postgres implementation is shared by every
+// instance; the in-memory one is per instance and does not survive restarts.
+type LoginAttemptRepository interface {
+	// Get returns the failures of the key, a zero LoginAttempt if there are none.
+	Get(key string) (models.LoginAttempt, error)
+	// RecordFailure atomically counts a failure of the key. Failures before resetBefore are
+	// forgotten, the count starts over.
+	RecordFailure(key string, at, resetBefore time.Time) (models.LoginAttempt, error)
+	// Release takes back one failure of the key, counted for an attempt that turned out to succeed.
+	Release(key string) error
+	Reset(key string) error
+	// DeleteBefore forgets the keys whose last failure is before cutoff.
+	DeleteBefore(cutoff time.Time) (int64, error)
+}
+
+type loginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+func (r *loginAttemptRepository) Get(key string) (models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := r.db.Where("key = ?", key).First(&attempt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.LoginAttempt{Key: key}, nil
+	}
+	return attempt, err
+}
+
+func (r *loginAttemptRepository) RecordFailure(key string, at, resetBefore time.Time) (models.LoginAttempt, error) {
+	attempt := models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: at}
+	err := r.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":        gorm.Expr("CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END", resetBefore),
+				"last_failure_at": at,
+			}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "failures"}}},
+	).Create(&attempt).Error
+	return attempt, err
+}
+
+func (r *loginAttemptRepository) Release(key string) error {
+	return r.db.Model(&models.LoginAttempt{}).
+		Where("key = ? AND failures > 0", key).
+		Update("failures", gorm.Expr("failures - 1")).Error
+}
+
+func (r *loginAttemptRepository) Reset(key string) error {
+	return r.db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func (r *loginAttemptRepository) DeleteBefore(cutoff time.Time) (int64, error) {
+	result := r.db.Where("last_failure_at < ?", cutoff).Delete(&models.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+type memoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptRepository() LoginAttemptRepository {
+	return &memoryLoginAttemptRepository{attempts: make(map[string]models.LoginAttempt)}
+}
+
+func (r *memoryLoginAttemptRepository) Get(key string) (models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempt, ok := r.attempts[key]; ok {
+		return attempt, nil
+	}
+	return models.LoginAttempt{Key: key}, nil
+}
+
+func (r *memoryLoginAttemptRepository) RecordFailure(key string, at, resetBefore time.Time) (models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt, ok := r.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(resetBefore) {
+		attempt = models.LoginAttempt{Key: key}
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = at
+	r.attempts[key] = attempt
+	return attempt, nil
+}
+
+func (r *memoryLoginAttemptRepository) Release(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempt, ok := r.attempts[key]; ok && attempt.Failures > 0 {
+		attempt.Failures--
+		r.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) Reset(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) DeleteBefore(cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for key, attempt := range r.attempts {
+		if attempt.LastFailureAt.Before(cutoff) {
+			delete(r.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
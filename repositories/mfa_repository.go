@@ -0,0 +1,108 @@
+package repositories
+
+import (
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MFARepository interface {
+	CreateChallenge(challenge *models.MFAChallenge) error
+	FindChallengeByHash(hash string) (*models.MFAChallenge, error)
+	RecordAttempt(challenge *models.MFAChallenge, maxAttempts int) (bool, error)
+	ConsumeChallenge(challenge *models.MFAChallenge) (bool, error)
+	ReplaceRecoveryCodes(userID uint, hashes []string) error
+	UseRecoveryCode(userID uint, hash string) (bool, error)
+	CountUnusedRecoveryCodes(userID uint) (int64, error)
+	DeleteRecoveryCodes(userID uint) error
+}
+
+type mfaRepository struct {
+	db *gorm.DB
+}
+
+func NewMFARepository(db *gorm.DB) MFARepository {
+	return &mfaRepository{db: db}
+}
+
+func (r *mfaRepository) CreateChallenge(challenge *models.MFAChallenge) error {
+	return r.db.Create(challenge).Error
+}
+
+func (r *mfaRepository) FindChallengeByHash(hash string) (*models.MFAChallenge, error) {
+	var challenge models.MFAChallenge
+	err := r.db.Where("token_hash = ?", hash).First(&challenge).Error
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// RecordAttempt atomically counts a verification attempt. It returns false once the challenge
+// has had maxAttempts attempts or was used.
+func (r *mfaRepository) RecordAttempt(challenge *models.MFAChallenge, maxAttempts int) (bool, error) {
+	result := r.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL AND attempts < ?", challenge.ID, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	challenge.Attempts++
+	return true, nil
+}
+
+// ConsumeChallenge atomically marks the challenge as used. It returns false if it already was.
+func (r *mfaRepository) ConsumeChallenge(challenge *models.MFAChallenge) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	challenge.UsedAt = &now
+	return true, nil
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of the user and stores the new ones.
+func (r *mfaRepository) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]models.RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode atomically consumes the recovery code. It returns false if the user has no
+// such unused code.
+func (r *mfaRepository) UseRecoveryCode(userID uint, hash string) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *mfaRepository) CountUnusedRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *mfaRepository) DeleteRecoveryCodes(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PasswordResetRepository interface {
+	Create(token *models.PasswordResetToken) error
+	FindByHash(hash string) (*models.PasswordResetToken, error)
+	MarkUsed(token *models.PasswordResetToken) (bool, error)
+	InvalidateAllForUser(userID uint) error
+}
+
+type passwordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) PasswordResetRepository {
+	return &passwordResetRepository{db: db}
+}
+
+func (r *passwordResetRepository) Create(token *models.PasswordResetToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *passwordResetRepository) FindByHash(hash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed atomically consumes the token. It returns false if the token was already used.
+func (r *passwordResetRepository) MarkUsed(token *models.PasswordResetToken) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&models.PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	token.UsedAt = &now
+	return true, nil
+}
+
+// InvalidateAllForUser marks every unused token of the user as used.
+func (r *passwordResetRepository) InvalidateAllForUser(userID uint) error {
+	return r.db.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
@@ -0,0 +1,65 @@
+package repositories
+
+import (
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RefreshTokenRepository interface {
+	Create(token *models.RefreshToken) error
+	FindByHash(hash string) (*models.RefreshToken, error)
+	MarkUsed(token *models.RefreshToken) (bool, error)
+	RevokeFamily(familyID string) error
+	RevokeAllForUser(userID uint) error
+}
+
+type refreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) RefreshTokenRepository {
+	return &refreshTokenRepository{db: db}
+}
+
+func (r *refreshTokenRepository) Create(token *models.RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *refreshTokenRepository) FindByHash(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed atomically consumes the token. It returns false if the token was already used or revoked.
+func (r *refreshTokenRepository) MarkUsed(token *models.RefreshToken) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	token.UsedAt = &now
+	return true, nil
+}
+
+func (r *refreshTokenRepository) RevokeFamily(familyID string) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *refreshTokenRepository) RevokeAllForUser(userID uint) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
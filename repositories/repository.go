@@ -2,14 +2,39 @@ package repositories
 
 import "gorm.io/gorm"
 
+// ErrRecordNotFound is returned by the Find methods when nothing matches.
+var ErrRecordNotFound = gorm.ErrRecordNotFound
+
 type Repository struct {
-	User UserRepository
-	File FileRepository
+	User          UserRepository
+	Role          RoleRepository
+	Session       SessionRepository
+	RefreshToken  RefreshTokenRepository
+	AccessToken   AccessTokenRepository
+	PasswordReset PasswordResetRepository
+	MFA           MFARepository
+	LoginAttempt  LoginAttemptRepository
+	File          FileRepository
+	Upload        UploadRepository
+	SigningKey    SigningKeyRepository
+	ExportJob     ExportJobRepository
+	Identity      IdentityRepository
 }
 
 func NewRepository(db *gorm.DB) *Repository {
 	return &Repository{
-		User: NewUserRepository(db),
-		File: NewFileRepository(db),
+		User:          NewUserRepository(db),
+		Role:          NewRoleRepository(db),
+		Session:       NewSessionRepository(db),
+		RefreshToken:  NewRefreshTokenRepository(db),
+		AccessToken:   NewAccessTokenRepository(db),
+		PasswordReset: NewPasswordResetRepository(db),
+		MFA:           NewMFARepository(db),
+		LoginAttempt:  NewLoginAttemptRepository(db),
+		File:          NewFileRepository(db),
+		Upload:        NewUploadRepository(db),
+		SigningKey:    NewSigningKeyRepository(db),
+		ExportJob:     NewExportJobRepository(db),
+		Identity:      NewIdentityRepository(db),
 	}
 }
@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"hackathon/models"
+
+	"gorm.io/gorm"
+)
+
+type RoleRepository interface {
+	List() ([]models.Role, error)
+	FindByName(name string) (*models.Role, error)
+	FindByNames(names []string) ([]models.Role, error)
+	RolesForUser(userID uint) ([]models.Role, error)
+	PermissionsForUser(userID uint) ([]string, error)
+	SetUserRoles(user *models.User, roles []models.Role) error
+}
+
+type roleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &roleRepository{db: db}
+}
+
+func (r *roleRepository) List() ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Preload("Permissions").Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *roleRepository) FindByName(name string) (*models.Role, error) {
+	var role models.Role
+	err := r.db.Where("name = ?", name).First(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *roleRepository) FindByNames(names []string) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Where("name IN ?", names).Find(&roles).Error
+	return roles, err
+}
+
+func (r *roleRepository) RolesForUser(userID uint) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Find(&roles).Error
+	return roles, err
+}
+
+// PermissionsForUser returns the names of the permissions granted by all roles of the user.
+func (r *roleRepository) PermissionsForUser(userID uint) ([]string, error) {
+	var permissions []string
+	err := r.db.Model(&models.Permission{}).
+		Distinct("permissions.name").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Order("permissions.name").
+		Pluck("permissions.name", &permissions).Error
+	return permissions, err
+}
+
+func (r *roleRepository) SetUserRoles(user *models.User, roles []models.Role) error {
+	return r.db.Model(user).Association("Roles").Replace(roles)
+}
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SessionRepository interface {
+	Create(session *models.Session) error
+	FindByID(id string) (*models.Session, error)
+	ListActiveByUser(userID uint, seenAfter time.Time) ([]models.Session, error)
+	Touch(session *models.Session, seenAt time.Time) error
+	MarkReauthenticated(session *models.Session, at time.Time) error
+	Revoke(session *models.Session) error
+	RevokeAllForUser(userID uint) error
+}
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *sessionRepository) FindByID(id string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.Where("id = ?", id).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) ListActiveByUser(userID uint, seenAfter time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, seenAfter).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *sessionRepository) Touch(session *models.Session, seenAt time.Time) error {
+	if err := r.db.Model(session).Update("last_seen_at", seenAt).Error; err != nil {
+		return err
+	}
+	session.LastSeenAt = seenAt
+	return nil
+}
+
+func (r *sessionRepository) MarkReauthenticated(session *models.Session, at time.Time) error {
+	if err := r.db.Model(session).Update("reauthenticated_at", at).Error; err != nil {
+		return err
+	}
+	session.ReauthenticatedAt = &at
+	return nil
+}
+
+func (r *sessionRepository) Revoke(session *models.Session) error {
+	now := time.Now()
+	if err := r.db.Model(session).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	session.RevokedAt = &now
+	return nil
+}
+
+func (r *sessionRepository) RevokeAllForUser(userID uint) error {
+	return r.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
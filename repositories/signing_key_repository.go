@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SigningKeyRepository interface {
+	Create(key *models.SigningKey) error
+	FindByID(id string) (*models.SigningKey, error)
+	List() ([]models.SigningKey, error)
+	Promote(key *models.SigningKey) error
+	RetireDeactivatedBefore(cutoff time.Time) ([]models.SigningKey, error)
+}
+
+type signingKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyRepository(db *gorm.DB) SigningKeyRepository {
+	return &signingKeyRepository{db: db}
+}
+
+func (r *signingKeyRepository) Create(key *models.SigningKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *signingKeyRepository) FindByID(id string) (*models.SigningKey, error) {
+	var key models.SigningKey
+	err := r.db.Where("id = ?", id).First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *signingKeyRepository) List() ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.Order("created_at").Find(&keys).Error
+	return keys, err
+}
+
+// Promote makes the key the active one. The previously active key is kept for verification.
+func (r *signingKeyRepository) Promote(key *models.SigningKey) error {
+	now := time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.SigningKey{}).
+			Where("status = ? AND id <> ?", models.SigningKeyActive, key.ID).
+			Updates(map[string]interface{}{"status": models.SigningKeyVerify, "deactivated_at": now}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(key).
+			Updates(map[string]interface{}{"status": models.SigningKeyActive, "activated_at": now, "deactivated_at": nil}).Error
+		if err != nil {
+			return err
+		}
+		key.Status = models.SigningKeyActive
+		key.ActivatedAt = &now
+		key.DeactivatedAt = nil
+		return nil
+	})
+}
+
+// RetireDeactivatedBefore retires the verification keys that were replaced before cutoff and
+// returns them.
+func (r *signingKeyRepository) RetireDeactivatedBefore(cutoff time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("status = ? AND deactivated_at < ?", models.SigningKeyVerify, cutoff).Find(&keys).Error
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		ids := make([]string, len(keys))
+		for i := range keys {
+			ids[i] = keys[i].ID
+		}
+		return tx.Model(&models.SigningKey{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{"status": models.SigningKeyRetired, "retired_at": time.Now()}).Error
+	})
+	return keys, err
+}
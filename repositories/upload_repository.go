@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"errors"
+	"hackathon/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrStaleOffset is returned when an upload's offset changed since it was read.
+var ErrStaleOffset = errors.New("upload offset changed concurrently")
+
+type UploadRepository interface {
+	Create(upload *models.Upload) error
+	FindByIDAndOwner(id string, ownerID uint) (*models.Upload, error)
+	ListByOwner(ownerID uint) ([]models.Upload, error)
+	UpdateOffset(upload *models.Upload, newOffset int64, expiresAt time.Time) error
+	SetFileID(upload *models.Upload, fileID uint) error
+	ListExpired(now time.Time, limit int) ([]models.Upload, error)
+	Delete(upload *models.Upload) error
+}
+
+type uploadRepository struct {
+	db *gorm.DB
+}
+
+func NewUploadRepository(db *gorm.DB) UploadRepository {
+	return &uploadRepository{db: db}
+}
+
+func (r *uploadRepository) Create(upload *models.Upload) error {
+	return r.db.Create(upload).Error
+}
+
+func (r *uploadRepository) FindByIDAndOwner(id string, ownerID uint) (*models.Upload, error) {
+	var upload models.Upload
+	err := r.db.Where("id = ? AND owner_id = ?", id, ownerID).First(&upload).Error
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *uploadRepository) ListByOwner(ownerID uint) ([]models.Upload, error) {
+	var uploads []models.Upload
+	err := r.db.Where("owner_id = ?", ownerID).Order("created_at").Find(&uploads).Error
+	return uploads, err
+}
+
+// UpdateOffset moves the offset and the expiry forward only if the offset still has the value the
+// caller read.
+func (r *uploadRepository) UpdateOffset(upload *models.Upload, newOffset int64, expiresAt time.Time) error {
+	result := r.db.Model(&models.Upload{}).
+		Where("id = ? AND upload_offset = ?", upload.ID, upload.Offset).
+		Updates(map[string]interface{}{"upload_offset": newOffset, "expires_at": expiresAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStaleOffset
+	}
+	upload.Offset = newOffset
+	upload.ExpiresAt = expiresAt
+	return nil
+}
+
+func (r *uploadRepository) SetFileID(upload *models.Upload, fileID uint) error {
+	if err := r.db.Model(upload).Update("file_id", fileID).Error; err != nil {
+		return err
+	}
+	upload.FileID = &fileID
+	return nil
+}
+
+func (r *uploadRepository) ListExpired(now time.Time, limit int) ([]models.Upload, error) {
+	var uploads []models.Upload
+	err := r.db.Where("expires_at < ?", now).
+		Order("expires_at").
+		Limit(limit).
+		Find(&uploads).Error
+	return uploads, err
+}
+
+func (r *uploadRepository) Delete(upload *models.Upload) error {
+	return r.db.Delete(upload).Error
+}
@@ -1,16 +1,48 @@
 package repositories
 
 import (
+	"errors"
 	"hackathon/models"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 )
 
+// Create returns these when the username or the email address is already taken.
+var (
+	ErrDuplicateUsername = errors.New("username already exists")
+	ErrDuplicateEmail    = errors.New("email already exists")
+)
+
+// uniqueViolation is the Postgres error code of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// UserListQuery selects one page of users, ordered by ID. AfterID is the last ID of the previous page.
+type UserListQuery struct {
+	UsernameContains string
+	AfterID          uint
+	Limit            int
+}
+
 type UserRepository interface {
 	Create(user *models.User) error
 	FindByUsername(username string) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
 	FindByID(id uint) (*models.User, error)
+	List(query UserListQuery) ([]models.User, error)
+	Count(query UserListQuery) (int64, error)
 	UpdateRevokeTokensBefore(user *models.User, timestamp int64) error
+	UpdateDisabled(user *models.User, disabled bool) error
+	UpdatePasswordResetRequired(user *models.User, required bool) error
+	UpdatePassword(user *models.User, hashed string) error
+	UpdatePasswordHash(user *models.User, hashed string) error
+	UpdateProfile(user *models.User, displayName string, avatarFileID *uint) error
+	UpdateEmail(user *models.User, email string) error
+	UpdateEmailVerified(user *models.User, verified bool) error
+	UpdateMFA(user *models.User, secret string, enabled bool) error
+	AdvanceTOTPStep(user *models.User, step int64) (bool, error)
+	Anonymize(user *models.User, username, hashed string) error
 }
 
 type userRepository struct {
@@ -22,7 +54,15 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 }
 
 func (r *userRepository) Create(user *models.User) error {
-	return r.db.Create(user).Error
+	err := r.db.Create(user).Error
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.TableName == "users" {
+		if pgErr.ConstraintName == "idx_users_email" {
+			return ErrDuplicateEmail
+		}
+		return ErrDuplicateUsername
+	}
+	return err
 }
 
 func (r *userRepository) FindByUsername(username string) (*models.User, error) {
@@ -34,6 +74,15 @@ func (r *userRepository) FindByUsername(username string) (*models.User, error) {
 	return &user, nil
 }
 
+func (r *userRepository) FindByEmail(email string) (*models.User, error) {
+	var user models.User
+	err := r.db.Where("lower(email) = lower(?)", email).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (r *userRepository) FindByID(id uint) (*models.User, error) {
 	var user models.User
 	err := r.db.Where("id = ?", id).First(&user).Error
@@ -46,3 +95,197 @@ func (r *userRepository) FindByID(id uint) (*models.User, error) {
 func (r *userRepository) UpdateRevokeTokensBefore(user *models.User, timestamp int64) error {
 	return r.db.Model(user).Update("revoke_tokens_before", timestamp).Error
 }
+
+func (r *userRepository) List(query UserListQuery) ([]models.User, error) {
+	var users []models.User
+	err := r.filtered(query).
+		Where("id > ?", query.AfterID).
+		Order("id").
+		Limit(query.Limit).
+		Find(&users).Error
+	return users, err
+}
+
+// Count returns the number of users matching the query filters, ignoring pagination.
+func (r *userRepository) Count(query UserListQuery) (int64, error) {
+	var total int64
+	err := r.filtered(query).Count(&total).Error
+	return total, err
+}
+
+func (r *userRepository) filtered(query UserListQuery) *gorm.DB {
+	db := r.db.Model(&models.User{})
+	if query.UsernameContains != "" {
+		db = db.Where(`username ILIKE ? ESCAPE '\'`, "%"+escapeLike(query.UsernameContains)+"%")
+	}
+	return db
+}
+
+func (r *userRepository) UpdateDisabled(user *models.User, disabled bool) error {
+	if err := r.db.Model(user).Update("disabled", disabled).Error; err != nil {
+		return err
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+func (r *userRepository) UpdatePasswordResetRequired(user *models.User, required bool) error {
+	if err := r.db.Model(user).Update("password_reset_required", required).Error; err != nil {
+		return err
+	}
+	user.PasswordResetRequired = required
+	return nil
+}
+
+// UpdatePassword sets a new password hash chosen by the user and clears the password reset
+// requirement.
+func (r *userRepository) UpdatePassword(user *models.User, hashed string) error {
+	err := r.db.Model(user).Updates(map[string]interface{}{
+		"password":                hashed,
+		"password_reset_required": false,
+		"no_password":             false,
+	}).Error
+	if err != nil {
+		return err
+	}
+	user.Password = hashed
+	user.PasswordResetRequired = false
+	user.NoPassword = false
+	return nil
+}
+
+// UpdatePasswordHash replaces the hash of the unchanged password with an upgraded one. It does
+// nothing if the password was changed since the user was read.
+func (r *userRepository) UpdatePasswordHash(user *models.User, hashed string) error {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", hashed)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		user.Password = hashed
+	}
+	return nil
+}
+
+func (r *userRepository) UpdateProfile(user *models.User, displayName string, avatarFileID *uint) error {
+	err := r.db.Model(user).Updates(map[string]interface{}{
+		"display_name":   displayName,
+		"avatar_file_id": avatarFileID,
+	}).Error
+	if err != nil {
+		return err
+	}
+	user.DisplayName = displayName
+	user.AvatarFileID = avatarFileID
+	return nil
+}
+
+// UpdateEmail sets a new, not yet verified email address.
+func (r *userRepository) UpdateEmail(user *models.User, email string) error {
+	err := r.db.Model(user).Updates(map[string]interface{}{
+		"email":          email,
+		"email_verified": false,
+	}).Error
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == "idx_users_email" {
+		return ErrDuplicateEmail
+	}
+	if err != nil {
+		return err
+	}
+	user.Email = &email
+	user.EmailVerified = false
+	return nil
+}
+
+func (r *userRepository) UpdateEmailVerified(user *models.User, verified bool) error {
+	if err := r.db.Model(user).Update("email_verified", verified).Error; err != nil {
+		return err
+	}
+	user.EmailVerified = verified
+	return nil
+}
+
+// UpdateMFA sets the TOTP secret and whether two-factor authentication is enabled. Changing
+// either resets the last used time step.
+func (r *userRepository) UpdateMFA(user *models.User, secret string, enabled bool) error {
+	err := r.db.Model(user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"mfa_enabled":    enabled,
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = secret
+	user.MFAEnabled = enabled
+	user.TOTPLastStep = 0
+	return nil
+}
+
+// AdvanceTOTPStep atomically records the time step of an accepted TOTP code. It returns false if
+// a code of that step or a later one was already used.
+func (r *userRepository) AdvanceTOTPStep(user *models.User, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+// Anonymize strips the user of personal data for good: the username and password are replaced,
+// the email address and profile are cleared and the account is disabled. Everything that signs
+// the user in or links other rows to them, such as sessions, tokens, roles, file grants and
+// external identities, is deleted in the same transaction.
+func (r *userRepository) Anonymize(user *models.User, username, hashed string) error {
+	now := time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.Session{}, &models.RefreshToken{}, &models.PersonalAccessToken{},
+			&models.PasswordResetToken{}, &models.MFAChallenge{}, &models.RecoveryCode{},
+			&models.FileGrant{}, &models.ExportJob{}, &models.Identity{}, &models.OIDCLoginState{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(user).Association("Roles").Clear(); err != nil {
+			return err
+		}
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"username":                username,
+			"password":                hashed,
+			"email":                   nil,
+			"email_verified":          false,
+			"display_name":            "",
+			"avatar_file_id":          nil,
+			"disabled":                true,
+			"password_reset_required": false,
+			"mfa_enabled":             false,
+			"totp_secret":             "",
+			"totp_last_step":          0,
+			"revoke_tokens_before":    now.Unix(),
+			"anonymized_at":           now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		*user = models.User{
+			ID:                 user.ID,
+			Username:           username,
+			Password:           hashed,
+			Disabled:           true,
+			RevokeTokensBefore: now.Unix(),
+			AnonymizedAt:       &now,
+		}
+		return nil
+	})
+}
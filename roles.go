@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"hackathon/models"
+	"hackathon/services"
+	"os"
+	"strings"
+)
+
+const rolesUsage = `Usage: hackathon-app roles <command>
+
+Commands:
+  list                   List roles and their permissions
+  grant USERNAME ROLE    Give a role to a user, e.g. to create the first admin
+  revoke USERNAME ROLE   Take a role away from a user
+
+Role changes apply from the user's next login or token refresh.
+`
+
+// runRolesCommand runs the role management command line and returns the exit code.
+func runRolesCommand(roles *services.RoleService, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, rolesUsage)
+		return 2
+	}
+
+	var err error
+	var granted []models.Role
+	switch {
+	case args[0] == "list":
+		if granted, err = roles.ListRoles(); err == nil {
+			for _, role := range granted {
+				permissions := make([]string, len(role.Permissions))
+				for i, permission := range role.Permissions {
+					permissions[i] = permission.Name
+				}
+				fmt.Printf("%s\t%s\n", role.Name, strings.Join(permissions, ","))
+			}
+		}
+	case args[0] == "grant" && len(args) == 3:
+		if granted, err = roles.GrantRole(args[1], args[2]); err == nil {
+			printUserRoles(args[1], granted)
+		}
+	case args[0] == "revoke" && len(args) == 3:
+		if granted, err = roles.RevokeRole(args[1], args[2]); err == nil {
+			printUserRoles(args[1], granted)
+		}
+	default:
+		fmt.Fprint(os.Stderr, rolesUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func printUserRoles(username string, roles []models.Role) {
+	names := make([]string, len(roles))
+	for i := range roles {
+		names[i] = roles[i].Name
+	}
+	fmt.Printf("%s now has roles: %s\n", username, strings.Join(names, ", "))
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"hackathon/models"
+	"hackathon/repositories"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AccountService deletes accounts on request of their users.
+type AccountService struct {
+	userRepo repositories.UserRepository
+	mfaRepo  repositories.MFARepository
+	auth     *AuthService
+	files    *FileService
+	uploads  *UploadService
+	exports  *ExportService
+}
+
+func NewAccountService(userRepo repositories.UserRepository, mfaRepo repositories.MFARepository, auth *AuthService, files *FileService, uploads *UploadService, exports *ExportService) *AccountService {
+	return &AccountService{userRepo: userRepo, mfaRepo: mfaRepo, auth: auth, files: files, uploads: uploads, exports: exports}
+}
+
+// DeleteAccount erases the account of the user after checking their password (or a recent
+// reauthentication of the session if they have none) and, if two-factor authentication is enabled,
+// a code, which is throttled like the password. Every file of the user is purged, uploads in progress are cancelled and exports are
+// deleted. The user row itself is anonymized rather than deleted, which also revokes every session
+// and token. A failure leaves the account usable, so deleting it can be retried.
+func (s *AccountService) DeleteAccount(ctx context.Context, user *models.User, session *models.Session, password, code string, client ClientInfo) error {
+	if err := s.auth.confirmUser(user, session, password, client); err != nil {
+		return err
+	}
+	if user.MFAEnabled {
+		if err := attemptMFACode(s.auth.throttle, s.userRepo, s.mfaRepo, user, code, client.IP); err != nil {
+			return err
+		}
+	}
+
+	if err := s.uploads.TerminateAll(user.ID); err != nil {
+		return err
+	}
+	if err := s.exports.DeleteForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	purged, err := s.files.PurgeOwner(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	suffix, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+	// Nobody knows this password, so the account can never be signed in to again.
+	unusable, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	hashed, err := s.auth.hashPassword(unusable)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.Anonymize(user, "deleted-"+suffix, hashed); err != nil {
+		return err
+	}
+	log.Info().Uint("user_id", user.ID).Int("files", purged).Msg("Account deleted")
+	return nil
+}
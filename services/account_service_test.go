@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hackathon/models"
+	"hackathon/storage"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountService_DeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	client := ClientInfo{IP: "192.0.2.1"}
+	session := &models.Session{ID: "session", UserID: 1}
+	newService := func(t *testing.T) (*AccountService, *models.User, *MockFileRepository) {
+		hashed, err := testHasher.Hash("correct password")
+		assert.NoError(t, err)
+		email := "dev@example.com"
+		user := &models.User{ID: 1, Username: "dev", Email: &email, Password: hashed, DisplayName: "Dev"}
+		users := &MockUserRepository{users: map[string]*models.User{"dev": user}}
+
+		uploads, fileRepo := newTestUploadService(t)
+		jobs := &MockExportJobRepository{jobs: make(map[string]*models.ExportJob)}
+		exports := NewExportService(users, newMockRoleRepository(), fileRepo, jobs, uploads.files.storage, 1, time.Hour)
+		service := NewAccountService(users, &MockMFARepository{}, newTestAuthService(users), uploads.files, uploads, exports)
+		return service, user, fileRepo
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		service, user, _ := newService(t)
+
+		err := service.DeleteAccount(ctx, user, session, "wrong password", "", client)
+		assert.Equal(t, ErrWrongPassword, err)
+		assert.Equal(t, "dev", user.Username)
+		assert.False(t, user.Disabled)
+	})
+
+	t.Run("two-factor code is required when enabled", func(t *testing.T) {
+		service, user, _ := newService(t)
+		user.MFAEnabled = true
+		user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+
+		err := service.DeleteAccount(ctx, user, session, "correct password", "000000", client)
+		assert.Equal(t, ErrInvalidMFACode, err)
+		assert.Equal(t, "dev", user.Username)
+	})
+
+	t.Run("wrong two-factor codes are throttled", func(t *testing.T) {
+		service, user, _ := newService(t)
+		user.MFAEnabled = true
+		user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+
+		// The correct password must not reset the count, and the username is locked out from
+		// every client.
+		for i := range testThrottlePolicy.FreeAttempts + 1 {
+			err := service.DeleteAccount(ctx, user, session, "correct password", "000000", ClientInfo{IP: fmt.Sprintf("192.0.2.%d", i+10)})
+			assert.Equal(t, ErrInvalidMFACode, err)
+		}
+		err := service.DeleteAccount(ctx, user, session, "correct password", "000000", client)
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+		assert.Equal(t, "dev", user.Username)
+	})
+
+	t.Run("everything is erased", func(t *testing.T) {
+		service, user, fileRepo := newService(t)
+		files := service.files
+		kept, err := files.UploadFileStream(ctx, strings.NewReader("private content"), "private.txt", 15, 1)
+		assert.NoError(t, err)
+		shared, err := files.UploadFileStream(ctx, strings.NewReader("shared content"), "mine.txt", 14, 1)
+		assert.NoError(t, err)
+		others, err := files.UploadFileStream(ctx, strings.NewReader("shared content"), "theirs.txt", 14, 2)
+		assert.NoError(t, err)
+		assert.NoError(t, files.DeleteFile(shared.ID, 1))
+		upload, err := service.uploads.Create(ctx, 1, 100, "partial.txt", "")
+		assert.NoError(t, err)
+		job, _, err := service.exports.StartJob(user)
+		assert.NoError(t, err)
+		assert.NoError(t, service.exports.RunJob(ctx, job))
+
+		assert.NoError(t, service.DeleteAccount(ctx, user, session, "correct password", "", client))
+
+		assert.True(t, strings.HasPrefix(user.Username, "deleted-"))
+		assert.Nil(t, user.Email)
+		assert.Empty(t, user.DisplayName)
+		assert.True(t, user.Disabled)
+		assert.NotNil(t, user.AnonymizedAt)
+		ok, _, err := testHasher.Verify("correct password", user.Password)
+		assert.NoError(t, err)
+		assert.False(t, ok, "the password no longer works")
+
+		assert.Len(t, fileRepo.files, 1, "trashed files are purged too")
+		assert.Contains(t, fileRepo.files, others.ID)
+		_, err = files.storage.Stat(ctx, blobKey(kept.Digest))
+		assert.Equal(t, storage.ErrNotFound, err)
+		_, err = files.storage.Stat(ctx, blobKey(others.Digest))
+		assert.NoError(t, err, "content shared with other users' files is kept")
+
+		_, err = service.uploads.Get(1, upload.ID)
+		assert.Equal(t, ErrUploadNotFound, err)
+		_, err = os.Stat(service.uploads.stagingPath(upload.ID))
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = service.exports.GetJob(1, job.ID)
+		assert.Equal(t, ErrExportNotFound, err)
+		_, err = files.storage.Stat(ctx, exportKey(job.ID))
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+}
@@ -1,76 +1,553 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"hackathon/dto"
 	"hackathon/models"
+	"hackathon/pkg/keyring"
+	"hackathon/pkg/passhash"
 	"hackathon/repositories"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/rs/zerolog/log"
 )
 
 var (
-	ErrUserExists   = errors.New("username already exists")
-	ErrInvalidCreds = errors.New("invalid credentials")
+	ErrUserExists          = errors.New("username already exists")
+	ErrEmailExists         = errors.New("email address already in use")
+	ErrEmailNotVerified    = errors.New("email address not verified")
+	ErrInvalidCreds        = errors.New("invalid credentials")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, please login again")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrAccountDisabled     = errors.New("account is disabled")
+	ErrPasswordResetNeeded = errors.New("password reset required")
+	ErrWrongPassword       = errors.New("current password is incorrect")
+	ErrReauthRequired      = errors.New("sign in again with single sign-on to confirm it is you")
 )
 
+// ReauthTTL is how long a single sign-on reauthentication confirms sensitive changes for accounts
+// without a password.
+const ReauthTTL = 5 * time.Minute
+
+// ClientInfo describes the client a login comes from. It is recorded on the session.
+type ClientInfo struct {
+	Device    string
+	UserAgent string
+	IP        string
+}
+
 type JwtCustomClaims struct {
-	Username string `json:"username"`
+	Username    string   `json:"username"`
+	Permissions []string `json:"permissions"`
 	jwt.RegisteredClaims
 }
 
+// AuthOptions configures the AuthService.
+type AuthOptions struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	// LoginRequiresVerifiedEmail rejects logins until the user has confirmed their email address.
+	// Accounts without an address can still login, so that they can add one.
+	LoginRequiresVerifiedEmail bool
+	// MFAIssuer is the name authenticator apps show for the account.
+	MFAIssuer string
+	// Hasher hashes new passwords and verifies stored ones. Hashes of older schemes or parameters
+	// are upgraded on login.
+	Hasher *passhash.Hasher
+	// PasswordPolicy is checked for new passwords.
+	PasswordPolicy PasswordPolicy
+	// UsernameThrottle and IPThrottle limit failed logins per username and per client IP.
+	UsernameThrottle ThrottlePolicy
+	IPThrottle       ThrottlePolicy
+	// MailThrottle and MailIPThrottle limit the password reset and verification mails requested
+	// per recipient and per client IP.
+	MailThrottle   ThrottlePolicy
+	MailIPThrottle ThrottlePolicy
+}
+
 type AuthService struct {
-	userRepo  repositories.UserRepository
-	jwtSecret []byte
-	expHours  int
+	userRepo        repositories.UserRepository
+	sessionRepo     repositories.SessionRepository
+	tokenRepo       repositories.RefreshTokenRepository
+	accessTokenRepo repositories.AccessTokenRepository
+	roleRepo        repositories.RoleRepository
+	mfaRepo         repositories.MFARepository
+	throttle        *LoginThrottle
+	keys            *keyring.Keyring
+	opts            AuthOptions
+	dummy           func() dummyHash
+}
+
+// dummyHash is checked for unknown usernames: a hash of the scheme that is slowest to verify, and
+// how long verifying it took. Failed logins are padded to that cost, so that neither unknown
+// usernames nor accounts with a legacy hash stand out by their response time.
+type dummyHash struct {
+	hash string
+	cost time.Duration
+}
+
+func newDummyHash(hasher *passhash.Hasher) dummyHash {
+	dummies, err := hasher.Dummies()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create dummy password hashes")
+		return dummyHash{}
+	}
+	var slowest dummyHash
+	for _, hash := range dummies {
+		start := time.Now()
+		_, _, _ = hasher.Verify("wrong password", hash)
+		if cost := time.Since(start); cost > slowest.cost {
+			slowest = dummyHash{hash: hash, cost: cost}
+		}
+	}
+	return slowest
 }
 
-func NewAuthService(repo repositories.UserRepository, secret []byte, expHours int) *AuthService {
-	return &AuthService{userRepo: repo, jwtSecret: secret, expHours: expHours}
+func NewAuthService(repo repositories.UserRepository, sessionRepo repositories.SessionRepository, tokenRepo repositories.RefreshTokenRepository, accessTokenRepo repositories.AccessTokenRepository, roleRepo repositories.RoleRepository, mfaRepo repositories.MFARepository, throttle *LoginThrottle, keys *keyring.Keyring, opts AuthOptions) *AuthService {
+	s := &AuthService{userRepo: repo, sessionRepo: sessionRepo, tokenRepo: tokenRepo, accessTokenRepo: accessTokenRepo, roleRepo: roleRepo, mfaRepo: mfaRepo, throttle: throttle, keys: keys, opts: opts}
+	s.dummy = sync.OnceValue(func() dummyHash {
+		return newDummyHash(s.opts.Hasher)
+	})
+	return s
 }
 
-func (s *AuthService) Register(username, password string) error {
-	hashed, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	user := &models.User{Username: username, Password: string(hashed)}
+// Register creates an account with the default user role. Its email address is unverified until
+// the link sent by EmailService.SendVerification is opened.
+func (s *AuthService) Register(username, email, password string) (*models.User, error) {
+	email = normalizeEmail(email)
+	if _, err := s.userRepo.FindByEmail(email); err == nil {
+		return nil, ErrEmailExists
+	} else if !errors.Is(err, repositories.ErrRecordNotFound) {
+		return nil, err
+	}
+	role, err := s.roleRepo.FindByName(models.RoleUser)
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := s.newPasswordHash(username, password)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{Username: username, Email: &email, Password: hashed, Roles: []models.Role{*role}}
 	if err := s.userRepo.Create(user); err != nil {
-		return ErrUserExists
+		switch {
+		case errors.Is(err, repositories.ErrDuplicateUsername):
+			return nil, ErrUserExists
+		case errors.Is(err, repositories.ErrDuplicateEmail):
+			return nil, ErrEmailExists
+		}
+		return nil, err
 	}
-	return nil
+	return user, nil
 }
 
-func (s *AuthService) Login(username, password string) (dto.TokenResponse, error) {
+// Login checks the credentials and starts a new session for the client. For users with
+// two-factor authentication it returns a challenge instead, completed by VerifyMFA. After too many
+// failures for the username or the client IP, logins are rejected with a *TooManyAttemptsError
+// without checking the password. Unknown usernames are checked against a dummy hash of the slowest
+// scheme and failed logins are padded to its cost, so that they reveal neither which accounts exist
+// nor which still have a legacy hash.
+func (s *AuthService) Login(username, password string, client ClientInfo) (dto.LoginResponse, error) {
+	if err := s.throttle.Attempt(username, client.IP); err != nil {
+		return dto.LoginResponse{}, err
+	}
+	dummy := s.dummy()
+	start := time.Now()
 	user, err := s.userRepo.FindByUsername(username)
+	if errors.Is(err, repositories.ErrRecordNotFound) {
+		_, _, _ = s.opts.Hasher.Verify(password, dummy.hash)
+		sleepUntil(start.Add(dummy.cost))
+		return dto.LoginResponse{}, ErrInvalidCreds
+	}
+	if err != nil {
+		return dto.LoginResponse{}, err
+	}
+	ok, rehash, err := s.opts.Hasher.Verify(password, user.Password)
 	if err != nil {
-		return dto.TokenResponse{}, ErrInvalidCreds
+		return dto.LoginResponse{}, err
+	}
+	if !ok {
+		sleepUntil(start.Add(dummy.cost))
+		return dto.LoginResponse{}, ErrInvalidCreds
+	}
+	if rehash {
+		s.upgradePasswordHash(user, password)
+	}
+	// With two-factor authentication the login only succeeds with the code: the failures of the
+	// username, wrong codes included, are kept until VerifyMFA.
+	if user.MFAEnabled {
+		err = s.throttle.Release(username, client.IP)
+	} else {
+		err = s.throttle.Success(username, client.IP)
+	}
+	if err != nil {
+		return dto.LoginResponse{}, err
+	}
+	if user.Disabled {
+		return dto.LoginResponse{}, ErrAccountDisabled
+	}
+	if user.PasswordResetRequired {
+		return dto.LoginResponse{}, ErrPasswordResetNeeded
+	}
+	if s.opts.LoginRequiresVerifiedEmail && user.Email != nil && !user.EmailVerified {
+		return dto.LoginResponse{}, ErrEmailNotVerified
+	}
+
+	if user.MFAEnabled {
+		return s.issueMFAChallenge(user, client)
+	}
+	tokens, err := s.startSession(user, client)
+	if err != nil {
+		return dto.LoginResponse{}, err
+	}
+	return dto.LoginResponse{TokenResponse: &tokens}, nil
+}
+
+// VerifyMFA completes a login with a TOTP or recovery code and starts the session. Wrong codes
+// count as failed logins of the user from ip, so they are limited across challenges too.
+func (s *AuthService) VerifyMFA(challengeToken, code, ip string) (dto.TokenResponse, error) {
+	challenge, err := s.mfaRepo.FindChallengeByHash(hashToken(challengeToken))
+	if err != nil || challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		return dto.TokenResponse{}, ErrInvalidMFAChallenge
+	}
+	allowed, err := s.mfaRepo.RecordAttempt(challenge, maxMFAAttempts)
+	if err != nil {
+		return dto.TokenResponse{}, err
+	}
+	if !allowed {
+		return dto.TokenResponse{}, ErrInvalidMFAChallenge
+	}
+
+	user, err := s.userRepo.FindByID(challenge.UserID)
+	if err != nil || !user.MFAEnabled {
+		return dto.TokenResponse{}, ErrInvalidMFAChallenge
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return dto.TokenResponse{}, ErrInvalidCreds
+	if user.Disabled {
+		return dto.TokenResponse{}, ErrAccountDisabled
+	}
+	if err := attemptMFACode(s.throttle, s.userRepo, s.mfaRepo, user, code, ip); err != nil {
+		return dto.TokenResponse{}, err
+	}
+	consumed, err := s.mfaRepo.ConsumeChallenge(challenge)
+	if err != nil {
+		return dto.TokenResponse{}, err
+	}
+	if !consumed {
+		return dto.TokenResponse{}, ErrInvalidMFAChallenge
+	}
+	return s.startSession(user, ClientInfo{Device: challenge.Device, UserAgent: challenge.UserAgent, IP: challenge.IP})
+}
+
+// newPasswordHash checks a new password of the user against the policy and hashes it.
+func (s *AuthService) newPasswordHash(username, password string) (string, error) {
+	if err := s.opts.PasswordPolicy.Check(username, password); err != nil {
+		return "", err
+	}
+	return s.hashPassword(password)
+}
+
+// hashPassword hashes a password with the current scheme.
+func (s *AuthService) hashPassword(password string) (string, error) {
+	return s.opts.Hasher.Hash(password)
+}
+
+// upgradePasswordHash replaces the stored hash of a verified password with one of the current
+// scheme. Failing is harmless, the old hash keeps working and is upgraded on a later login.
+func (s *AuthService) upgradePasswordHash(user *models.User, password string) {
+	hashed, err := s.hashPassword(password)
+	if err == nil {
+		err = s.userRepo.UpdatePasswordHash(user, hashed)
+	}
+	if err != nil {
+		log.Warn().Err(err).Uint("user_id", user.ID).Msg("Failed to upgrade password hash")
+	}
+}
+
+func (s *AuthService) issueMFAChallenge(user *models.User, client ClientInfo) (dto.LoginResponse, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return dto.LoginResponse{}, err
+	}
+	challenge := &models.MFAChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		Device:    client.Device,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		ExpiresAt: time.Now().Add(MFAChallengeTTL),
+	}
+	if err := s.mfaRepo.CreateChallenge(challenge); err != nil {
+		return dto.LoginResponse{}, err
+	}
+	return dto.LoginResponse{MFARequired: true, MFAToken: token, MFAExpiredTime: challenge.ExpiresAt.Unix()}, nil
+}
+
+// startSession creates a session for the client and issues its first tokens.
+func (s *AuthService) startSession(user *models.User, client ClientInfo) (dto.TokenResponse, error) {
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return dto.TokenResponse{}, err
+	}
+	now := time.Now()
+	session := &models.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		Device:     client.Device,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		LastSeenAt: now,
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return dto.TokenResponse{}, err
+	}
+	return s.issueTokens(user, session.ID)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token. Each refresh
+// token can be used once: presenting a used token again means it has leaked, so every token of
+// its family is revoked, the session is ended and the user has to login again.
+func (s *AuthService) Refresh(refreshToken string) (dto.TokenResponse, error) {
+	stored, err := s.tokenRepo.FindByHash(hashToken(refreshToken))
+	if err != nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return dto.TokenResponse{}, ErrInvalidRefreshToken
+	}
+
+	consumed, err := s.tokenRepo.MarkUsed(stored)
+	if err != nil {
+		return dto.TokenResponse{}, err
+	}
+	if !consumed {
+		if err := s.tokenRepo.RevokeFamily(stored.FamilyID); err != nil {
+			return dto.TokenResponse{}, err
+		}
+		if session, err := s.sessionRepo.FindByID(stored.FamilyID); err == nil && session.RevokedAt == nil {
+			if err := s.sessionRepo.Revoke(session); err != nil {
+				return dto.TokenResponse{}, err
+			}
+		}
+		return dto.TokenResponse{}, ErrRefreshTokenReused
+	}
+
+	session, err := s.sessionRepo.FindByID(stored.FamilyID)
+	if err != nil || session.RevokedAt != nil {
+		return dto.TokenResponse{}, ErrInvalidRefreshToken
+	}
+	if err := s.sessionRepo.Touch(session, time.Now()); err != nil {
+		return dto.TokenResponse{}, err
 	}
 
-	exp := time.Now().Add(time.Hour * time.Duration(s.expHours))
+	user, err := s.userRepo.FindByID(stored.UserID)
+	if err != nil {
+		return dto.TokenResponse{}, ErrInvalidRefreshToken
+	}
+	if user.RevokeTokensBefore > 0 && stored.CreatedAt.Unix() < user.RevokeTokensBefore {
+		return dto.TokenResponse{}, ErrInvalidRefreshToken
+	}
+	if user.Disabled {
+		return dto.TokenResponse{}, ErrAccountDisabled
+	}
+	return s.issueTokens(user, session.ID)
+}
+
+// RevokeToken signs the user out everywhere: every access token issued so far is rejected and
+// every session is ended.
+func (s *AuthService) RevokeToken(userID uint) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdateRevokeTokensBefore(user, time.Now().Unix()); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+	if err := s.tokenRepo.RevokeAllForUser(userID); err != nil {
+		return err
+	}
+	// RevokeTokensBefore has a precision of seconds, which lets personal access tokens created
+	// earlier in the same second through; revoking them outright does not.
+	return s.accessTokenRepo.RevokeAllForUser(userID)
+}
+
+// ChangePassword sets a new password after checking the current one, or a recent reauthentication
+// of the session for accounts without a password. Like a reset, it signs the
+// user out everywhere through RevokeToken; the client that changed the password gets a new session
+// and its tokens are returned. Wrong current passwords count as failed logins.
+func (s *AuthService) ChangePassword(user *models.User, session *models.Session, current, password string, client ClientInfo) (dto.TokenResponse, error) {
+	if err := s.confirmUser(user, session, current, client); err != nil {
+		return dto.TokenResponse{}, err
+	}
+
+	hashed, err := s.newPasswordHash(user.Username, password)
+	if err != nil {
+		return dto.TokenResponse{}, err
+	}
+	if err := s.userRepo.UpdatePassword(user, hashed); err != nil {
+		return dto.TokenResponse{}, err
+	}
+	if err := s.RevokeToken(user.ID); err != nil {
+		return dto.TokenResponse{}, err
+	}
+	return s.startSession(user, client)
+}
+
+// ChangeEmail sets the email address of the user after checking their password, or a recent
+// reauthentication of the session for accounts without a password. The new address is not
+// verified yet; the caller sends the verification mail.
+func (s *AuthService) ChangeEmail(user *models.User, session *models.Session, password, email string, client ClientInfo) error {
+	if err := s.confirmUser(user, session, password, client); err != nil {
+		return err
+	}
+	email = normalizeEmail(email)
+	if user.Email != nil && *user.Email == email {
+		return nil
+	}
+	if _, err := s.userRepo.FindByEmail(email); err == nil {
+		return ErrEmailExists
+	} else if !errors.Is(err, repositories.ErrRecordNotFound) {
+		return err
+	}
+	if err := s.userRepo.UpdateEmail(user, email); err != nil {
+		if errors.Is(err, repositories.ErrDuplicateEmail) {
+			return ErrEmailExists
+		}
+		return err
+	}
+	return nil
+}
+
+// confirmUser confirms that a signed in user is present before a sensitive change: with the
+// current password or, for accounts without one, a recent reauthentication of the session.
+func (s *AuthService) confirmUser(user *models.User, session *models.Session, password string, client ClientInfo) error {
+	if !user.NoPassword {
+		return s.checkPassword(user, password, client)
+	}
+	if session.ReauthenticatedAt == nil || time.Since(*session.ReauthenticatedAt) > ReauthTTL {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// checkPassword confirms the current password of a signed in user before a sensitive change.
+// Wrong passwords count as failed logins. As in Login, a correct password does not forget the
+// failures of a user with two-factor authentication, which include wrong codes.
+func (s *AuthService) checkPassword(user *models.User, password string, client ClientInfo) error {
+	if err := s.throttle.Attempt(user.Username, client.IP); err != nil {
+		return err
+	}
+	ok, _, err := s.opts.Hasher.Verify(password, user.Password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWrongPassword
+	}
+	if user.MFAEnabled {
+		return s.throttle.Release(user.Username, client.IP)
+	}
+	return s.throttle.Success(user.Username, client.IP)
+}
+
+// ListSessions returns the sessions of the user that are still active, most recently seen first.
+// Sessions idle for longer than the refresh token lifetime can no longer be used and are left out.
+func (s *AuthService) ListSessions(userID uint) ([]models.Session, error) {
+	return s.sessionRepo.ListActiveByUser(userID, time.Now().Add(-s.opts.RefreshTTL))
+}
+
+// RevokeSession signs a single session out. Its access tokens are rejected from then on and its
+// refresh tokens can no longer be used.
+func (s *AuthService) RevokeSession(userID uint, sessionID string) error {
+	session, err := s.sessionRepo.FindByID(sessionID)
+	if err != nil || session.UserID != userID || session.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+	if err := s.sessionRepo.Revoke(session); err != nil {
+		return err
+	}
+	return s.tokenRepo.RevokeFamily(session.ID)
+}
+
+// issueTokens signs a new access token for the session and stores a new refresh token in the
+// session's family. The access token carries the user ID as subject, the session ID as jti and
+// the permissions of the user, so a role change applies from the next refresh on.
+func (s *AuthService) issueTokens(user *models.User, sessionID string) (dto.TokenResponse, error) {
+	permissions, err := s.roleRepo.PermissionsForUser(user.ID)
+	if err != nil {
+		return dto.TokenResponse{}, err
+	}
+
+	now := time.Now()
+	exp := now.Add(s.opts.AccessTTL)
 	claims := &JwtCustomClaims{
 		user.Username,
+		permissions,
 		jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(exp),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ID:        strconv.Itoa(int(user.ID)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   strconv.Itoa(int(user.ID)),
+			ID:        sessionID,
 		},
 	}
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.jwtSecret)
+	token, err := s.keys.Sign(claims)
 	if err != nil {
 		return dto.TokenResponse{}, err
 	}
 
-	return dto.TokenResponse{Token: token, ExpiredTime: exp.Unix()}, nil
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return dto.TokenResponse{}, err
+	}
+	refreshExp := now.Add(s.opts.RefreshTTL)
+	stored := &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  sessionID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: refreshExp,
+	}
+	if err := s.tokenRepo.Create(stored); err != nil {
+		return dto.TokenResponse{}, err
+	}
+
+	return dto.TokenResponse{
+		Token:              token,
+		ExpiredTime:        exp.Unix(),
+		RefreshToken:       refreshToken,
+		RefreshExpiredTime: refreshExp.Unix(),
+	}, nil
 }
 
-func (s *AuthService) RevokeToken(userID uint) error {
-	user, err := s.userRepo.FindByID(userID)
-	if err != nil {
-		return err
+// normalizeEmail is the form email addresses are stored and looked up in.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func sleepUntil(t time.Time) {
+	if wait := time.Until(t); wait > 0 {
+		time.Sleep(wait)
 	}
-	return s.userRepo.UpdateRevokeTokensBefore(user, time.Now().Unix())
+}
+
+// newOpaqueToken returns a random, URL-safe token with 256 bits of entropy.
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken is how opaque tokens are stored: they are random enough that a plain SHA-256 is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
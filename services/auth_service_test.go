@@ -1,12 +1,18 @@
-
 package services
 
 import (
 	"errors"
 	"hackathon/models"
+	"hackathon/pkg/keyring"
+	"hackathon/pkg/passhash"
+	"hackathon/repositories"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -23,7 +29,7 @@ func (m *MockUserRepository) Create(user *models.User) error {
 		return m.err
 	}
 	if _, exists := m.users[user.Username]; exists {
-		return errors.New("username already exists")
+		return repositories.ErrDuplicateUsername
 	}
 	user.ID = uint(len(m.users) + 1)
 	m.users[user.Username] = user
@@ -37,7 +43,19 @@ func (m *MockUserRepository) FindByUsername(username string) (*models.User, erro
 	if user, exists := m.users[username]; exists {
 		return user, nil
 	}
-	return nil, errors.New("user not found")
+	return nil, repositories.ErrRecordNotFound
+}
+
+func (m *MockUserRepository) FindByEmail(email string) (*models.User, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	for _, user := range m.users {
+		if user.Email != nil && strings.EqualFold(*user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, repositories.ErrRecordNotFound
 }
 
 func (m *MockUserRepository) FindByID(id uint) (*models.User, error) {
@@ -49,7 +67,7 @@ func (m *MockUserRepository) FindByID(id uint) (*models.User, error) {
 			return user, nil
 		}
 	}
-	return nil, errors.New("user not found")
+	return nil, repositories.ErrRecordNotFound
 }
 
 func (m *MockUserRepository) UpdateRevokeTokensBefore(user *models.User, timestamp int64) error {
@@ -60,8 +78,230 @@ func (m *MockUserRepository) UpdateRevokeTokensBefore(user *models.User, timesta
 	return nil
 }
 
+func (m *MockUserRepository) List(query repositories.UserListQuery) ([]models.User, error) {
+	var users []models.User
+	for _, user := range m.matching(query) {
+		if user.ID > query.AfterID && len(users) < query.Limit {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+func (m *MockUserRepository) Count(query repositories.UserListQuery) (int64, error) {
+	return int64(len(m.matching(query))), nil
+}
+
+// matching returns the users matching the query filters, ordered by ID.
+func (m *MockUserRepository) matching(query repositories.UserListQuery) []*models.User {
+	var users []*models.User
+	for _, user := range m.users {
+		if strings.Contains(strings.ToLower(user.Username), strings.ToLower(query.UsernameContains)) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+func (m *MockUserRepository) UpdateDisabled(user *models.User, disabled bool) error {
+	user.Disabled = disabled
+	return nil
+}
+
+func (m *MockUserRepository) UpdatePasswordResetRequired(user *models.User, required bool) error {
+	user.PasswordResetRequired = required
+	return nil
+}
+
+func (m *MockUserRepository) UpdatePassword(user *models.User, hashed string) error {
+	user.Password = hashed
+	user.PasswordResetRequired = false
+	user.NoPassword = false
+	return nil
+}
+
+func (m *MockUserRepository) UpdatePasswordHash(user *models.User, hashed string) error {
+	user.Password = hashed
+	return nil
+}
+
+func (m *MockUserRepository) UpdateProfile(user *models.User, displayName string, avatarFileID *uint) error {
+	user.DisplayName = displayName
+	user.AvatarFileID = avatarFileID
+	return nil
+}
+
+func (m *MockUserRepository) UpdateEmail(user *models.User, email string) error {
+	user.Email = &email
+	user.EmailVerified = false
+	return nil
+}
+
+func (m *MockUserRepository) UpdateEmailVerified(user *models.User, verified bool) error {
+	user.EmailVerified = verified
+	return nil
+}
+
+func (m *MockUserRepository) UpdateMFA(user *models.User, secret string, enabled bool) error {
+	user.TOTPSecret = secret
+	user.MFAEnabled = enabled
+	user.TOTPLastStep = 0
+	return nil
+}
+
+func (m *MockUserRepository) AdvanceTOTPStep(user *models.User, step int64) (bool, error) {
+	if user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+func (m *MockUserRepository) Anonymize(user *models.User, username, hashed string) error {
+	if m.err != nil {
+		return m.err
+	}
+	delete(m.users, user.Username)
+	now := time.Now()
+	*user = models.User{ID: user.ID, Username: username, Password: hashed, Disabled: true, RevokeTokensBefore: now.Unix(), AnonymizedAt: &now}
+	m.users[username] = user
+	return nil
+}
+
+// MockRefreshTokenRepository is a mock implementation of RefreshTokenRepository for testing
+type MockRefreshTokenRepository struct {
+	tokens []*models.RefreshToken
+}
+
+func (m *MockRefreshTokenRepository) Create(token *models.RefreshToken) error {
+	token.ID = uint(len(m.tokens) + 1)
+	token.CreatedAt = time.Now()
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *MockRefreshTokenRepository) FindByHash(hash string) (*models.RefreshToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockRefreshTokenRepository) MarkUsed(token *models.RefreshToken) (bool, error) {
+	stored := m.tokens[token.ID-1]
+	if stored.UsedAt != nil || stored.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	stored.UsedAt = &now
+	return true, nil
+}
+
+func (m *MockRefreshTokenRepository) RevokeFamily(familyID string) error {
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MockRefreshTokenRepository) RevokeAllForUser(userID uint) error {
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.UserID == userID {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// MockSessionRepository is a mock implementation of SessionRepository for testing
+type MockSessionRepository struct {
+	sessions []*models.Session
+}
+
+func (m *MockSessionRepository) Create(session *models.Session) error {
+	session.CreatedAt = time.Now()
+	m.sessions = append(m.sessions, session)
+	return nil
+}
+
+func (m *MockSessionRepository) FindByID(id string) (*models.Session, error) {
+	for _, session := range m.sessions {
+		if session.ID == id {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockSessionRepository) ListActiveByUser(userID uint, seenAfter time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.LastSeenAt.After(seenAfter) {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *MockSessionRepository) Touch(session *models.Session, seenAt time.Time) error {
+	for _, stored := range m.sessions {
+		if stored.ID == session.ID {
+			stored.LastSeenAt = seenAt
+		}
+	}
+	session.LastSeenAt = seenAt
+	return nil
+}
+
+func (m *MockSessionRepository) MarkReauthenticated(session *models.Session, at time.Time) error {
+	for _, stored := range m.sessions {
+		if stored.ID == session.ID {
+			stored.ReauthenticatedAt = &at
+		}
+	}
+	session.ReauthenticatedAt = &at
+	return nil
+}
+
+func (m *MockSessionRepository) Revoke(session *models.Session) error {
+	now := time.Now()
+	for _, stored := range m.sessions {
+		if stored.ID == session.ID {
+			stored.RevokedAt = &now
+		}
+	}
+	session.RevokedAt = &now
+	return nil
+}
+
+func (m *MockSessionRepository) RevokeAllForUser(userID uint) error {
+	now := time.Now()
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// testHasher hashes new passwords with cheap argon2id parameters. Fixtures hashed with bcrypt verify
+// as legacy hashes.
+var testHasher = passhash.New(passhash.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}, passhash.Bcrypt{Cost: bcrypt.MinCost})
+
+var testThrottlePolicy = ThrottlePolicy{FreeAttempts: 3, MaxAttempts: 5, Lockout: time.Minute}
+
 func newTestAuthService(repo *MockUserRepository) *AuthService {
-	return NewAuthService(repo, []byte("secret"), 1)
+	throttle := NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), testThrottlePolicy, testThrottlePolicy)
+	return NewAuthService(repo, &MockSessionRepository{}, &MockRefreshTokenRepository{}, &MockAccessTokenRepository{}, newMockRoleRepository(), &MockMFARepository{}, throttle, keyring.New(keyring.NewHMACKey("test", []byte("secret"))), AuthOptions{AccessTTL: time.Hour, RefreshTTL: 24 * time.Hour, Hasher: testHasher})
 }
 
 func TestAuthService_Register(t *testing.T) {
@@ -69,15 +309,22 @@ func TestAuthService_Register(t *testing.T) {
 		repo := &MockUserRepository{users: make(map[string]*models.User)}
 		service := newTestAuthService(repo)
 
-		err := service.Register("testuser", "password")
+		registered, err := service.Register("testuser", " Dev@Example.com ", "password")
 		assert.NoError(t, err)
 
 		user, err := repo.FindByUsername("testuser")
 		assert.NoError(t, err)
 		assert.NotNil(t, user)
+		assert.Same(t, registered, user)
 		assert.Equal(t, "testuser", user.Username)
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("password"))
+		assert.Equal(t, "dev@example.com", *user.Email)
+		assert.False(t, user.EmailVerified)
+		assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"))
+		ok, _, err := testHasher.Verify("password", user.Password)
 		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Len(t, user.Roles, 1)
+		assert.Equal(t, models.RoleUser, user.Roles[0].Name)
 	})
 
 	t.Run("existing username", func(t *testing.T) {
@@ -88,10 +335,33 @@ func TestAuthService_Register(t *testing.T) {
 		}
 		service := newTestAuthService(repo)
 
-		err := service.Register("testuser", "password")
+		_, err := service.Register("testuser", "other@example.com", "password")
 		assert.Error(t, err)
 		assert.Equal(t, ErrUserExists, err)
 	})
+
+	t.Run("existing email address", func(t *testing.T) {
+		email := "dev@example.com"
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {Username: "testuser", Email: &email, Password: "hashedpassword"},
+			},
+		}
+		service := newTestAuthService(repo)
+
+		_, err := service.Register("other", "DEV@example.com", "password")
+		assert.Equal(t, ErrEmailExists, err)
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		dbErr := errors.New("connection refused")
+		repo := &MockUserRepository{users: make(map[string]*models.User), err: dbErr}
+		service := newTestAuthService(repo)
+
+		_, err := service.Register("testuser", "dev@example.com", "password")
+		assert.ErrorIs(t, err, dbErr)
+		assert.NotErrorIs(t, err, ErrUserExists)
+	})
 }
 
 func TestAuthService_Login(t *testing.T) {
@@ -104,17 +374,95 @@ func TestAuthService_Login(t *testing.T) {
 		}
 		service := newTestAuthService(repo)
 
-		tokenResponse, err := service.Login("testuser", "password")
+		tokenResponse, err := service.Login("testuser", "password", ClientInfo{})
 		assert.NoError(t, err)
 		assert.NotEmpty(t, tokenResponse.Token)
 		assert.True(t, tokenResponse.ExpiredTime > time.Now().Unix())
+		assert.NotEmpty(t, tokenResponse.RefreshToken)
+		assert.True(t, tokenResponse.RefreshExpiredTime > tokenResponse.ExpiredTime)
+	})
+
+	t.Run("starts a session", func(t *testing.T) {
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser", Password: string(hashedPassword)},
+			},
+		}
+		service := newTestAuthService(repo)
+
+		client := ClientInfo{Device: "laptop", UserAgent: "curl/8.0", IP: "10.0.0.1"}
+		tokenResponse, err := service.Login("testuser", "password", client)
+		assert.NoError(t, err)
+
+		sessions, err := service.ListSessions(1)
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 1)
+		assert.Equal(t, "laptop", sessions[0].Device)
+		assert.Equal(t, "curl/8.0", sessions[0].UserAgent)
+		assert.Equal(t, "10.0.0.1", sessions[0].IP)
+
+		claims := &JwtCustomClaims{}
+		_, err = jwt.ParseWithClaims(tokenResponse.Token, claims, service.keys.Keyfunc)
+		assert.NoError(t, err)
+		assert.Equal(t, sessions[0].ID, claims.ID)
+		assert.Equal(t, "1", claims.Subject)
+		assert.Empty(t, claims.Permissions)
+	})
+
+	t.Run("carries the permissions of the user's roles", func(t *testing.T) {
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser", Password: string(hashedPassword)},
+			},
+		}
+		service := newTestAuthService(repo)
+		service.roleRepo.(*MockRoleRepository).userRoles[1] = []string{models.RoleUser}
+
+		tokenResponse, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+
+		claims := &JwtCustomClaims{}
+		_, err = jwt.ParseWithClaims(tokenResponse.Token, claims, service.keys.Keyfunc)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{models.PermissionFilesRead, models.PermissionFilesWrite}, claims.Permissions)
+	})
+
+	t.Run("unverified email address", func(t *testing.T) {
+		email := "test@example.com"
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser", Email: &email, Password: string(hashedPassword)},
+			},
+		}
+		service := newTestAuthService(repo)
+		service.opts.LoginRequiresVerifiedEmail = true
+
+		_, err := service.Login("testuser", "password", ClientInfo{})
+		assert.Equal(t, ErrEmailNotVerified, err)
+
+		repo.users["testuser"].EmailVerified = true
+		_, err = service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("no email address", func(t *testing.T) {
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser", Password: string(hashedPassword)},
+			},
+		}
+		service := newTestAuthService(repo)
+		service.opts.LoginRequiresVerifiedEmail = true
+
+		_, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err, "accounts from before addresses were collected can login to add one")
 	})
 
 	t.Run("user not found", func(t *testing.T) {
 		repo := &MockUserRepository{users: make(map[string]*models.User)}
 		service := newTestAuthService(repo)
 
-		_, err := service.Login("testuser", "password")
+		_, err := service.Login("testuser", "password", ClientInfo{})
 		assert.Error(t, err)
 		assert.Equal(t, ErrInvalidCreds, err)
 	})
@@ -127,10 +475,89 @@ func TestAuthService_Login(t *testing.T) {
 		}
 		service := newTestAuthService(repo)
 
-		_, err := service.Login("testuser", "wrongpassword")
+		_, err := service.Login("testuser", "wrongpassword", ClientInfo{})
 		assert.Error(t, err)
 		assert.Equal(t, ErrInvalidCreds, err)
 	})
+
+	t.Run("upgrades legacy password hashes", func(t *testing.T) {
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser", Password: string(hashedPassword)},
+			},
+		}
+		service := newTestAuthService(repo)
+
+		_, err := service.Login("testuser", "wrongpassword", ClientInfo{})
+		assert.Equal(t, ErrInvalidCreds, err)
+		assert.Equal(t, string(hashedPassword), repo.users["testuser"].Password, "wrong passwords do not rehash")
+
+		_, err = service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+		upgraded := repo.users["testuser"].Password
+		assert.True(t, strings.HasPrefix(upgraded, "$argon2id$"))
+
+		_, err = service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+		assert.Equal(t, upgraded, repo.users["testuser"].Password, "current hashes are kept")
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		dbErr := errors.New("connection refused")
+		repo := &MockUserRepository{users: make(map[string]*models.User), err: dbErr}
+		service := newTestAuthService(repo)
+
+		_, err := service.Login("testuser", "password", ClientInfo{})
+		assert.ErrorIs(t, err, dbErr)
+	})
+}
+
+func TestAuthService_LoginTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hashes with production parameters")
+	}
+	// The production hasher: new passwords use argon2id, dormant accounts still have bcrypt hashes.
+	hasher := passhash.New(passhash.Argon2id{Memory: 19456, Iterations: 2, Parallelism: 1}, passhash.Bcrypt{Cost: bcrypt.DefaultCost})
+	legacyHash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	currentHash, err := hasher.Hash("password")
+	assert.NoError(t, err)
+	repo := &MockUserRepository{
+		users: map[string]*models.User{
+			"legacy":  {ID: 1, Username: "legacy", Password: string(legacyHash)},
+			"current": {ID: 2, Username: "current", Password: currentHash},
+		},
+	}
+	service := newTestAuthService(repo)
+	lenient := ThrottlePolicy{FreeAttempts: 1000, MaxAttempts: 1000, Lockout: time.Minute}
+	service.throttle = NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), lenient, lenient)
+	service.opts.Hasher = hasher
+	service.dummy()
+
+	// The logins are interleaved so that load changes affect every username alike, and compared by
+	// their median so that single slow rounds do not matter.
+	usernames := []string{"nobody", "legacy", "current"}
+	durations := make(map[string][]time.Duration)
+	for range 15 {
+		for _, username := range usernames {
+			start := time.Now()
+			_, err := service.Login(username, "wrongpassword", ClientInfo{})
+			durations[username] = append(durations[username], time.Since(start))
+			assert.Equal(t, ErrInvalidCreds, err)
+		}
+	}
+	median := func(username string) time.Duration {
+		sorted := slices.Clone(durations[username])
+		slices.Sort(sorted)
+		return sorted[len(sorted)/2]
+	}
+
+	unknown := median("nobody")
+	for _, username := range usernames[1:] {
+		known := median(username)
+		// Failed logins are padded to the slowest scheme, so they should take about as long.
+		diff := (known - unknown).Abs()
+		assert.Less(t, diff, max(known, unknown)/4, "wrong password of %s took %s, unknown user took %s", username, known, unknown)
+	}
 }
 
 func TestAuthService_RevokeToken(t *testing.T) {
@@ -155,3 +582,259 @@ func TestAuthService_RevokeToken(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestAuthService_Refresh(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	newService := func() *AuthService {
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser", Password: string(hashedPassword)},
+			},
+		}
+		return newTestAuthService(repo)
+	}
+
+	t.Run("rotates the refresh token", func(t *testing.T) {
+		service := newService()
+		login, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+
+		refreshed, err := service.Refresh(login.RefreshToken)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, refreshed.Token)
+		assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+
+		_, err = service.Refresh(refreshed.RefreshToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("reuse revokes the whole family", func(t *testing.T) {
+		service := newService()
+		login, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+		other, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+
+		refreshed, err := service.Refresh(login.RefreshToken)
+		assert.NoError(t, err)
+
+		_, err = service.Refresh(login.RefreshToken)
+		assert.Equal(t, ErrRefreshTokenReused, err)
+
+		_, err = service.Refresh(refreshed.RefreshToken)
+		assert.Equal(t, ErrInvalidRefreshToken, err, "tokens rotated from the reused one are revoked too")
+
+		_, err = service.Refresh(other.RefreshToken)
+		assert.NoError(t, err, "other logins are not affected")
+	})
+
+	t.Run("unknown or expired token", func(t *testing.T) {
+		service := newService()
+		_, err := service.Refresh("not-a-token")
+		assert.Equal(t, ErrInvalidRefreshToken, err)
+
+		service.opts.RefreshTTL = -time.Minute
+		login, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+		_, err = service.Refresh(login.RefreshToken)
+		assert.Equal(t, ErrInvalidRefreshToken, err)
+	})
+
+	t.Run("revoking tokens revokes refresh tokens", func(t *testing.T) {
+		service := newService()
+		login, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+
+		assert.NoError(t, service.RevokeToken(1))
+		_, err = service.Refresh(login.RefreshToken)
+		assert.Equal(t, ErrInvalidRefreshToken, err)
+
+		sessions, err := service.ListSessions(1)
+		assert.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+}
+
+func TestAuthService_RevokeSession(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	newService := func() *AuthService {
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser", Password: string(hashedPassword)},
+				"other":    {ID: 2, Username: "other", Password: string(hashedPassword)},
+			},
+		}
+		return newTestAuthService(repo)
+	}
+
+	t.Run("ends only the given session", func(t *testing.T) {
+		service := newService()
+		phone, err := service.Login("testuser", "password", ClientInfo{Device: "phone"})
+		assert.NoError(t, err)
+		laptop, err := service.Login("testuser", "password", ClientInfo{Device: "laptop"})
+		assert.NoError(t, err)
+
+		sessions, err := service.ListSessions(1)
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 2)
+		var phoneID string
+		for _, session := range sessions {
+			if session.Device == "phone" {
+				phoneID = session.ID
+			}
+		}
+
+		assert.NoError(t, service.RevokeSession(1, phoneID))
+
+		_, err = service.Refresh(phone.RefreshToken)
+		assert.Equal(t, ErrInvalidRefreshToken, err)
+		_, err = service.Refresh(laptop.RefreshToken)
+		assert.NoError(t, err)
+
+		sessions, err = service.ListSessions(1)
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 1)
+		assert.Equal(t, "laptop", sessions[0].Device)
+	})
+
+	t.Run("other users' sessions are not found", func(t *testing.T) {
+		service := newService()
+		_, err := service.Login("other", "password", ClientInfo{})
+		assert.NoError(t, err)
+		sessions, err := service.ListSessions(2)
+		assert.NoError(t, err)
+
+		assert.Equal(t, ErrSessionNotFound, service.RevokeSession(1, sessions[0].ID))
+		assert.Equal(t, ErrSessionNotFound, service.RevokeSession(1, "unknown"))
+	})
+
+	t.Run("reusing a refresh token ends the session", func(t *testing.T) {
+		service := newService()
+		login, err := service.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+		_, err = service.Refresh(login.RefreshToken)
+		assert.NoError(t, err)
+
+		_, err = service.Refresh(login.RefreshToken)
+		assert.Equal(t, ErrRefreshTokenReused, err)
+
+		sessions, err := service.ListSessions(1)
+		assert.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+}
+
+func TestAuthService_ChangeEmail(t *testing.T) {
+	newService := func() (*AuthService, *MockUserRepository) {
+		hashed, _ := testHasher.Hash("password")
+		taken := "taken@example.com"
+		repo := &MockUserRepository{users: map[string]*models.User{
+			"dev":   {ID: 1, Username: "dev", Password: hashed},
+			"other": {ID: 2, Username: "other", Email: &taken, EmailVerified: true},
+		}}
+		service := newTestAuthService(repo)
+		service.opts.LoginRequiresVerifiedEmail = true
+		return service, repo
+	}
+	client := ClientInfo{IP: "10.0.0.1"}
+	session := &models.Session{ID: "session", UserID: 1}
+
+	t.Run("new address needs verification", func(t *testing.T) {
+		service, repo := newService()
+		user := repo.users["dev"]
+
+		assert.NoError(t, service.ChangeEmail(user, session, "password", "Dev@Example.com", client))
+		assert.Equal(t, "dev@example.com", *user.Email)
+		assert.False(t, user.EmailVerified)
+		_, err := service.Login("dev", "password", client)
+		assert.Equal(t, ErrEmailNotVerified, err)
+	})
+
+	t.Run("changing a verified address", func(t *testing.T) {
+		service, repo := newService()
+		user := repo.users["dev"]
+		email := "dev@example.com"
+		user.Email, user.EmailVerified = &email, true
+
+		assert.NoError(t, service.ChangeEmail(user, session, "password", "dev@example.com", client))
+		assert.True(t, user.EmailVerified, "the same address stays verified")
+		assert.NoError(t, service.ChangeEmail(user, session, "password", "new@example.com", client))
+		assert.False(t, user.EmailVerified)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		service, repo := newService()
+		user := repo.users["dev"]
+
+		assert.Equal(t, ErrWrongPassword, service.ChangeEmail(user, session, "wrong", "dev@example.com", client))
+		assert.Nil(t, user.Email)
+	})
+
+	t.Run("address taken", func(t *testing.T) {
+		service, repo := newService()
+		user := repo.users["dev"]
+
+		assert.Equal(t, ErrEmailExists, service.ChangeEmail(user, session, "password", "TAKEN@example.com", client))
+		assert.Nil(t, user.Email)
+	})
+}
+
+func TestAuthService_ChangePassword(t *testing.T) {
+	newService := func() (*AuthService, *MockUserRepository) {
+		hashed, _ := testHasher.Hash("old-password")
+		repo := &MockUserRepository{users: map[string]*models.User{"dev": {ID: 1, Username: "dev", Password: hashed}}}
+		return newTestAuthService(repo), repo
+	}
+	client := ClientInfo{Device: "laptop", IP: "10.0.0.1"}
+	session := &models.Session{ID: "session", UserID: 1}
+
+	t.Run("signs out other sessions", func(t *testing.T) {
+		service, repo := newService()
+		user := repo.users["dev"]
+		_, err := service.Login("dev", "old-password", ClientInfo{Device: "phone"})
+		assert.NoError(t, err)
+
+		tokens, err := service.ChangePassword(user, session, "old-password", "new-password", client)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tokens.Token)
+		assert.NotZero(t, repo.revokeTokensBeforeTime)
+
+		sessions, err := service.ListSessions(user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 1)
+		assert.Equal(t, "laptop", sessions[0].Device)
+
+		_, err = service.Login("dev", "old-password", client)
+		assert.Equal(t, ErrInvalidCreds, err)
+		_, err = service.Login("dev", "new-password", client)
+		assert.NoError(t, err)
+	})
+
+	t.Run("wrong current password", func(t *testing.T) {
+		service, repo := newService()
+		user := repo.users["dev"]
+		before := user.Password
+
+		_, err := service.ChangePassword(user, session, "wrong", "new-password", client)
+		assert.Equal(t, ErrWrongPassword, err)
+		assert.Equal(t, before, user.Password)
+
+		for range testThrottlePolicy.FreeAttempts {
+			_, _ = service.ChangePassword(user, session, "wrong", "new-password", client)
+		}
+		_, err = service.ChangePassword(user, session, "old-password", "new-password", client)
+		assert.ErrorIs(t, err, ErrTooManyAttempts, "wrong passwords count as failed logins")
+	})
+
+	t.Run("new password must meet the policy", func(t *testing.T) {
+		service, repo := newService()
+		user := repo.users["dev"]
+		service.opts.PasswordPolicy = PasswordPolicy{MinLength: 20}
+		before := user.Password
+
+		_, err := service.ChangePassword(user, session, "old-password", "new-password", client)
+		assert.Equal(t, []string{RuleMinLength}, violatedRules(err))
+		assert.Equal(t, before, user.Password)
+		assert.Zero(t, repo.revokeTokensBeforeTime)
+	})
+}
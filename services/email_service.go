@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hackathon/mailer"
+	"hackathon/models"
+	"hackathon/pkg/keyring"
+	"hackathon/repositories"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+	ErrEmailAlreadyVerified     = errors.New("email address already verified")
+)
+
+// EmailVerificationTTL is how long an email verification link can be used.
+const EmailVerificationTTL = 24 * time.Hour
+
+// emailVerificationAudience tells verification tokens apart from access tokens, which are signed
+// with the same keys.
+const emailVerificationAudience = "email-verification"
+
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// EmailService confirms that users own their email address. Verification links carry a signed
+// token instead of a stored one; changing the address invalidates the links sent for the old one.
+type EmailService struct {
+	userRepo  repositories.UserRepository
+	keys      *keyring.Keyring
+	mail      mailer.Mailer
+	verifyURL string
+}
+
+// NewEmailService creates the service. verifyURL is the link sent by mail, "{token}" in it is
+// replaced by the verification token.
+func NewEmailService(userRepo repositories.UserRepository, keys *keyring.Keyring, mail mailer.Mailer, verifyURL string) *EmailService {
+	return &EmailService{userRepo: userRepo, keys: keys, mail: mail, verifyURL: verifyURL}
+}
+
+// SendVerification mails a verification link to the address of the user.
+func (s *EmailService) SendVerification(ctx context.Context, user *models.User) error {
+	if user.Email == nil {
+		return nil
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	now := time.Now()
+	token, err := s.keys.Sign(&emailVerificationClaims{
+		*user.Email,
+		jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(int(user.ID)),
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(EmailVerificationTTL)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	link := strings.ReplaceAll(s.verifyURL, "{token}", token)
+	return s.mail.Send(ctx, mailer.Message{
+		To:      *user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Open this link within %d hours to confirm the email address of your account %s:\n\n%s\n\n"+
+			"If you did not create this account, ignore this mail.\n",
+			int(EmailVerificationTTL.Hours()), user.Username, link),
+	})
+}
+
+// ResendVerification mails a new verification link to the address. Unknown and already verified
+// addresses are silently ignored; callers must not tell the client which case applied.
+func (s *EmailService) ResendVerification(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(normalizeEmail(email))
+	if err != nil || user.EmailVerified {
+		return nil
+	}
+	return s.SendVerification(ctx, user)
+}
+
+// VerifyEmail marks the address the token was sent to as verified.
+func (s *EmailService) VerifyEmail(token string) error {
+	claims := new(emailVerificationClaims)
+	_, err := jwt.ParseWithClaims(token, claims, s.keys.Keyfunc,
+		jwt.WithAudience(emailVerificationAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	user, err := s.userRepo.FindByID(uint(userID))
+	if err != nil || user.Email == nil || *user.Email != claims.Email {
+		return ErrInvalidVerificationToken
+	}
+	if user.EmailVerified {
+		return nil
+	}
+	return s.userRepo.UpdateEmailVerified(user, true)
+}
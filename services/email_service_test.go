@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"hackathon/models"
+	"hackathon/pkg/keyring"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEmailService() (*EmailService, *MockUserRepository, *MockMailer) {
+	email := "dev@example.com"
+	users := &MockUserRepository{
+		users: map[string]*models.User{
+			"dev": {ID: 1, Username: "dev", Email: &email},
+		},
+	}
+	mail := &MockMailer{}
+	keys := keyring.New(keyring.NewHMACKey("test", []byte("secret")))
+	return NewEmailService(users, keys, mail, "https://app.example.com/verify?token={token}"), users, mail
+}
+
+func verificationTokenFrom(t *testing.T, body string) string {
+	_, rest, found := strings.Cut(body, "https://app.example.com/verify?token=")
+	assert.True(t, found, "the mail contains the verification link")
+	token, _, _ := strings.Cut(rest, "\n")
+	return token
+}
+
+func TestEmailService_Verify(t *testing.T) {
+	service, users, mail := newTestEmailService()
+	user := users.users["dev"]
+
+	assert.NoError(t, service.SendVerification(context.Background(), user))
+	assert.Len(t, mail.sent, 1)
+	assert.Equal(t, "dev@example.com", mail.sent[0].To)
+	token := verificationTokenFrom(t, mail.sent[0].Body)
+
+	assert.Equal(t, ErrInvalidVerificationToken, service.VerifyEmail(token+"x"))
+	assert.NoError(t, service.VerifyEmail(token))
+	assert.True(t, user.EmailVerified)
+	assert.NoError(t, service.VerifyEmail(token), "verifying again is harmless")
+
+	assert.Equal(t, ErrEmailAlreadyVerified, service.SendVerification(context.Background(), user))
+}
+
+func TestEmailService_VerifyRejectsOtherTokens(t *testing.T) {
+	service, users, mail := newTestEmailService()
+	user := users.users["dev"]
+	assert.NoError(t, service.SendVerification(context.Background(), user))
+	token := verificationTokenFrom(t, mail.sent[0].Body)
+
+	changed := "other@example.com"
+	user.Email = &changed
+	assert.Equal(t, ErrInvalidVerificationToken, service.VerifyEmail(token), "links for a previous address stop working")
+
+	accessToken := signTestToken(t, service.keys)
+	assert.Equal(t, ErrInvalidVerificationToken, service.VerifyEmail(accessToken))
+	assert.False(t, user.EmailVerified)
+}
+
+func TestEmailService_ResendVerification(t *testing.T) {
+	service, users, mail := newTestEmailService()
+	ctx := context.Background()
+
+	assert.NoError(t, service.ResendVerification(ctx, "unknown@example.com"))
+	assert.Empty(t, mail.sent)
+
+	assert.NoError(t, service.ResendVerification(ctx, "DEV@example.com"))
+	assert.Len(t, mail.sent, 1)
+
+	users.users["dev"].EmailVerified = true
+	assert.NoError(t, service.ResendVerification(ctx, "dev@example.com"))
+	assert.Len(t, mail.sent, 1)
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hackathon/models"
+	"hackathon/repositories"
+	"hackathon/storage"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not ready yet")
+)
+
+const (
+	exportBatchSize = 100
+	// exportStaleAfter is how long a job may stay pending or running before its worker is assumed
+	// to be gone, e.g. because the instance running it was restarted.
+	exportStaleAfter = 6 * time.Hour
+	// exportStaleMessage is the error of jobs failed because they went stale.
+	exportStaleMessage = "the export was interrupted, please try again"
+)
+
+// ExportService builds ZIP archives of everything stored about a user: their profile, the
+// metadata of their files, trashed ones included, and the content of those files. Small exports
+// are streamed in the response; larger ones run as background jobs whose archive is kept in
+// storage until it expires.
+type ExportService struct {
+	userRepo   repositories.UserRepository
+	roleRepo   repositories.RoleRepository
+	fileRepo   repositories.FileRepository
+	exportRepo repositories.ExportJobRepository
+	storage    storage.Storage
+	syncMax    int64
+	ttl        time.Duration
+}
+
+func NewExportService(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, fileRepo repositories.FileRepository, exportRepo repositories.ExportJobRepository, store storage.Storage, syncMaxMB int64, ttl time.Duration) *ExportService {
+	return &ExportService{
+		userRepo:   userRepo,
+		roleRepo:   roleRepo,
+		fileRepo:   fileRepo,
+		exportRepo: exportRepo,
+		storage:    store,
+		syncMax:    syncMaxMB * 1024 * 1024,
+		ttl:        ttl,
+	}
+}
+
+// ExceedsSyncLimit reports whether the files of the user are too large to be exported within
+// the request, so the export must run as a job.
+func (s *ExportService) ExceedsSyncLimit(userID uint) (bool, error) {
+	var total int64
+	errLimit := errors.New("limit exceeded")
+	err := s.eachFile(userID, func(metadata *models.FileMetadata) error {
+		total += metadata.Size
+		if total > s.syncMax {
+			return errLimit
+		}
+		return nil
+	})
+	if errors.Is(err, errLimit) {
+		return true, nil
+	}
+	return false, err
+}
+
+// Write writes the export archive of the user to w. Files whose content is missing from storage
+// are only listed in files.json.
+func (s *ExportService) Write(ctx context.Context, user *models.User, w io.Writer) error {
+	roles, err := s.roleRepo.RolesForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	profile := *user
+	profile.Roles = roles
+
+	zw := zip.NewWriter(w)
+	if err := writeJSONEntry(zw, "profile.json", profile); err != nil {
+		return err
+	}
+	files := []models.FileMetadata{}
+	err = s.eachFile(user.ID, func(metadata *models.FileMetadata) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		files = append(files, *metadata)
+		return s.writeFileEntry(ctx, zw, metadata)
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(zw, "files.json", files); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// StartJob creates an export job for the user, unless one is pending or running already. It
+// returns the job and whether it was created; a new job must be passed to RunJob. A stale active
+// job is failed and replaced, so exports do not depend on the purger to recover from it.
+func (s *ExportService) StartJob(user *models.User) (*models.ExportJob, bool, error) {
+	now := time.Now()
+	job, err := s.exportRepo.FindActiveByUser(user.ID)
+	switch {
+	case err == nil && job.CreatedAt.After(now.Add(-exportStaleAfter)):
+		return job, false, nil
+	case err == nil:
+		if err := s.exportRepo.Fail(job, exportStaleMessage, now.Add(s.ttl)); err != nil {
+			return nil, false, err
+		}
+	case !errors.Is(err, repositories.ErrRecordNotFound):
+		return nil, false, err
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, false, err
+	}
+	job = &models.ExportJob{ID: id, UserID: user.ID, Status: models.ExportPending}
+	if err := s.exportRepo.Create(job); err != nil {
+		return nil, false, err
+	}
+	return job, true, nil
+}
+
+// RunJob builds the archive of a job, spooling it to a temporary file before it is stored.
+// A job that fails is marked failed with a generic message; the returned error has the details.
+func (s *ExportService) RunJob(ctx context.Context, job *models.ExportJob) error {
+	if err := s.exportRepo.MarkRunning(job); err != nil {
+		return err
+	}
+	if err := s.buildArchive(ctx, job); err != nil {
+		if failErr := s.exportRepo.Fail(job, "the export could not be built, please try again", time.Now().Add(s.ttl)); failErr != nil {
+			log.Error().Err(failErr).Str("export_id", job.ID).Msg("Failed to mark export as failed")
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ExportService) buildArchive(ctx context.Context, job *models.ExportJob) error {
+	user, err := s.userRepo.FindByID(job.UserID)
+	if err != nil {
+		return err
+	}
+	spool, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if err := s.Write(ctx, user, spool); err != nil {
+		return err
+	}
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.storage.Put(ctx, exportKey(job.ID), spool, size, "application/zip"); err != nil {
+		return err
+	}
+	if err := s.exportRepo.Complete(job, size, time.Now().Add(s.ttl)); err != nil {
+		// The job is gone, or it will expire without an archive; either way nothing refers to it.
+		s.storage.Delete(ctx, exportKey(job.ID))
+		return err
+	}
+	return nil
+}
+
+// GetJob returns an export job of the user.
+func (s *ExportService) GetJob(userID uint, id string) (*models.ExportJob, error) {
+	job, err := s.exportRepo.FindByIDAndUser(id, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrRecordNotFound) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// OpenArchive opens the archive of a done export job of the user. The caller is responsible for
+// closing the returned reader.
+func (s *ExportService) OpenArchive(ctx context.Context, userID uint, id string) (*models.ExportJob, io.ReadSeekCloser, error) {
+	job, err := s.GetJob(userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.Status != models.ExportDone {
+		return nil, nil, ErrExportNotReady
+	}
+	if job.ExpiresAt != nil && job.ExpiresAt.Before(time.Now()) {
+		return nil, nil, ErrExportNotFound
+	}
+	content, err := s.storage.Get(ctx, exportKey(job.ID))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrExportNotFound
+		}
+		return nil, nil, err
+	}
+	return job, content, nil
+}
+
+// DeleteForUser deletes every export job of the user and their archives.
+func (s *ExportService) DeleteForUser(ctx context.Context, userID uint) error {
+	jobs, err := s.exportRepo.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		if err := s.deleteJob(ctx, &jobs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeExpired fails jobs whose worker is gone and deletes expired jobs with their archives.
+// It returns how many jobs were deleted.
+func (s *ExportService) PurgeExpired(ctx context.Context) (int, error) {
+	now := time.Now()
+	if _, err := s.exportRepo.FailStale(now.Add(-exportStaleAfter), exportStaleMessage, now.Add(s.ttl)); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for {
+		jobs, err := s.exportRepo.ListExpired(now, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		for i := range jobs {
+			if err := ctx.Err(); err != nil {
+				return purged, err
+			}
+			if err := s.deleteJob(ctx, &jobs[i]); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(jobs) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// RunPurger purges expired exports every interval until ctx is cancelled.
+func (s *ExportService) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := s.PurgeExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to purge expired exports")
+		} else if purged > 0 {
+			log.Info().Int("count", purged).Msg("Purged expired exports")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ExportService) deleteJob(ctx context.Context, job *models.ExportJob) error {
+	if err := s.storage.Delete(ctx, exportKey(job.ID)); err != nil {
+		return err
+	}
+	return s.exportRepo.Delete(job)
+}
+
+// eachFile calls fn for every file of the owner, trashed ones included, in batches.
+func (s *ExportService) eachFile(ownerID uint, fn func(metadata *models.FileMetadata) error) error {
+	var afterID uint
+	for {
+		files, err := s.fileRepo.ListAllByOwner(ownerID, afterID, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range files {
+			if err := fn(&files[i]); err != nil {
+				return err
+			}
+		}
+		if len(files) < exportBatchSize {
+			return nil
+		}
+		afterID = files[len(files)-1].ID
+	}
+}
+
+func (s *ExportService) writeFileEntry(ctx context.Context, zw *zip.Writer, metadata *models.FileMetadata) error {
+	content, err := s.storage.Get(ctx, storageKey(metadata))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer content.Close()
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     exportFileName(metadata),
+		Method:   zip.Deflate,
+		Modified: metadata.UploadedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+func writeJSONEntry(zw *zip.Writer, name string, v interface{}) error {
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// exportFileName prefixes the file ID, as several files may have the same name.
+func exportFileName(metadata *models.FileMetadata) string {
+	return fmt.Sprintf("files/%d-%s", metadata.ID, metadata.Filename)
+}
+
+// exportKey returns where the archive of an export job is stored.
+func exportKey(id string) string {
+	return "exports/" + id + ".zip"
+}
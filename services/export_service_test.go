@@ -0,0 +1,290 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"hackathon/models"
+	"hackathon/repositories"
+	"hackathon/storage"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MockExportJobRepository is a mock implementation of ExportJobRepository for testing
+type MockExportJobRepository struct {
+	jobs map[string]*models.ExportJob
+}
+
+func (m *MockExportJobRepository) Create(job *models.ExportJob) error {
+	job.CreatedAt = time.Now()
+	copied := *job
+	m.jobs[job.ID] = &copied
+	return nil
+}
+
+func (m *MockExportJobRepository) FindByIDAndUser(id string, userID uint) (*models.ExportJob, error) {
+	if job, exists := m.jobs[id]; exists && job.UserID == userID {
+		copied := *job
+		return &copied, nil
+	}
+	return nil, repositories.ErrRecordNotFound
+}
+
+func (m *MockExportJobRepository) FindActiveByUser(userID uint) (*models.ExportJob, error) {
+	for _, job := range m.jobs {
+		if job.UserID == userID && (job.Status == models.ExportPending || job.Status == models.ExportRunning) {
+			copied := *job
+			return &copied, nil
+		}
+	}
+	return nil, repositories.ErrRecordNotFound
+}
+
+func (m *MockExportJobRepository) ListByUser(userID uint) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	for _, job := range m.jobs {
+		if job.UserID == userID {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *MockExportJobRepository) MarkRunning(job *models.ExportJob) error {
+	job.Status = models.ExportRunning
+	if stored, exists := m.jobs[job.ID]; exists {
+		stored.Status = job.Status
+	}
+	return nil
+}
+
+func (m *MockExportJobRepository) Complete(job *models.ExportJob, size int64, expiresAt time.Time) error {
+	stored, exists := m.jobs[job.ID]
+	if !exists {
+		return repositories.ErrRecordNotFound
+	}
+	now := time.Now()
+	job.Status, job.Size, job.CompletedAt, job.ExpiresAt = models.ExportDone, size, &now, &expiresAt
+	*stored = *job
+	return nil
+}
+
+func (m *MockExportJobRepository) Fail(job *models.ExportJob, message string, expiresAt time.Time) error {
+	now := time.Now()
+	job.Status, job.Error, job.CompletedAt, job.ExpiresAt = models.ExportFailed, message, &now, &expiresAt
+	if stored, exists := m.jobs[job.ID]; exists {
+		*stored = *job
+	}
+	return nil
+}
+
+func (m *MockExportJobRepository) FailStale(createdBefore time.Time, message string, expiresAt time.Time) (int64, error) {
+	var failed int64
+	for _, job := range m.jobs {
+		if (job.Status == models.ExportPending || job.Status == models.ExportRunning) && job.CreatedAt.Before(createdBefore) {
+			m.Fail(job, message, expiresAt)
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+func (m *MockExportJobRepository) ListExpired(now time.Time, limit int) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	for _, job := range m.jobs {
+		if job.ExpiresAt != nil && job.ExpiresAt.Before(now) && len(jobs) < limit {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *MockExportJobRepository) Delete(job *models.ExportJob) error {
+	delete(m.jobs, job.ID)
+	return nil
+}
+
+func newTestExportService(t *testing.T) (*ExportService, *FileService, *MockUserRepository, *MockExportJobRepository) {
+	email := "dev@example.com"
+	users := &MockUserRepository{users: map[string]*models.User{
+		"dev": {ID: 1, Username: "dev", Email: &email, DisplayName: "Dev"},
+	}}
+	roles := newMockRoleRepository()
+	roles.userRoles[1] = []string{models.RoleUser}
+	files := newTestFileService(&MockFileRepository{}, t.TempDir(), 1, nil)
+	jobs := &MockExportJobRepository{jobs: make(map[string]*models.ExportJob)}
+	return NewExportService(users, roles, files.fileRepo, jobs, files.storage, 1, time.Hour), files, users, jobs
+}
+
+// readExport returns the entries of an export archive by name.
+func readExport(t *testing.T, archive []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	assert.NoError(t, err)
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		r.Close()
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func TestExportService_Write(t *testing.T) {
+	ctx := context.Background()
+	service, files, users, _ := newTestExportService(t)
+	kept, err := files.UploadFileStream(ctx, strings.NewReader("kept content"), "kept.txt", 12, 1)
+	assert.NoError(t, err)
+	trashed, err := files.UploadFileStream(ctx, strings.NewReader("trashed content"), "trashed.txt", 15, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, files.DeleteFile(trashed.ID, 1))
+	_, err = files.UploadFileStream(ctx, strings.NewReader("someone else's"), "other.txt", 14, 2)
+	assert.NoError(t, err)
+
+	var archive bytes.Buffer
+	assert.NoError(t, service.Write(ctx, users.users["dev"], &archive))
+	entries := readExport(t, archive.Bytes())
+
+	var profile models.User
+	assert.NoError(t, json.Unmarshal([]byte(entries["profile.json"]), &profile))
+	assert.Equal(t, "dev@example.com", *profile.Email)
+	assert.Equal(t, "Dev", profile.DisplayName)
+	assert.Len(t, profile.Roles, 1)
+
+	var listed []models.FileMetadata
+	assert.NoError(t, json.Unmarshal([]byte(entries["files.json"]), &listed))
+	assert.Len(t, listed, 2, "trashed files are exported, other users' files are not")
+	assert.Equal(t, "kept content", entries[exportFileName(kept)])
+	assert.Equal(t, "trashed content", entries[exportFileName(trashed)])
+	assert.Len(t, entries, 4)
+}
+
+func TestExportService_ExceedsSyncLimit(t *testing.T) {
+	ctx := context.Background()
+	service, files, _, _ := newTestExportService(t)
+
+	exceeds, err := service.ExceedsSyncLimit(1)
+	assert.NoError(t, err)
+	assert.False(t, exceeds)
+
+	// The limit is 1 MB, each file is 600 KB.
+	content := strings.Repeat("a", 600*1024)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		_, err := files.UploadFileStream(ctx, strings.NewReader(content+name), name, int64(len(content)+len(name)), 1)
+		assert.NoError(t, err)
+	}
+	exceeds, err = service.ExceedsSyncLimit(1)
+	assert.NoError(t, err)
+	assert.True(t, exceeds)
+}
+
+func TestExportService_Job(t *testing.T) {
+	ctx := context.Background()
+	service, files, users, jobs := newTestExportService(t)
+	user := users.users["dev"]
+	uploaded, err := files.UploadFileStream(ctx, strings.NewReader("exported content"), "doc.txt", 16, 1)
+	assert.NoError(t, err)
+
+	job, created, err := service.StartJob(user)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, models.ExportPending, job.Status)
+
+	t.Run("one job at a time", func(t *testing.T) {
+		again, created, err := service.StartJob(user)
+		assert.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, job.ID, again.ID)
+	})
+
+	t.Run("not downloadable before it is done", func(t *testing.T) {
+		_, _, err := service.OpenArchive(ctx, 1, job.ID)
+		assert.Equal(t, ErrExportNotReady, err)
+	})
+
+	assert.NoError(t, service.RunJob(ctx, job))
+	assert.Equal(t, models.ExportDone, jobs.jobs[job.ID].Status)
+
+	t.Run("download", func(t *testing.T) {
+		done, content, err := service.OpenArchive(ctx, 1, job.ID)
+		assert.NoError(t, err)
+		defer content.Close()
+		archive, err := io.ReadAll(content)
+		assert.NoError(t, err)
+		assert.Equal(t, done.Size, int64(len(archive)))
+		assert.Equal(t, "exported content", readExport(t, archive)[exportFileName(uploaded)])
+	})
+
+	t.Run("other users cannot see the job", func(t *testing.T) {
+		_, err := service.GetJob(2, job.ID)
+		assert.Equal(t, ErrExportNotFound, err)
+		_, _, err = service.OpenArchive(ctx, 2, job.ID)
+		assert.Equal(t, ErrExportNotFound, err)
+	})
+
+	t.Run("expired jobs are purged with their archive", func(t *testing.T) {
+		expired := time.Now().Add(-time.Minute)
+		jobs.jobs[job.ID].ExpiresAt = &expired
+
+		purged, err := service.PurgeExpired(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		assert.Empty(t, jobs.jobs)
+		_, err = files.storage.Stat(ctx, exportKey(job.ID))
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+}
+
+func TestExportService_StaleJobsFail(t *testing.T) {
+	service, _, users, jobs := newTestExportService(t)
+	job, _, err := service.StartJob(users.users["dev"])
+	assert.NoError(t, err)
+	jobs.jobs[job.ID].CreatedAt = time.Now().Add(-exportStaleAfter - time.Minute)
+
+	_, err = service.PurgeExpired(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, models.ExportFailed, jobs.jobs[job.ID].Status)
+
+	_, created, err := service.StartJob(users.users["dev"])
+	assert.NoError(t, err)
+	assert.True(t, created, "a failed job does not block a new one")
+}
+
+func TestExportService_StaleJobReplacedWithoutPurger(t *testing.T) {
+	service, _, users, jobs := newTestExportService(t)
+	job, _, err := service.StartJob(users.users["dev"])
+	assert.NoError(t, err)
+
+	active, created, err := service.StartJob(users.users["dev"])
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, job.ID, active.ID)
+
+	jobs.jobs[job.ID].CreatedAt = time.Now().Add(-exportStaleAfter - time.Minute)
+	replacement, created, err := service.StartJob(users.users["dev"])
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, job.ID, replacement.ID)
+	assert.Equal(t, models.ExportFailed, jobs.jobs[job.ID].Status)
+	assert.Equal(t, exportStaleMessage, jobs.jobs[job.ID].Error)
+}
+
+func TestExportService_JobOfDeletedAccount(t *testing.T) {
+	ctx := context.Background()
+	service, files, users, jobs := newTestExportService(t)
+	job, _, err := service.StartJob(users.users["dev"])
+	assert.NoError(t, err)
+	delete(jobs.jobs, job.ID)
+
+	assert.ErrorIs(t, service.RunJob(ctx, job), repositories.ErrRecordNotFound)
+	_, err = files.storage.Stat(ctx, exportKey(job.ID))
+	assert.Equal(t, storage.ErrNotFound, err, "the archive is not kept")
+}
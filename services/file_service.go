@@ -1,56 +1,80 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"hackathon/dto"
 	"hackathon/models"
+	"hackathon/pkg/pagination"
 	"hackathon/repositories"
+	"hackathon/storage"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 var (
 	ErrFileTooLarge = errors.New("file too large")
 	ErrInvalidType  = errors.New("invalid file type")
-	ErrSaveFile     = errors.New("failed to save file to disk")
+	ErrSaveFile     = errors.New("failed to save file to storage")
 	ErrSaveDB       = errors.New("failed to save metadata")
+	ErrFileNotFound = errors.New("file not found")
+	ErrGrantToOwner = errors.New("the owner already has access to this file")
 )
 
+const purgeBatchSize = 100
+
+// fileSortColumns maps the public sort keys of the listing API to FileMetadata columns.
+var fileSortColumns = map[string]string{
+	"name":        "filename",
+	"size":        "size",
+	"uploaded_at": "uploaded_at",
+}
+
 type FileService struct {
 	fileRepo     repositories.FileRepository
-	uploadDir    string
+	storage      storage.Storage
 	maxSize      int64
 	allowedTypes []string
 }
 
-func NewFileService(repo repositories.FileRepository, uploadDir string, maxSizeMB int64, allowedTypes []string) *FileService {
+func NewFileService(repo repositories.FileRepository, store storage.Storage, maxSizeMB int64, allowedTypes []string) *FileService {
 	return &FileService{
 		fileRepo:     repo,
-		uploadDir:    uploadDir,
+		storage:      store,
 		maxSize:      maxSizeMB * 1024 * 1024,
 		allowedTypes: allowedTypes,
 	}
 }
 
-func (s *FileService) UploadFile(fileHeader *multipart.FileHeader) (*models.FileMetadata, error) {
+func (s *FileService) UploadFile(ctx context.Context, fileHeader *multipart.FileHeader, ownerID uint) (*models.FileMetadata, error) {
 	src, err := fileHeader.Open()
 	if err != nil {
 		return nil, err
 	}
 	defer src.Close()
-	return s.UploadFileStream(src, fileHeader.Filename, fileHeader.Size)
+	return s.UploadFileStream(ctx, src, fileHeader.Filename, fileHeader.Size, ownerID)
 }
 
-func (s *FileService) UploadFileStream(reader io.Reader, filename string, size int64) (*models.FileMetadata, error) {
+// UploadFileStream validates the content, stores it once under its SHA-256 digest and records
+// the file metadata. Uploading content that is already stored only adds a reference to it.
+// The client-supplied filename is only kept, sanitized, as display metadata.
+func (s *FileService) UploadFileStream(ctx context.Context, reader io.Reader, filename string, size int64, ownerID uint) (*models.FileMetadata, error) {
 	if size > s.maxSize {
 		return nil, ErrFileTooLarge
 	}
 
 	buffer := make([]byte, 512)
-	n, err := reader.Read(buffer)
-	if err != nil && err != io.EOF {
+	n, err := io.ReadFull(reader, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
 	buffer = buffer[:n]
@@ -73,26 +97,312 @@ func (s *FileService) UploadFileStream(reader io.Reader, filename string, size i
 		return nil, ErrInvalidType
 	}
 
-	dstPath := filepath.Join(s.uploadDir, "upload-"+filename)
-	dst, err := os.Create(dstPath)
+	// Spool the content to a temporary file while hashing it: the digest, and so the storage
+	// key, is only known once the whole stream has been read.
+	spool, err := os.CreateTemp("", "upload-*")
 	if err != nil {
 		return nil, ErrSaveFile
 	}
-	defer dst.Close()
+	defer os.Remove(spool.Name())
+	defer spool.Close()
 
-	if _, err := dst.Write(buffer); err != nil {
+	hash := sha256.New()
+	content := io.MultiReader(bytes.NewReader(buffer), reader)
+	written, err := io.Copy(io.MultiWriter(spool, hash), io.LimitReader(content, s.maxSize+1))
+	if err != nil {
 		return nil, ErrSaveFile
 	}
+	if written > s.maxSize {
+		return nil, ErrFileTooLarge
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
 
-	if _, err := io.Copy(dst, reader); err != nil {
+	err = s.fileRepo.AcquireBlob(digest, written, func() error {
+		key := blobKey(digest)
+		if _, err := s.storage.Stat(ctx, key); err == nil {
+			return nil // Already stored
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return s.storage.Put(ctx, key, spool, written, contentType)
+	})
+	if err != nil {
 		return nil, ErrSaveFile
 	}
 
-	metadata := &models.FileMetadata{Filename: filename, Size: size, ContentType: contentType}
+	metadata := &models.FileMetadata{OwnerID: ownerID, Filename: sanitizeFilename(filename), Size: written, ContentType: contentType, Digest: digest}
 	if err := s.fileRepo.Create(metadata); err != nil {
-		os.Remove(dstPath) // Clean up file if DB save fails
+		s.releaseBlob(ctx, digest) // Drop the reference if DB save fails
 		return nil, ErrSaveDB
 	}
 
 	return metadata, nil
-}
\ No newline at end of file
+}
+
+// OpenFile looks up the metadata of a file the user owns or has been granted access to,
+// and opens its content for reading. The caller is responsible for closing the returned reader.
+func (s *FileService) OpenFile(ctx context.Context, id, userID uint) (*models.FileMetadata, io.ReadSeekCloser, error) {
+	metadata, err := s.fileRepo.FindAccessibleByID(id, userID)
+	if err != nil {
+		return nil, nil, ErrFileNotFound
+	}
+
+	content, err := s.storage.Get(ctx, storageKey(metadata))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrFileNotFound
+		}
+		return nil, nil, err
+	}
+
+	return metadata, content, nil
+}
+
+// ListFiles returns one page of the files owned by the user, filtered and sorted as requested.
+func (s *FileService) ListFiles(ownerID uint, req dto.ListFilesRequest) (dto.Page[models.FileMetadata], error) {
+	var page dto.Page[models.FileMetadata]
+
+	sortKey := req.Sort
+	if sortKey == "" {
+		sortKey = "uploaded_at"
+	}
+	order := req.Order
+	if order == "" {
+		order = "desc"
+	}
+	limit := pagination.Limit(req.Limit)
+
+	query := repositories.FileListQuery{
+		OwnerID:      ownerID,
+		ContentType:  req.ContentType,
+		NameContains: req.Q,
+		Trashed:      req.Trashed,
+		SortColumn:   fileSortColumns[sortKey],
+		Descending:   order == "desc",
+		Limit:        limit + 1,
+	}
+	if req.From != "" {
+		from, err := time.Parse(time.RFC3339, req.From)
+		if err != nil {
+			return page, err
+		}
+		query.UploadedFrom = &from
+	}
+	if req.To != "" {
+		to, err := time.Parse(time.RFC3339, req.To)
+		if err != nil {
+			return page, err
+		}
+		query.UploadedTo = &to
+	}
+
+	if req.Cursor != "" {
+		cursor, err := pagination.Decode(req.Cursor)
+		if err != nil || cursor.Sort != sortKey || cursor.Order != order {
+			return page, pagination.ErrInvalidCursor
+		}
+		value, err := parseFileSortValue(sortKey, cursor.Value)
+		if err != nil {
+			return page, pagination.ErrInvalidCursor
+		}
+		query.AfterValue = value
+		query.AfterID = cursor.ID
+	}
+
+	files, err := s.fileRepo.List(query)
+	if err != nil {
+		return page, err
+	}
+	total, err := s.fileRepo.Count(query)
+	if err != nil {
+		return page, err
+	}
+
+	if len(files) > limit {
+		files = files[:limit]
+		last := files[limit-1]
+		page.NextCursor = pagination.Encode(pagination.Cursor{
+			Sort:  sortKey,
+			Order: order,
+			Value: formatFileSortValue(sortKey, &last),
+			ID:    last.ID,
+		})
+	}
+	if files == nil {
+		files = []models.FileMetadata{}
+	}
+	page.Items = files
+	page.TotalEstimate = total
+	return page, nil
+}
+
+func formatFileSortValue(sortKey string, metadata *models.FileMetadata) string {
+	switch sortKey {
+	case "name":
+		return metadata.Filename
+	case "size":
+		return strconv.FormatInt(metadata.Size, 10)
+	default:
+		return metadata.UploadedAt.Format(time.RFC3339Nano)
+	}
+}
+
+func parseFileSortValue(sortKey, value string) (interface{}, error) {
+	switch sortKey {
+	case "name":
+		return value, nil
+	case "size":
+		return strconv.ParseInt(value, 10, 64)
+	default:
+		return time.Parse(time.RFC3339Nano, value)
+	}
+}
+
+// DeleteFile moves a file to the trash. It disappears from every read path but can be restored
+// until it is purged. Only the owner may delete a file.
+func (s *FileService) DeleteFile(id, ownerID uint) error {
+	metadata, err := s.fileRepo.FindByIDAndOwner(id, ownerID)
+	if err != nil {
+		return ErrFileNotFound
+	}
+	if err := s.fileRepo.Trash(metadata); err != nil {
+		return ErrSaveDB
+	}
+	return nil
+}
+
+// RestoreFile takes a file out of the trash.
+func (s *FileService) RestoreFile(id, ownerID uint) (*models.FileMetadata, error) {
+	metadata, err := s.fileRepo.FindTrashedByIDAndOwner(id, ownerID)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	if err := s.fileRepo.Restore(metadata); err != nil {
+		return nil, ErrSaveDB
+	}
+	return metadata, nil
+}
+
+// PurgeTrash permanently removes files that were trashed before cutoff, releasing their content.
+// It returns how many files were purged.
+func (s *FileService) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	purged := 0
+	for {
+		files, err := s.fileRepo.ListTrashedBefore(cutoff, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		for i := range files {
+			if err := ctx.Err(); err != nil {
+				return purged, err
+			}
+			if err := s.purge(ctx, &files[i]); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(files) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// PurgeOwner permanently removes every file of the owner, trashed ones included. It returns how
+// many files were purged.
+func (s *FileService) PurgeOwner(ctx context.Context, ownerID uint) (int, error) {
+	purged := 0
+	for {
+		files, err := s.fileRepo.ListAllByOwner(ownerID, 0, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		for i := range files {
+			if err := ctx.Err(); err != nil {
+				return purged, err
+			}
+			if err := s.purge(ctx, &files[i]); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(files) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// RunPurger purges trashed files older than retention every interval until ctx is cancelled.
+func (s *FileService) RunPurger(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := s.PurgeTrash(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to purge trashed files")
+		} else if purged > 0 {
+			log.Info().Int("count", purged).Msg("Purged trashed files")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *FileService) purge(ctx context.Context, metadata *models.FileMetadata) error {
+	return s.fileRepo.Purge(metadata, func() error {
+		return s.storage.Delete(ctx, storageKey(metadata))
+	})
+}
+
+// GrantAccess lets another user read a file. Only the owner may grant access.
+func (s *FileService) GrantAccess(fileID, ownerID, granteeID uint) error {
+	if _, err := s.fileRepo.FindByIDAndOwner(fileID, ownerID); err != nil {
+		return ErrFileNotFound
+	}
+	if granteeID == ownerID {
+		return ErrGrantToOwner
+	}
+	return s.fileRepo.CreateGrant(&models.FileGrant{FileID: fileID, UserID: granteeID})
+}
+
+// RevokeAccess removes a previously granted access. Only the owner may revoke access.
+func (s *FileService) RevokeAccess(fileID, ownerID, granteeID uint) error {
+	if _, err := s.fileRepo.FindByIDAndOwner(fileID, ownerID); err != nil {
+		return ErrFileNotFound
+	}
+	return s.fileRepo.DeleteGrant(fileID, granteeID)
+}
+
+// ListGrants returns the users a file has been shared with. Only the owner may list them.
+func (s *FileService) ListGrants(fileID, ownerID uint) ([]models.FileGrant, error) {
+	if _, err := s.fileRepo.FindByIDAndOwner(fileID, ownerID); err != nil {
+		return nil, ErrFileNotFound
+	}
+	return s.fileRepo.ListGrants(fileID)
+}
+
+// releaseBlob drops one reference to a blob and deletes its content once nothing uses it anymore.
+func (s *FileService) releaseBlob(ctx context.Context, digest string) error {
+	return s.fileRepo.ReleaseBlob(digest, func() error {
+		return s.storage.Delete(ctx, blobKey(digest))
+	})
+}
+
+// blobKey shards blobs over two directory levels taken from the digest, so no single
+// directory grows too large on filesystem-backed storage.
+func blobKey(digest string) string {
+	return "blobs/" + digest[0:2] + "/" + digest[2:4] + "/" + digest
+}
+
+// storageKey returns where the content of a file is stored. Files uploaded before content
+// addressing was introduced have no digest and live under their original name.
+func storageKey(metadata *models.FileMetadata) string {
+	if metadata.Digest == "" {
+		return "upload-" + metadata.Filename
+	}
+	return blobKey(metadata.Digest)
+}
@@ -1,34 +1,259 @@
-
 package services
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"hackathon/dto"
 	"hackathon/models"
+	"hackathon/pkg/pagination"
+	"hackathon/repositories"
+	"hackathon/storage"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
 // MockFileRepository is a mock implementation of FileRepository for testing
 type MockFileRepository struct {
-	err error
+	files  map[uint]*models.FileMetadata
+	grants []models.FileGrant
+	blobs  map[string]int64
+	err    error
 }
 
 func (m *MockFileRepository) Create(metadata *models.FileMetadata) error {
 	if m.err != nil {
 		return m.err
 	}
-	metadata.ID = 1
+	if m.files == nil {
+		m.files = make(map[uint]*models.FileMetadata)
+	}
+	metadata.ID = uint(len(m.files) + 1)
+	metadata.UploadedAt = time.Now()
+	m.files[metadata.ID] = metadata
+	return nil
+}
+
+func (m *MockFileRepository) FindByIDAndOwner(id, ownerID uint) (*models.FileMetadata, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if metadata, exists := m.files[id]; exists && metadata.OwnerID == ownerID && !metadata.DeletedAt.Valid {
+		return metadata, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockFileRepository) FindTrashedByIDAndOwner(id, ownerID uint) (*models.FileMetadata, error) {
+	if metadata, exists := m.files[id]; exists && metadata.OwnerID == ownerID && metadata.DeletedAt.Valid {
+		return metadata, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockFileRepository) Trash(metadata *models.FileMetadata) error {
+	if m.err != nil {
+		return m.err
+	}
+	metadata.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (m *MockFileRepository) Restore(metadata *models.FileMetadata) error {
+	if m.err != nil {
+		return m.err
+	}
+	metadata.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (m *MockFileRepository) ListTrashedBefore(cutoff time.Time, limit int) ([]models.FileMetadata, error) {
+	var files []models.FileMetadata
+	for _, metadata := range m.files {
+		if metadata.DeletedAt.Valid && metadata.DeletedAt.Time.Before(cutoff) && len(files) < limit {
+			files = append(files, *metadata)
+		}
+	}
+	return files, nil
+}
+
+func (m *MockFileRepository) ListAllByOwner(ownerID, afterID uint, limit int) ([]models.FileMetadata, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var files []models.FileMetadata
+	for _, metadata := range m.files {
+		if metadata.OwnerID == ownerID && metadata.ID > afterID {
+			files = append(files, *metadata)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	return files, nil
+}
+
+func (m *MockFileRepository) Purge(metadata *models.FileMetadata, deleteContent func() error) error {
+	if m.err != nil {
+		return m.err
+	}
+	if metadata.Digest == "" {
+		if err := deleteContent(); err != nil {
+			return err
+		}
+	} else if err := m.ReleaseBlob(metadata.Digest, deleteContent); err != nil {
+		return err
+	}
+	delete(m.files, metadata.ID)
 	return nil
 }
 
+// List implements keyset pagination over the in-memory files, mirroring the SQL implementation.
+func (m *MockFileRepository) List(query repositories.FileListQuery) ([]models.FileMetadata, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var files []models.FileMetadata
+	for _, metadata := range m.files {
+		if metadata.OwnerID == query.OwnerID && metadata.DeletedAt.Valid == query.Trashed &&
+			(query.ContentType == "" || metadata.ContentType == query.ContentType) {
+			files = append(files, *metadata)
+		}
+	}
+	compare := func(a models.FileMetadata, value interface{}, id uint) int {
+		c := 0
+		switch query.SortColumn {
+		case "filename":
+			c = strings.Compare(a.Filename, value.(string))
+		case "size":
+			c = int(a.Size - value.(int64))
+		default:
+			c = a.UploadedAt.Compare(value.(time.Time))
+		}
+		if c == 0 {
+			c = int(a.ID) - int(id)
+		}
+		if query.Descending {
+			c = -c
+		}
+		return c
+	}
+	sortValue := func(f models.FileMetadata) interface{} {
+		switch query.SortColumn {
+		case "filename":
+			return f.Filename
+		case "size":
+			return f.Size
+		default:
+			return f.UploadedAt
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return compare(files[i], sortValue(files[j]), files[j].ID) < 0 })
+
+	var page []models.FileMetadata
+	for _, f := range files {
+		if query.AfterValue != nil && compare(f, query.AfterValue, query.AfterID) <= 0 {
+			continue
+		}
+		if len(page) == query.Limit {
+			break
+		}
+		page = append(page, f)
+	}
+	return page, nil
+}
+
+func (m *MockFileRepository) Count(query repositories.FileListQuery) (int64, error) {
+	query.AfterValue = nil
+	query.Limit = len(m.files)
+	files, err := m.List(query)
+	return int64(len(files)), err
+}
+
+func (m *MockFileRepository) FindAccessibleByID(id, userID uint) (*models.FileMetadata, error) {
+	if metadata, err := m.FindByIDAndOwner(id, userID); err == nil {
+		return metadata, nil
+	}
+	for _, grant := range m.grants {
+		if grant.FileID == id && grant.UserID == userID {
+			if metadata, exists := m.files[id]; exists && !metadata.DeletedAt.Valid {
+				return metadata, nil
+			}
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockFileRepository) AcquireBlob(digest string, size int64, ensureContent func() error) error {
+	if err := ensureContent(); err != nil {
+		return err
+	}
+	if m.blobs == nil {
+		m.blobs = make(map[string]int64)
+	}
+	m.blobs[digest]++
+	return nil
+}
+
+func (m *MockFileRepository) ReleaseBlob(digest string, deleteContent func() error) error {
+	if m.blobs[digest] > 1 {
+		m.blobs[digest]--
+		return nil
+	}
+	if err := deleteContent(); err != nil {
+		return err
+	}
+	delete(m.blobs, digest)
+	return nil
+}
+
+func (m *MockFileRepository) CreateGrant(grant *models.FileGrant) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.grants = append(m.grants, *grant)
+	return nil
+}
+
+func (m *MockFileRepository) DeleteGrant(fileID, userID uint) error {
+	if m.err != nil {
+		return m.err
+	}
+	kept := m.grants[:0]
+	for _, grant := range m.grants {
+		if grant.FileID != fileID || grant.UserID != userID {
+			kept = append(kept, grant)
+		}
+	}
+	m.grants = kept
+	return nil
+}
+
+func (m *MockFileRepository) ListGrants(fileID uint) ([]models.FileGrant, error) {
+	var grants []models.FileGrant
+	for _, grant := range m.grants {
+		if grant.FileID == fileID {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
 func newTestFileService(repo *MockFileRepository, uploadDir string, maxSizeMB int64, allowedTypes []string) *FileService {
-	return NewFileService(repo, uploadDir, maxSizeMB, allowedTypes)
+	store, err := storage.NewLocal(uploadDir)
+	if err != nil {
+		panic(err)
+	}
+	return NewFileService(repo, store, maxSizeMB, allowedTypes)
 }
 
 func TestFileService_UploadFileStream(t *testing.T) {
@@ -43,18 +268,19 @@ func TestFileService_UploadFileStream(t *testing.T) {
 		reader := strings.NewReader(content)
 		filename := "test.jpg"
 
-		metadata, err := service.UploadFileStream(reader, filename, int64(len(content)))
+		metadata, err := service.UploadFileStream(context.Background(), reader, filename, int64(len(content)), 1)
 		assert.NoError(t, err)
 		assert.NotNil(t, metadata)
 		assert.Equal(t, filename, metadata.Filename)
 		assert.Equal(t, int64(len(content)), metadata.Size)
+		assert.Equal(t, uint(1), metadata.OwnerID)
 		// This is tricky to test without a real image, http.DetectContentType will return "text/plain; charset=utf-8"
 		// For a real jpeg it would be "image/jpeg"
 		// We will assert that it's not empty
 		assert.NotEmpty(t, metadata.ContentType)
 
-		filePath := filepath.Join(tmpDir, "upload-"+filename)
-		_, err = os.Stat(filePath)
+		assert.Len(t, metadata.Digest, 64)
+		_, err = os.Stat(filepath.Join(tmpDir, filepath.FromSlash(blobKey(metadata.Digest))))
 		assert.NoError(t, err)
 	})
 
@@ -65,7 +291,7 @@ func TestFileService_UploadFileStream(t *testing.T) {
 		reader := strings.NewReader(content)
 		filename := "large.jpg"
 
-		_, err := service.UploadFileStream(reader, filename, 2*1024*1024) // 2MB
+		_, err := service.UploadFileStream(context.Background(), reader, filename, 2*1024*1024, 1) // 2MB
 		assert.Error(t, err)
 		assert.Equal(t, ErrFileTooLarge, err)
 	})
@@ -78,7 +304,7 @@ func TestFileService_UploadFileStream(t *testing.T) {
 		reader := strings.NewReader(content)
 		filename := "test.txt"
 
-		_, err := service.UploadFileStream(reader, filename, int64(len(content)))
+		_, err := service.UploadFileStream(context.Background(), reader, filename, int64(len(content)), 1)
 		assert.Error(t, err)
 		assert.Equal(t, ErrInvalidType, err)
 	})
@@ -90,12 +316,301 @@ func TestFileService_UploadFileStream(t *testing.T) {
 		reader := bytes.NewReader([]byte(content))
 		filename := "test.jpg"
 
-		_, err := service.UploadFileStream(reader, filename, int64(len(content)))
+		_, err := service.UploadFileStream(context.Background(), reader, filename, int64(len(content)), 1)
 		assert.Error(t, err)
 		assert.Equal(t, ErrSaveDB, err)
 
-		filePath := filepath.Join(tmpDir, "upload-"+filename)
-		_, err = os.Stat(filePath)
-		assert.True(t, os.IsNotExist(err), "File should be deleted after db error")
+		assert.Empty(t, repo.blobs)
+		objects, err := service.storage.List(context.Background(), "blobs/")
+		assert.NoError(t, err)
+		assert.Empty(t, objects, "File should be deleted after db error")
+	})
+}
+
+func TestFileService_OpenFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "download-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	t.Run("existing file", func(t *testing.T) {
+		repo := &MockFileRepository{}
+		service := newTestFileService(repo, tmpDir, 1, nil)
+		content := "stored content"
+		uploaded, err := service.UploadFileStream(context.Background(), strings.NewReader(content), "stored.txt", int64(len(content)), 1)
+		assert.NoError(t, err)
+
+		metadata, file, err := service.OpenFile(context.Background(), uploaded.ID, 1)
+		assert.NoError(t, err)
+		defer file.Close()
+		assert.Equal(t, uploaded.ID, metadata.ID)
+
+		data, err := ioutil.ReadAll(file)
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		repo := &MockFileRepository{}
+		service := newTestFileService(repo, tmpDir, 1, nil)
+
+		_, _, err := service.OpenFile(context.Background(), 42, 1)
+		assert.Equal(t, ErrFileNotFound, err)
+	})
+
+	t.Run("metadata without content", func(t *testing.T) {
+		repo := &MockFileRepository{files: map[uint]*models.FileMetadata{
+			7: {ID: 7, OwnerID: 1, Filename: "missing.txt"},
+		}}
+		service := newTestFileService(repo, tmpDir, 1, nil)
+
+		_, _, err := service.OpenFile(context.Background(), 7, 1)
+		assert.Equal(t, ErrFileNotFound, err)
+	})
+}
+
+func TestFileService_AccessControl(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "acl-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	const owner, grantee, stranger uint = 1, 2, 3
+	repo := &MockFileRepository{}
+	service := newTestFileService(repo, tmpDir, 1, nil)
+	content := "private content"
+	uploaded, err := service.UploadFileStream(context.Background(), strings.NewReader(content), "private.txt", int64(len(content)), owner)
+	assert.NoError(t, err)
+
+	t.Run("stranger cannot read", func(t *testing.T) {
+		_, _, err := service.OpenFile(context.Background(), uploaded.ID, stranger)
+		assert.Equal(t, ErrFileNotFound, err)
+	})
+
+	t.Run("only the owner can grant", func(t *testing.T) {
+		assert.Equal(t, ErrFileNotFound, service.GrantAccess(uploaded.ID, stranger, stranger))
+		assert.Equal(t, ErrGrantToOwner, service.GrantAccess(uploaded.ID, owner, owner))
+		assert.NoError(t, service.GrantAccess(uploaded.ID, owner, grantee))
+
+		grants, err := service.ListGrants(uploaded.ID, owner)
+		assert.NoError(t, err)
+		assert.Len(t, grants, 1)
+
+		_, err = service.ListGrants(uploaded.ID, grantee)
+		assert.Equal(t, ErrFileNotFound, err)
+	})
+
+	t.Run("grantee can read until access is revoked", func(t *testing.T) {
+		_, file, err := service.OpenFile(context.Background(), uploaded.ID, grantee)
+		assert.NoError(t, err)
+		file.Close()
+
+		assert.Equal(t, ErrFileNotFound, service.RevokeAccess(uploaded.ID, grantee, grantee))
+		assert.NoError(t, service.RevokeAccess(uploaded.ID, owner, grantee))
+
+		_, _, err = service.OpenFile(context.Background(), uploaded.ID, grantee)
+		assert.Equal(t, ErrFileNotFound, err)
+	})
+}
+
+func TestFileService_ListFiles(t *testing.T) {
+	repo := &MockFileRepository{files: map[uint]*models.FileMetadata{
+		1: {ID: 1, OwnerID: 1, Filename: "b.png", Size: 30, ContentType: "image/png", UploadedAt: time.Unix(100, 0)},
+		2: {ID: 2, OwnerID: 1, Filename: "a.png", Size: 10, ContentType: "image/png", UploadedAt: time.Unix(200, 0)},
+		3: {ID: 3, OwnerID: 1, Filename: "c.jpg", Size: 20, ContentType: "image/jpeg", UploadedAt: time.Unix(300, 0)},
+		4: {ID: 4, OwnerID: 2, Filename: "other.png", Size: 5, ContentType: "image/png", UploadedAt: time.Unix(400, 0)},
+	}}
+	service := newTestFileService(repo, os.TempDir(), 1, nil)
+
+	collect := func(req dto.ListFilesRequest) []uint {
+		var ids []uint
+		for {
+			page, err := service.ListFiles(1, req)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), page.TotalEstimate)
+			for _, item := range page.Items {
+				ids = append(ids, item.ID)
+			}
+			if page.NextCursor == "" {
+				return ids
+			}
+			req.Cursor = page.NextCursor
+		}
+	}
+
+	t.Run("defaults to newest first", func(t *testing.T) {
+		assert.Equal(t, []uint{3, 2, 1}, collect(dto.ListFilesRequest{Limit: 1}))
+	})
+
+	t.Run("sort by name ascending", func(t *testing.T) {
+		assert.Equal(t, []uint{2, 1, 3}, collect(dto.ListFilesRequest{Sort: "name", Order: "asc", Limit: 2}))
+	})
+
+	t.Run("sort by size descending", func(t *testing.T) {
+		assert.Equal(t, []uint{1, 3, 2}, collect(dto.ListFilesRequest{Sort: "size", Order: "desc", Limit: 2}))
+	})
+
+	t.Run("content type filter", func(t *testing.T) {
+		page, err := service.ListFiles(1, dto.ListFilesRequest{ContentType: "image/jpeg"})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 1)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("cursor from a different ordering is rejected", func(t *testing.T) {
+		page, err := service.ListFiles(1, dto.ListFilesRequest{Sort: "name", Limit: 1})
+		assert.NoError(t, err)
+
+		_, err = service.ListFiles(1, dto.ListFilesRequest{Sort: "size", Cursor: page.NextCursor})
+		assert.Equal(t, pagination.ErrInvalidCursor, err)
+
+		_, err = service.ListFiles(1, dto.ListFilesRequest{Cursor: "not-a-cursor"})
+		assert.Equal(t, pagination.ErrInvalidCursor, err)
+	})
+}
+
+func TestFileService_Deduplication(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "dedup-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	ctx := context.Background()
+	repo := &MockFileRepository{}
+	service := newTestFileService(repo, tmpDir, 1, nil)
+	content := "same bytes uploaded twice"
+
+	first, err := service.UploadFileStream(ctx, strings.NewReader(content), "first.txt", int64(len(content)), 1)
+	assert.NoError(t, err)
+	second, err := service.UploadFileStream(ctx, strings.NewReader(content), "second.txt", int64(len(content)), 2)
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, first.Digest, second.Digest)
+	assert.Equal(t, int64(2), repo.blobs[first.Digest])
+
+	objects, err := service.storage.List(ctx, "blobs/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1, "identical content is stored once")
+
+	assert.NoError(t, service.releaseBlob(ctx, first.Digest))
+	_, err = service.storage.Stat(ctx, blobKey(first.Digest))
+	assert.NoError(t, err, "content is kept while referenced")
+
+	assert.NoError(t, service.releaseBlob(ctx, second.Digest))
+	_, err = service.storage.Stat(ctx, blobKey(first.Digest))
+	assert.Equal(t, storage.ErrNotFound, err, "content is deleted with its last reference")
+}
+
+func TestFileService_UploadEnforcesActualSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "size-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	service := newTestFileService(&MockFileRepository{}, tmpDir, 1, nil)
+	content := strings.Repeat("a", 1024*1024+1)
+
+	// The declared size is not trusted: the stream itself is bounded.
+	_, err = service.UploadFileStream(context.Background(), strings.NewReader(content), "big.txt", 10, 1)
+	assert.Equal(t, ErrFileTooLarge, err)
+}
+
+// failingDeleteStorage fails to delete anything while err is set.
+type failingDeleteStorage struct {
+	storage.Storage
+	err error
+}
+
+func (f *failingDeleteStorage) Delete(ctx context.Context, key string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.Storage.Delete(ctx, key)
+}
+
+func TestFileService_PurgeFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "purge-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	ctx := context.Background()
+	repo := &MockFileRepository{}
+	service := newTestFileService(repo, tmpDir, 1, nil)
+	store := &failingDeleteStorage{Storage: service.storage, err: errors.New("storage unavailable")}
+	service.storage = store
+	uploaded, err := service.UploadFileStream(ctx, strings.NewReader("purged content"), "purged.txt", 14, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, service.DeleteFile(uploaded.ID, 1))
+
+	_, err = service.PurgeTrash(ctx, time.Now().Add(time.Second))
+	assert.Equal(t, store.err, err)
+	assert.Contains(t, repo.files, uploaded.ID, "the file is kept so the purge can be retried")
+	assert.Equal(t, int64(1), repo.blobs[uploaded.Digest], "the blob is not released")
+
+	store.err = nil
+	purged, err := service.PurgeTrash(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.NotContains(t, repo.files, uploaded.ID)
+	assert.NotContains(t, repo.blobs, uploaded.Digest)
+	_, err = service.storage.Stat(ctx, blobKey(uploaded.Digest))
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestFileService_Trash(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "trash-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	ctx := context.Background()
+	repo := &MockFileRepository{}
+	service := newTestFileService(repo, tmpDir, 1, nil)
+	content := "deletable content"
+	uploaded, err := service.UploadFileStream(ctx, strings.NewReader(content), "trash.txt", int64(len(content)), 1)
+	assert.NoError(t, err)
+
+	t.Run("only the owner can delete", func(t *testing.T) {
+		assert.Equal(t, ErrFileNotFound, service.DeleteFile(uploaded.ID, 2))
+	})
+
+	t.Run("deleted files are hidden and restorable", func(t *testing.T) {
+		assert.NoError(t, service.DeleteFile(uploaded.ID, 1))
+
+		_, _, err := service.OpenFile(ctx, uploaded.ID, 1)
+		assert.Equal(t, ErrFileNotFound, err)
+		page, err := service.ListFiles(1, dto.ListFilesRequest{})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+		page, err = service.ListFiles(1, dto.ListFilesRequest{Trashed: true})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 1)
+
+		restored, err := service.RestoreFile(uploaded.ID, 1)
+		assert.NoError(t, err)
+		assert.False(t, restored.DeletedAt.Valid)
+
+		_, file, err := service.OpenFile(ctx, uploaded.ID, 1)
+		assert.NoError(t, err)
+		file.Close()
+
+		_, err = service.RestoreFile(uploaded.ID, 1)
+		assert.Equal(t, ErrFileNotFound, err, "a file that is not in the trash cannot be restored")
+	})
+
+	t.Run("purge respects the retention window", func(t *testing.T) {
+		assert.NoError(t, service.DeleteFile(uploaded.ID, 1))
+
+		purged, err := service.PurgeTrash(ctx, time.Now().Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, purged)
+		assert.Contains(t, repo.files, uploaded.ID)
+
+		purged, err = service.PurgeTrash(ctx, time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		assert.NotContains(t, repo.files, uploaded.ID)
+
+		_, err = service.storage.Stat(ctx, blobKey(uploaded.Digest))
+		assert.Equal(t, storage.ErrNotFound, err, "content is deleted with the last purged reference")
+
+		_, err = service.RestoreFile(uploaded.ID, 1)
+		assert.Equal(t, ErrFileNotFound, err)
 	})
 }
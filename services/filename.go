@@ -0,0 +1,77 @@
+package services
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	maxFilenameBytes  = 255
+	maxExtensionBytes = 16
+	defaultFilename   = "file"
+)
+
+// separatorLookalikes are characters that render like a path separator but survive NFKC.
+var separatorLookalikes = map[rune]bool{
+	'\\': true,
+	'⁄':  true, // FRACTION SLASH
+	'∕':  true, // DIVISION SLASH
+	'⧸':  true, // BIG SOLIDUS
+	'⧹':  true, // BIG REVERSE SOLIDUS
+}
+
+// sanitizeFilename turns a client-supplied filename into a name that is safe to display and to
+// send back in a Content-Disposition header. It is never used to build a storage path.
+func sanitizeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	// NFKC folds compatibility characters such as the fullwidth "／" and "．" into the ASCII
+	// characters they imitate, so they are handled below like the real thing.
+	name = norm.NFKC.String(name)
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case separatorLookalikes[r]:
+			return '/'
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			// NUL and other control characters, bidi overrides, zero-width characters.
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Keep the last path element only.
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return defaultFilename
+	}
+
+	return truncateFilename(name)
+}
+
+// truncateFilename limits the name to maxFilenameBytes, keeping a short extension intact and
+// never splitting a multi-byte character.
+func truncateFilename(name string) string {
+	if len(name) <= maxFilenameBytes {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > maxExtensionBytes {
+		ext = ""
+	}
+	base := name[:len(name)-len(ext)]
+	limit := maxFilenameBytes - len(ext)
+	for limit > 0 && !utf8.RuneStart(base[limit]) {
+		limit--
+	}
+	return base[:limit] + ext
+}
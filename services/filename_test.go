@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain name", "avatar.png", "avatar.png"},
+		{"parent traversal", "../../etc/passwd", "passwd"},
+		{"absolute path", "/etc/shadow", "shadow"},
+		{"windows traversal", `..\..\windows\system32\cmd.exe`, "cmd.exe"},
+		{"only dots", "..", defaultFilename},
+		{"empty", "", defaultFilename},
+		{"hidden file", ".htaccess", "htaccess"},
+		{"NUL byte", "evil.php\x00.png", "evil.php.png"},
+		{"control characters", "line\r\nbreak\t.txt", "linebreak.txt"},
+		{"fullwidth solidus and dots", "．．／．．／secret.txt", "secret.txt"},
+		{"division slash lookalike", "..∕..∕secret.txt", "secret.txt"},
+		{"fraction slash lookalike", "a⁄b.txt", "b.txt"},
+		{"right-to-left override", "invoice\u202egnp.exe", "invoicegnp.exe"},
+		{"zero width space", "ad\u200bmin.png", "admin.png"},
+		{"reserved characters", `a<b>:c"d|e?f*.png`, "a_b__c_d_e_f_.png"},
+		{"unicode is kept", "ảnh đẹp.jpg", "ảnh đẹp.jpg"},
+		{"invalid utf-8", "bad\xff\xfename.png", "badname.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sanitizeFilename(tt.input))
+		})
+	}
+}
+
+func TestSanitizeFilename_LongNames(t *testing.T) {
+	t.Run("keeps the extension", func(t *testing.T) {
+		name := sanitizeFilename(strings.Repeat("a", 1000) + ".png")
+		assert.Equal(t, maxFilenameBytes, len(name))
+		assert.True(t, strings.HasSuffix(name, ".png"))
+	})
+
+	t.Run("does not split multi-byte characters", func(t *testing.T) {
+		name := sanitizeFilename(strings.Repeat("ế", 200) + ".jpeg")
+		assert.LessOrEqual(t, len(name), maxFilenameBytes)
+		assert.True(t, utf8.ValidString(name))
+		assert.True(t, strings.HasSuffix(name, ".jpeg"))
+	})
+
+	t.Run("drops an overly long extension", func(t *testing.T) {
+		name := sanitizeFilename("x." + strings.Repeat("e", 400))
+		assert.Equal(t, maxFilenameBytes, len(name))
+	})
+}
+
+func TestFileService_HostileFilenamesStayInsideStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostile-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	uploadDir := filepath.Join(root, "uploads")
+
+	repo := &MockFileRepository{}
+	service := newTestFileService(repo, uploadDir, 1, nil)
+
+	for i, name := range []string{"../escaped.txt", "../../escaped.txt", "avatar.png", "avatar.png", "/abs.txt", "nul\x00.txt", strings.Repeat("n", 5000)} {
+		content := strings.Repeat("x", i+1) // distinct content per upload
+		metadata, err := service.UploadFileStream(context.Background(), strings.NewReader(content), name, int64(len(content)), 1)
+		assert.NoError(t, err)
+		assert.NotContains(t, metadata.Filename, "/")
+		assert.NotContains(t, metadata.Filename, "\x00")
+	}
+
+	entries, err := os.ReadDir(root)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "nothing is written outside the upload directory")
+
+	objects, err := service.storage.List(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 7, "uploads with the same name do not overwrite each other")
+	for _, object := range objects {
+		assert.Regexp(t, `^blobs/[0-9a-f]{2}/[0-9a-f]{2}/[0-9a-f]{64}$`, object.Key)
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hackathon/models"
+	"hackathon/pkg/keyring"
+	"hackathon/repositories"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrKeyNotFound         = errors.New("signing key not found")
+	ErrKeyRetired          = errors.New("signing key is retired")
+	ErrKeyRotationDisabled = errors.New("key rotation is disabled, set JWT_KEYS_DIR")
+)
+
+// KeyReloadInterval is how often every instance reloads the signing keys, so a promotion reaches
+// all of them within this interval.
+const KeyReloadInterval = time.Minute
+
+// KeyService rotates the JWT signing keys. Key states are shared through the database and the
+// private keys are PEM files in the keys directory, which every instance must be able to read.
+// The key the keyring was created with is used until a key is promoted.
+type KeyService struct {
+	keyRepo   repositories.SigningKeyRepository
+	keys      *keyring.Keyring
+	fallback  *keyring.Key
+	dir       string
+	accessTTL time.Duration
+}
+
+func NewKeyService(repo repositories.SigningKeyRepository, keys *keyring.Keyring, dir string, accessTTL time.Duration) *KeyService {
+	return &KeyService{keyRepo: repo, keys: keys, fallback: keys.Active(), dir: dir, accessTTL: accessTTL}
+}
+
+// Enabled reports whether keys are rotated, i.e. a keys directory is configured.
+func (s *KeyService) Enabled() bool {
+	return s.dir != ""
+}
+
+// List returns every key, oldest first.
+func (s *KeyService) List() ([]models.SigningKey, error) {
+	if !s.Enabled() {
+		return nil, ErrKeyRotationDisabled
+	}
+	return s.keyRepo.List()
+}
+
+// Generate creates a new pending key. It is published in the JWKS right away but only signs
+// tokens once promoted.
+func (s *KeyService) Generate(algorithm string) (*models.SigningKey, error) {
+	if !s.Enabled() {
+		return nil, ErrKeyRotationDisabled
+	}
+	key, data, err := keyring.Generate(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.keyPath(key.ID), data, 0600); err != nil {
+		return nil, err
+	}
+
+	record := &models.SigningKey{ID: key.ID, Algorithm: key.Algorithm, Status: models.SigningKeyPending}
+	if err := s.keyRepo.Create(record); err != nil {
+		os.Remove(s.keyPath(key.ID))
+		return nil, err
+	}
+	return record, s.Reload()
+}
+
+// Promote makes the key the one new tokens are signed with. The previously active key keeps
+// verifying the tokens it signed until RetireExpired retires it.
+func (s *KeyService) Promote(id string) (*models.SigningKey, error) {
+	if !s.Enabled() {
+		return nil, ErrKeyRotationDisabled
+	}
+	record, err := s.keyRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+	if record.Status == models.SigningKeyRetired {
+		return nil, ErrKeyRetired
+	}
+	if _, err := s.loadKey(record); err != nil {
+		return nil, err
+	}
+	if err := s.keyRepo.Promote(record); err != nil {
+		return nil, err
+	}
+	return record, s.Reload()
+}
+
+// RetireExpired retires the keys that were replaced long enough ago for every token they signed
+// to have expired, and returns them.
+func (s *KeyService) RetireExpired() ([]models.SigningKey, error) {
+	if !s.Enabled() {
+		return nil, ErrKeyRotationDisabled
+	}
+	retired, err := s.keyRepo.RetireDeactivatedBefore(time.Now().Add(-s.gracePeriod()))
+	if err != nil {
+		return nil, err
+	}
+	return retired, s.Reload()
+}
+
+// Reload rebuilds the keyring from the database. It is a no-op when rotation is disabled.
+func (s *KeyService) Reload() error {
+	if !s.Enabled() {
+		return nil
+	}
+	records, err := s.keyRepo.List()
+	if err != nil {
+		return err
+	}
+
+	var active *keyring.Key
+	var activatedAt time.Time
+	var verification []*keyring.Key
+	for i := range records {
+		record := &records[i]
+		if record.Status == models.SigningKeyRetired {
+			continue
+		}
+		key, err := s.loadKey(record)
+		if err != nil {
+			return err
+		}
+		if record.Status == models.SigningKeyActive {
+			active = key
+			if record.ActivatedAt != nil {
+				activatedAt = *record.ActivatedAt
+			}
+			continue
+		}
+		verification = append(verification, key)
+	}
+
+	// The configured key signs until a key is promoted, then verifies what it signed until expiry.
+	if active == nil {
+		active = s.fallback
+	} else if time.Since(activatedAt) < s.gracePeriod() {
+		verification = append(verification, s.fallback)
+	}
+	s.keys.Replace(active, verification...)
+	return nil
+}
+
+// RunReloader reloads the keys every interval until ctx is cancelled.
+func (s *KeyService) RunReloader(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.Reload(); err != nil {
+			log.Error().Err(err).Msg("Failed to reload signing keys")
+		}
+	}
+}
+
+// gracePeriod is how long a replaced key keeps verifying: the lifetime of the tokens it signed,
+// plus the time other instances may still have been signing with it.
+func (s *KeyService) gracePeriod() time.Duration {
+	return s.accessTTL + KeyReloadInterval
+}
+
+func (s *KeyService) loadKey(record *models.SigningKey) (*keyring.Key, error) {
+	key, err := keyring.LoadPEMFile(s.keyPath(record.ID), record.ID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != record.Algorithm {
+		return nil, fmt.Errorf("key %s: expected %s, file holds a %s key", record.ID, record.Algorithm, key.Algorithm)
+	}
+	return key, nil
+}
+
+func (s *KeyService) keyPath(id string) string {
+	return filepath.Join(s.dir, id+".pem")
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"errors"
+	"hackathon/models"
+	"hackathon/pkg/keyring"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// MockSigningKeyRepository is a mock implementation of SigningKeyRepository for testing
+type MockSigningKeyRepository struct {
+	keys []*models.SigningKey
+}
+
+func (m *MockSigningKeyRepository) Create(key *models.SigningKey) error {
+	key.CreatedAt = time.Now()
+	m.keys = append(m.keys, key)
+	return nil
+}
+
+func (m *MockSigningKeyRepository) FindByID(id string) (*models.SigningKey, error) {
+	for _, key := range m.keys {
+		if key.ID == id {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockSigningKeyRepository) List() ([]models.SigningKey, error) {
+	keys := make([]models.SigningKey, len(m.keys))
+	for i, key := range m.keys {
+		keys[i] = *key
+	}
+	return keys, nil
+}
+
+func (m *MockSigningKeyRepository) Promote(key *models.SigningKey) error {
+	now := time.Now()
+	for _, stored := range m.keys {
+		if stored.Status == models.SigningKeyActive && stored.ID != key.ID {
+			stored.Status = models.SigningKeyVerify
+			stored.DeactivatedAt = &now
+		}
+		if stored.ID == key.ID {
+			stored.Status = models.SigningKeyActive
+			stored.ActivatedAt = &now
+			stored.DeactivatedAt = nil
+		}
+	}
+	key.Status = models.SigningKeyActive
+	key.ActivatedAt = &now
+	return nil
+}
+
+func (m *MockSigningKeyRepository) RetireDeactivatedBefore(cutoff time.Time) ([]models.SigningKey, error) {
+	var retired []models.SigningKey
+	now := time.Now()
+	for _, stored := range m.keys {
+		if stored.Status == models.SigningKeyVerify && stored.DeactivatedAt.Before(cutoff) {
+			stored.Status = models.SigningKeyRetired
+			stored.RetiredAt = &now
+			retired = append(retired, *stored)
+		}
+	}
+	return retired, nil
+}
+
+func signTestToken(t *testing.T, keys *keyring.Keyring) string {
+	token, err := keys.Sign(jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))})
+	assert.NoError(t, err)
+	return token
+}
+
+func verifyTestToken(keys *keyring.Keyring, token string) error {
+	_, err := jwt.Parse(token, keys.Keyfunc)
+	return err
+}
+
+func TestKeyService_Rotation(t *testing.T) {
+	keys := keyring.New(keyring.NewHMACKey("hs256", []byte("secret")))
+	repo := &MockSigningKeyRepository{}
+	service := NewKeyService(repo, keys, t.TempDir(), time.Hour)
+
+	legacyToken := signTestToken(t, keys)
+
+	first, err := service.Generate("ES256")
+	assert.NoError(t, err)
+	assert.Equal(t, models.SigningKeyPending, first.Status)
+	assert.Equal(t, "hs256", keys.Active().ID, "pending keys do not sign")
+	assert.Len(t, keys.JWKS().Keys, 1, "pending keys are published")
+
+	_, err = service.Promote(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, keys.Active().ID)
+	assert.NoError(t, verifyTestToken(keys, legacyToken), "the configured key verifies until its tokens expire")
+	firstToken := signTestToken(t, keys)
+
+	second, err := service.Generate("EdDSA")
+	assert.NoError(t, err)
+	_, err = service.Promote(second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, keys.Active().ID)
+	assert.NoError(t, verifyTestToken(keys, firstToken), "the replaced key keeps verifying")
+
+	retired, err := service.RetireExpired()
+	assert.NoError(t, err)
+	assert.Empty(t, retired, "tokens signed with the replaced key may still be valid")
+
+	// Pretend the replaced key was deactivated long ago.
+	past := time.Now().Add(-2 * time.Hour)
+	repo.keys[0].DeactivatedAt = &past
+	repo.keys[1].ActivatedAt = &past
+
+	retired, err = service.RetireExpired()
+	assert.NoError(t, err)
+	assert.Len(t, retired, 1)
+	assert.Equal(t, first.ID, retired[0].ID)
+	assert.ErrorIs(t, verifyTestToken(keys, firstToken), keyring.ErrUnknownKey)
+	assert.Error(t, verifyTestToken(keys, legacyToken))
+	assert.NoError(t, verifyTestToken(keys, signTestToken(t, keys)))
+
+	_, err = service.Promote(first.ID)
+	assert.Equal(t, ErrKeyRetired, err)
+	_, err = service.Promote("unknown")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestKeyService_Reload(t *testing.T) {
+	dir := t.TempDir()
+	repo := &MockSigningKeyRepository{}
+	leader := NewKeyService(repo, keyring.New(keyring.NewHMACKey("hs256", []byte("secret"))), dir, time.Hour)
+	key, err := leader.Generate("RS256")
+	assert.NoError(t, err)
+	_, err = leader.Promote(key.ID)
+	assert.NoError(t, err)
+
+	// Another instance picks the promotion up on reload.
+	keys := keyring.New(keyring.NewHMACKey("hs256", []byte("secret")))
+	follower := NewKeyService(repo, keys, dir, time.Hour)
+	assert.Equal(t, "hs256", keys.Active().ID)
+	assert.NoError(t, follower.Reload())
+	assert.Equal(t, key.ID, keys.Active().ID)
+}
+
+func TestKeyService_Disabled(t *testing.T) {
+	keys := keyring.New(keyring.NewHMACKey("hs256", []byte("secret")))
+	service := NewKeyService(&MockSigningKeyRepository{}, keys, "", time.Hour)
+
+	assert.False(t, service.Enabled())
+	assert.NoError(t, service.Reload())
+	_, err := service.Generate("ES256")
+	assert.Equal(t, ErrKeyRotationDisabled, err)
+	assert.Equal(t, "hs256", keys.Active().ID)
+}
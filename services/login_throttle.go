@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hackathon/models"
+	"hackathon/repositories"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrTooManyAttempts is matched by every *TooManyAttemptsError.
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// TooManyAttemptsError rejects a login without checking the password.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// ThrottlePolicy decides how long a key has to wait after failed logins: nothing for the first
+// FreeAttempts failures, then twice as long after every failure starting at one second, and
+// Lockout from MaxAttempts failures on. A key is forgotten once it has not failed for Lockout.
+type ThrottlePolicy struct {
+	FreeAttempts int
+	MaxAttempts  int
+	Lockout      time.Duration
+}
+
+// delay returns how long to wait after the given number of consecutive failures.
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	if failures >= p.MaxAttempts {
+		return p.Lockout
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	exponent := failures - p.FreeAttempts - 1
+	if exponent >= 30 {
+		return p.Lockout
+	}
+	return min(time.Second<<exponent, p.Lockout)
+}
+
+// LoginThrottle slows down password guessing. Failures are counted per username, so a targeted
+// account is protected, and per client IP, with a higher limit, so credential stuffing from one
+// address is slowed down across accounts.
+type LoginThrottle struct {
+	store    repositories.LoginAttemptRepository
+	username ThrottlePolicy
+	ip       ThrottlePolicy
+}
+
+func NewLoginThrottle(store repositories.LoginAttemptRepository, username, ip ThrottlePolicy) *LoginThrottle {
+	return &LoginThrottle{store: store, username: username, ip: ip}
+}
+
+// Attempt reserves a login attempt of the username from the IP before the password is checked.
+// The attempt counts as a failure right away, so parallel requests cannot all get past the limits
+// before the first failure is recorded; Success undoes it. Attempts that raced past the limits
+// are rejected with a *TooManyAttemptsError and stay counted.
+func (t *LoginThrottle) Attempt(username, ip string) error {
+	return reserve(t.store, t.keys(username, ip), "Login locked out")
+}
+
+// reserve counts an attempt for every key unless one of them has to wait. Attempts made in
+// parallel that raced past the limits are rejected too and stay counted. lockedOut is logged when
+// a key reaches its maximum.
+func reserve(store repositories.LoginAttemptRepository, keys []throttleKey, lockedOut string) error {
+	now := time.Now()
+	seen := make([]int, len(keys))
+	var wait time.Duration
+	for i, key := range keys {
+		attempt, err := store.Get(key.name)
+		if err != nil {
+			return err
+		}
+		seen[i] = attempt.Failures
+		wait = max(wait, key.wait(attempt, now))
+	}
+	if wait > 0 {
+		return &TooManyAttemptsError{RetryAfter: wait}
+	}
+
+	for i, key := range keys {
+		attempt, err := store.RecordFailure(key.name, now, now.Add(-key.policy.Lockout))
+		if err != nil {
+			return err
+		}
+		// Failures recorded since the read above are attempts running in parallel, made just now.
+		if previous := attempt.Failures - 1; previous > seen[i] {
+			wait = max(wait, key.policy.delay(previous))
+		}
+		if attempt.Failures == key.policy.MaxAttempts {
+			log.Warn().Str("key", key.name).Int("failures", attempt.Failures).Msg(lockedOut)
+		}
+	}
+	if wait > 0 {
+		return &TooManyAttemptsError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Success undoes the attempt reserved for a correct password and forgets the failures of the
+// username. Those of the IP are kept: one valid account must not unlock guessing the others.
+func (t *LoginThrottle) Success(username, ip string) error {
+	if err := t.store.Reset(usernameKey(username)); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return t.store.Release(ipKey(ip))
+}
+
+// Release undoes an attempt without forgetting earlier failures, for a correct password that still
+// needs a second factor.
+func (t *LoginThrottle) Release(username, ip string) error {
+	for _, key := range t.keys(username, ip) {
+		if err := t.store.Release(key.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunPruner forgets the keys that have not failed for longer than their lockout every interval
+// until ctx is cancelled.
+func (t *LoginThrottle) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := t.store.DeleteBefore(time.Now().Add(-max(t.username.Lockout, t.ip.Lockout))); err != nil {
+			log.Error().Err(err).Msg("Failed to prune login attempts")
+		}
+	}
+}
+
+type throttleKey struct {
+	name   string
+	policy ThrottlePolicy
+}
+
+// wait returns how long the key still has to wait after its failures.
+func (k throttleKey) wait(attempt models.LoginAttempt, now time.Time) time.Duration {
+	if attempt.Failures == 0 {
+		return 0
+	}
+	return attempt.LastFailureAt.Add(k.policy.delay(attempt.Failures)).Sub(now)
+}
+
+func (t *LoginThrottle) keys(username, ip string) []throttleKey {
+	keys := []throttleKey{{usernameKey(username), t.username}}
+	if ip != "" {
+		keys = append(keys, throttleKey{ipKey(ip), t.ip})
+	}
+	return keys
+}
+
+func usernameKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hackathon/models"
+	"hackathon/repositories"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestThrottlePolicy_Delay(t *testing.T) {
+	policy := ThrottlePolicy{FreeAttempts: 3, MaxAttempts: 10, Lockout: 5 * time.Second}
+
+	assert.Equal(t, time.Duration(0), policy.delay(0))
+	assert.Equal(t, time.Duration(0), policy.delay(3))
+	assert.Equal(t, time.Second, policy.delay(4))
+	assert.Equal(t, 2*time.Second, policy.delay(5))
+	assert.Equal(t, 4*time.Second, policy.delay(6))
+	assert.Equal(t, 5*time.Second, policy.delay(7))
+	assert.Equal(t, 5*time.Second, policy.delay(10))
+	assert.Equal(t, 5*time.Second, policy.delay(100))
+}
+
+func TestLoginThrottle(t *testing.T) {
+	policy := ThrottlePolicy{FreeAttempts: 2, MaxAttempts: 3, Lockout: time.Minute}
+	lenient := ThrottlePolicy{FreeAttempts: 100, MaxAttempts: 100, Lockout: time.Minute}
+
+	t.Run("locks out the username", func(t *testing.T) {
+		throttle := NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), policy, lenient)
+
+		assert.NoError(t, throttle.Attempt("Dev", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.2"))
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.3"))
+		err := throttle.Attempt("DEV", "10.0.0.4")
+		var tooMany *TooManyAttemptsError
+		assert.True(t, errors.As(err, &tooMany))
+		assert.True(t, errors.Is(err, ErrTooManyAttempts))
+		assert.InDelta(t, time.Minute, tooMany.RetryAfter, float64(time.Second))
+
+		assert.NoError(t, throttle.Attempt("other", "10.0.0.4"))
+	})
+
+	t.Run("locks out the IP across usernames", func(t *testing.T) {
+		throttle := NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), lenient, policy)
+
+		assert.NoError(t, throttle.Attempt("a", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt("b", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt("c", "10.0.0.1"))
+
+		assert.ErrorIs(t, throttle.Attempt("d", "10.0.0.1"), ErrTooManyAttempts)
+		assert.NoError(t, throttle.Attempt("d", "10.0.0.2"))
+	})
+
+	t.Run("success undoes the attempt and only resets the username", func(t *testing.T) {
+		store := repositories.NewMemoryLoginAttemptRepository()
+		throttle := NewLoginThrottle(store, policy, policy)
+		failures := func(key string) int {
+			attempt, err := store.Get(key)
+			assert.NoError(t, err)
+			return attempt.Failures
+		}
+
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.1"))
+		assert.Equal(t, 2, failures(usernameKey("dev")), "the attempt counts until it succeeds")
+		assert.Equal(t, 2, failures(ipKey("10.0.0.1")))
+
+		assert.NoError(t, throttle.Success("dev", "10.0.0.1"))
+		assert.Equal(t, 0, failures(usernameKey("dev")))
+		assert.Equal(t, 1, failures(ipKey("10.0.0.1")), "earlier failures of the IP are kept")
+	})
+
+	t.Run("release undoes the attempt and keeps earlier failures", func(t *testing.T) {
+		store := repositories.NewMemoryLoginAttemptRepository()
+		throttle := NewLoginThrottle(store, policy, policy)
+
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.1"))
+		assert.NoError(t, throttle.Release("dev", "10.0.0.1"))
+		for _, key := range []string{usernameKey("dev"), ipKey("10.0.0.1")} {
+			attempt, err := store.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, attempt.Failures)
+		}
+	})
+
+	t.Run("parallel attempts do not get past the limits", func(t *testing.T) {
+		throttle := NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), policy, policy)
+
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := throttle.Attempt("dev", "10.0.0.1"); err == nil {
+					allowed.Add(1)
+				} else {
+					assert.ErrorIs(t, err, ErrTooManyAttempts)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, int(allowed.Load()), policy.FreeAttempts+1)
+		assert.ErrorIs(t, throttle.Attempt("dev", "10.0.0.1"), ErrTooManyAttempts)
+	})
+
+	t.Run("forgets failures older than the lockout", func(t *testing.T) {
+		store := repositories.NewMemoryLoginAttemptRepository()
+		throttle := NewLoginThrottle(store, policy, policy)
+
+		old := time.Now().Add(-2 * time.Minute)
+		for range 3 {
+			_, err := store.RecordFailure(usernameKey("dev"), old, old.Add(-time.Minute))
+			assert.NoError(t, err)
+		}
+
+		assert.NoError(t, throttle.Attempt("dev", ""))
+		attempt, err := store.Get(usernameKey("dev"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempt.Failures)
+	})
+}
+
+func TestAuthService_LoginThrottled(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	repo := &MockUserRepository{
+		users: map[string]*models.User{
+			"testuser": {ID: 1, Username: "testuser", Password: string(hashedPassword)},
+		},
+	}
+	service := newTestAuthService(repo)
+	client := ClientInfo{IP: "10.0.0.1"}
+
+	for range testThrottlePolicy.FreeAttempts {
+		_, err := service.Login("testuser", "wrong", client)
+		assert.ErrorIs(t, err, ErrInvalidCreds)
+	}
+	_, err := service.Login("testuser", "wrong", client)
+	assert.ErrorIs(t, err, ErrInvalidCreds)
+
+	// The correct password is not even checked while the username has to wait.
+	_, err = service.Login("testuser", "password", ClientInfo{IP: "10.0.0.2"})
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	// Unknown usernames count as failures too.
+	for range testThrottlePolicy.MaxAttempts {
+		_, _ = service.Login("nobody", "wrong", ClientInfo{IP: "10.0.0.3"})
+	}
+	_, err = service.Login("nobody", "wrong", ClientInfo{IP: "10.0.0.4"})
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestAuthService_LoginThrottledConcurrently(t *testing.T) {
+	hashed, err := testHasher.Hash("password")
+	assert.NoError(t, err)
+	repo := &MockUserRepository{
+		users: map[string]*models.User{
+			"testuser": {ID: 1, Username: "testuser", Password: hashed},
+		},
+	}
+	service := newTestAuthService(repo)
+
+	var checked atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.Login("testuser", "wrong", ClientInfo{IP: fmt.Sprintf("10.0.1.%d", i)})
+			if errors.Is(err, ErrInvalidCreds) {
+				checked.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrTooManyAttempts)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every password check beyond the free attempts has to wait for the previous failure.
+	assert.LessOrEqual(t, int(checked.Load()), testThrottlePolicy.FreeAttempts+1)
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"errors"
+	"hackathon/repositories"
+	"strings"
+)
+
+// ErrTooManyMails tells clients that the MailThrottle rejected their request.
+var ErrTooManyMails = errors.New("too many mails requested, please wait before trying again")
+
+// MailThrottle limits the mails anyone can have sent by the public endpoints, per recipient so
+// that nobody's inbox can be flooded, and per client IP. It shares the store of the login
+// throttle, so its policies must not have a longer lockout than the login policies, or the login
+// pruner would forget them early.
+type MailThrottle struct {
+	store     repositories.LoginAttemptRepository
+	recipient ThrottlePolicy
+	ip        ThrottlePolicy
+}
+
+func NewMailThrottle(store repositories.LoginAttemptRepository, recipient, ip ThrottlePolicy) *MailThrottle {
+	return &MailThrottle{store: store, recipient: recipient, ip: ip}
+}
+
+// Attempt counts a mail requested for the recipient, a username or email address, from the IP.
+// It returns a *TooManyAttemptsError if either has to wait. Requests count whether or not a mail
+// is sent, so the limits do not tell which recipients have an account.
+func (t *MailThrottle) Attempt(recipient, ip string) error {
+	keys := []throttleKey{{"mail:" + strings.ToLower(strings.TrimSpace(recipient)), t.recipient}}
+	if ip != "" {
+		keys = append(keys, throttleKey{"mail-ip:" + ip, t.ip})
+	}
+	return reserve(t.store, keys, "Mail requests locked out")
+}
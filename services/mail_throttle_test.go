@@ -0,0 +1,47 @@
+package services
+
+import (
+	"errors"
+	"hackathon/repositories"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMailThrottle(t *testing.T) {
+	policy := ThrottlePolicy{FreeAttempts: 2, MaxAttempts: 2, Lockout: time.Minute}
+	lenient := ThrottlePolicy{FreeAttempts: 100, MaxAttempts: 100, Lockout: time.Minute}
+
+	t.Run("limits mails per recipient", func(t *testing.T) {
+		throttle := NewMailThrottle(repositories.NewMemoryLoginAttemptRepository(), policy, lenient)
+
+		assert.NoError(t, throttle.Attempt("dev@example.com", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt(" Dev@Example.com", "10.0.0.2"))
+		err := throttle.Attempt("dev@example.com", "10.0.0.3")
+		var tooMany *TooManyAttemptsError
+		assert.True(t, errors.As(err, &tooMany), "other IPs cannot flood the same inbox")
+		assert.InDelta(t, time.Minute, tooMany.RetryAfter, float64(time.Second))
+
+		assert.NoError(t, throttle.Attempt("other@example.com", "10.0.0.3"))
+	})
+
+	t.Run("limits mails per IP across recipients", func(t *testing.T) {
+		throttle := NewMailThrottle(repositories.NewMemoryLoginAttemptRepository(), lenient, policy)
+
+		assert.NoError(t, throttle.Attempt("a@example.com", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt("b@example.com", "10.0.0.1"))
+		assert.ErrorIs(t, throttle.Attempt("c@example.com", "10.0.0.1"), ErrTooManyAttempts)
+		assert.NoError(t, throttle.Attempt("c@example.com", "10.0.0.2"))
+	})
+
+	t.Run("does not touch login failures", func(t *testing.T) {
+		store := repositories.NewMemoryLoginAttemptRepository()
+		throttle := NewMailThrottle(store, policy, policy)
+		logins := NewLoginThrottle(store, policy, policy)
+
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.1"))
+		assert.NoError(t, throttle.Attempt("dev", "10.0.0.1"))
+		assert.NoError(t, logins.Attempt("dev", "10.0.0.1"))
+	})
+}
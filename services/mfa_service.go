@@ -0,0 +1,193 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"hackathon/models"
+	"hackathon/pkg/totp"
+	"hackathon/repositories"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFANotEnrolled      = errors.New("two-factor enrollment not started")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor challenge, please login again")
+)
+
+// MFAChallengeTTL is how long the user has to enter a code after the password.
+const MFAChallengeTTL = 5 * time.Minute
+
+// maxMFAAttempts is how many codes can be tried against a challenge. A new challenge needs the
+// password again, and wrong codes also count as failed logins of the user.
+const maxMFAAttempts = 5
+
+const recoveryCodeCount = 10
+
+// MFAService manages the enrollment of users in TOTP two-factor authentication.
+type MFAService struct {
+	userRepo repositories.UserRepository
+	mfaRepo  repositories.MFARepository
+	throttle *LoginThrottle
+	issuer   string
+}
+
+// NewMFAService creates the service. The issuer is the name authenticator apps show for the
+// account. Wrong codes count as failed logins in the throttle.
+func NewMFAService(userRepo repositories.UserRepository, mfaRepo repositories.MFARepository, throttle *LoginThrottle, issuer string) *MFAService {
+	return &MFAService{userRepo: userRepo, mfaRepo: mfaRepo, throttle: throttle, issuer: issuer}
+}
+
+// Status returns whether the user has two-factor authentication and how many recovery codes are left.
+func (s *MFAService) Status(user *models.User) (bool, int64, error) {
+	if !user.MFAEnabled {
+		return false, 0, nil
+	}
+	left, err := s.mfaRepo.CountUnusedRecoveryCodes(user.ID)
+	return true, left, err
+}
+
+// Enroll generates a new TOTP secret for the user and returns it with its otpauth:// URI.
+// Two-factor authentication is only enabled once Confirm gets a code generated from it.
+func (s *MFAService) Enroll(user *models.User) (string, string, error) {
+	if user.MFAEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.userRepo.UpdateMFA(user, secret, false); err != nil {
+		return "", "", err
+	}
+	account := user.Username
+	if user.Email != nil {
+		account = *user.Email
+	}
+	return secret, totp.URI(secret, s.issuer, account), nil
+}
+
+// Confirm enables two-factor authentication with the first code of the enrolled secret and
+// returns the recovery codes, which are only shown here.
+func (s *MFAService) Confirm(user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	if err := s.userRepo.UpdateMFA(user, user.TOTPSecret, true); err != nil {
+		return nil, err
+	}
+	if _, err := s.userRepo.AdvanceTOTPStep(user, step); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(user)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user, after checking a code.
+func (s *MFAService) RegenerateRecoveryCodes(user *models.User, code string, client ClientInfo) ([]string, error) {
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := attemptMFACode(s.throttle, s.userRepo, s.mfaRepo, user, code, client.IP); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(user)
+}
+
+// Disable turns two-factor authentication off, after checking a code.
+func (s *MFAService) Disable(user *models.User, code string, client ClientInfo) error {
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if err := attemptMFACode(s.throttle, s.userRepo, s.mfaRepo, user, code, client.IP); err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdateMFA(user, "", false); err != nil {
+		return err
+	}
+	return s.mfaRepo.DeleteRecoveryCodes(user.ID)
+}
+
+func (s *MFAService) newRecoveryCodes(user *models.User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+	if err := s.mfaRepo.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// attemptMFACode checks a code like checkMFACode, as a login attempt of the user from ip: a wrong
+// code counts as a failed login, and after too many it returns a *TooManyAttemptsError without
+// checking the code.
+func attemptMFACode(throttle *LoginThrottle, userRepo repositories.UserRepository, mfaRepo repositories.MFARepository, user *models.User, code, ip string) error {
+	if err := throttle.Attempt(user.Username, ip); err != nil {
+		return err
+	}
+	if err := checkMFACode(userRepo, mfaRepo, user, code); err != nil {
+		return err
+	}
+	return throttle.Success(user.Username, ip)
+}
+
+// checkMFACode accepts a current TOTP code that was not used before, or an unused recovery code,
+// which is consumed.
+func checkMFACode(userRepo repositories.UserRepository, mfaRepo repositories.MFARepository, user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		advanced, err := userRepo.AdvanceTOTPStep(user, step)
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := mfaRepo.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// newRecoveryCode returns 80 random bits as four groups of four base32 characters.
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	encoded := base32.StdEncoding.EncodeToString(buf)
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// normalizeRecoveryCode makes recovery codes match however the user typed them.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"errors"
+	"hackathon/models"
+	"hackathon/pkg/totp"
+	"hackathon/repositories"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MockMFARepository is a mock implementation of MFARepository for testing
+type MockMFARepository struct {
+	challenges []*models.MFAChallenge
+	codes      []*models.RecoveryCode
+}
+
+func (m *MockMFARepository) CreateChallenge(challenge *models.MFAChallenge) error {
+	challenge.ID = uint(len(m.challenges) + 1)
+	challenge.CreatedAt = time.Now()
+	m.challenges = append(m.challenges, challenge)
+	return nil
+}
+
+func (m *MockMFARepository) FindChallengeByHash(hash string) (*models.MFAChallenge, error) {
+	for _, challenge := range m.challenges {
+		if challenge.TokenHash == hash {
+			copied := *challenge
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockMFARepository) RecordAttempt(challenge *models.MFAChallenge, maxAttempts int) (bool, error) {
+	stored := m.challenges[challenge.ID-1]
+	if stored.UsedAt != nil || stored.Attempts >= maxAttempts {
+		return false, nil
+	}
+	stored.Attempts++
+	challenge.Attempts = stored.Attempts
+	return true, nil
+}
+
+func (m *MockMFARepository) ConsumeChallenge(challenge *models.MFAChallenge) (bool, error) {
+	stored := m.challenges[challenge.ID-1]
+	if stored.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	stored.UsedAt = &now
+	return true, nil
+}
+
+func (m *MockMFARepository) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	if err := m.DeleteRecoveryCodes(userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		m.codes = append(m.codes, &models.RecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	return nil
+}
+
+func (m *MockMFARepository) UseRecoveryCode(userID uint, hash string) (bool, error) {
+	for _, code := range m.codes {
+		if code.UserID == userID && code.CodeHash == hash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockMFARepository) CountUnusedRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	for _, code := range m.codes {
+		if code.UserID == userID && code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockMFARepository) DeleteRecoveryCodes(userID uint) error {
+	kept := m.codes[:0]
+	for _, code := range m.codes {
+		if code.UserID != userID {
+			kept = append(kept, code)
+		}
+	}
+	m.codes = kept
+	return nil
+}
+
+// enrollTestUser enables two-factor authentication for the user and returns its recovery codes.
+// The TOTP code used to confirm is spent, so tests pass codes of later time steps.
+func enrollTestUser(t *testing.T, service *MFAService, user *models.User) []string {
+	_, _, err := service.Enroll(user)
+	assert.NoError(t, err)
+	code, err := totp.Code(user.TOTPSecret, time.Now().Add(-totp.Period))
+	assert.NoError(t, err)
+	recoveryCodes, err := service.Confirm(user, code)
+	assert.NoError(t, err)
+	return recoveryCodes
+}
+
+func TestMFAService_Enrollment(t *testing.T) {
+	email := "dev@example.com"
+	user := &models.User{ID: 1, Username: "dev", Email: &email}
+	users := &MockUserRepository{users: map[string]*models.User{"dev": user}}
+	throttle := NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), testThrottlePolicy, testThrottlePolicy)
+	service := NewMFAService(users, &MockMFARepository{}, throttle, "Hackathon")
+
+	_, err := service.Confirm(user, "123456")
+	assert.Equal(t, ErrMFANotEnrolled, err)
+
+	secret, uri, err := service.Enroll(user)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, user.TOTPSecret)
+	assert.Contains(t, uri, "otpauth://totp/Hackathon:dev@example.com?")
+	assert.False(t, user.MFAEnabled, "enabled once confirmed")
+
+	_, err = service.Confirm(user, "000000")
+	assert.Equal(t, ErrInvalidMFACode, err)
+	code, _ := totp.Code(secret, time.Now())
+	recoveryCodes, err := service.Confirm(user, code)
+	assert.NoError(t, err)
+	assert.True(t, user.MFAEnabled)
+	assert.Len(t, recoveryCodes, recoveryCodeCount)
+
+	enabled, left, err := service.Status(user)
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+	assert.Equal(t, int64(recoveryCodeCount), left)
+
+	_, _, err = service.Enroll(user)
+	assert.Equal(t, ErrMFAAlreadyEnabled, err)
+
+	assert.Equal(t, ErrInvalidMFACode, service.Disable(user, code, ClientInfo{}), "codes cannot be replayed")
+	assert.NoError(t, service.Disable(user, recoveryCodes[0], ClientInfo{}))
+	assert.False(t, user.MFAEnabled)
+	assert.Empty(t, user.TOTPSecret)
+	_, left, _ = service.Status(user)
+	assert.Zero(t, left)
+}
+
+func TestMFAService_ThrottlesCodes(t *testing.T) {
+	user := &models.User{ID: 1, Username: "dev"}
+	users := &MockUserRepository{users: map[string]*models.User{"dev": user}}
+	throttle := NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), testThrottlePolicy, testThrottlePolicy)
+	service := NewMFAService(users, &MockMFARepository{}, throttle, "Hackathon")
+	recoveryCodes := enrollTestUser(t, service, user)
+	client := ClientInfo{IP: "10.0.0.1"}
+
+	for range testThrottlePolicy.FreeAttempts + 1 {
+		_, err := service.RegenerateRecoveryCodes(user, "000000", client)
+		assert.Equal(t, ErrInvalidMFACode, err)
+	}
+	assert.ErrorIs(t, service.Disable(user, recoveryCodes[0], client), ErrTooManyAttempts)
+	_, err := service.RegenerateRecoveryCodes(user, recoveryCodes[0], ClientInfo{IP: "10.0.0.2"})
+	assert.ErrorIs(t, err, ErrTooManyAttempts, "the username is locked out from every client")
+	assert.True(t, user.MFAEnabled)
+}
+
+func TestAuthService_LoginWithMFA(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	newService := func() (*AuthService, *models.User, []string) {
+		user := &models.User{ID: 1, Username: "testuser", Password: string(hashedPassword)}
+		repo := &MockUserRepository{users: map[string]*models.User{"testuser": user}}
+		auth := newTestAuthService(repo)
+		recoveryCodes := enrollTestUser(t, NewMFAService(repo, auth.mfaRepo, auth.throttle, "Hackathon"), user)
+		return auth, user, recoveryCodes
+	}
+
+	t.Run("returns a challenge instead of tokens", func(t *testing.T) {
+		service, user, _ := newService()
+
+		response, err := service.Login("testuser", "password", ClientInfo{Device: "phone"})
+		assert.NoError(t, err)
+		assert.Nil(t, response.TokenResponse)
+		assert.True(t, response.MFARequired)
+		assert.NotEmpty(t, response.MFAToken)
+		assert.WithinDuration(t, time.Now().Add(MFAChallengeTTL), time.Unix(response.MFAExpiredTime, 0), time.Minute)
+
+		_, err = service.VerifyMFA(response.MFAToken, "000000", "")
+		assert.Equal(t, ErrInvalidMFACode, err)
+
+		code, _ := totp.Code(user.TOTPSecret, time.Now())
+		tokens, err := service.VerifyMFA(response.MFAToken, code, "")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tokens.Token)
+		sessions, _ := service.ListSessions(1)
+		assert.Len(t, sessions, 1)
+		assert.Equal(t, "phone", sessions[0].Device)
+
+		_, err = service.VerifyMFA(response.MFAToken, code, "")
+		assert.Equal(t, ErrInvalidMFAChallenge, err, "challenges are single-use")
+	})
+
+	t.Run("accepts a recovery code once", func(t *testing.T) {
+		service, _, recoveryCodes := newService()
+
+		response, _ := service.Login("testuser", "password", ClientInfo{})
+		_, err := service.VerifyMFA(response.MFAToken, recoveryCodes[3], "")
+		assert.NoError(t, err)
+
+		response, _ = service.Login("testuser", "password", ClientInfo{})
+		_, err = service.VerifyMFA(response.MFAToken, recoveryCodes[3], "")
+		assert.Equal(t, ErrInvalidMFACode, err)
+	})
+
+	t.Run("limits attempts per challenge", func(t *testing.T) {
+		service, user, _ := newService()
+		lenient := ThrottlePolicy{FreeAttempts: 100, MaxAttempts: 100, Lockout: time.Minute}
+		service.throttle = NewLoginThrottle(repositories.NewMemoryLoginAttemptRepository(), lenient, lenient)
+
+		response, _ := service.Login("testuser", "password", ClientInfo{})
+		for i := 0; i < maxMFAAttempts; i++ {
+			_, err := service.VerifyMFA(response.MFAToken, "000000", "")
+			assert.Equal(t, ErrInvalidMFACode, err)
+		}
+		code, _ := totp.Code(user.TOTPSecret, time.Now())
+		_, err := service.VerifyMFA(response.MFAToken, code, "")
+		assert.Equal(t, ErrInvalidMFAChallenge, err)
+	})
+
+	t.Run("wrong codes count across challenges", func(t *testing.T) {
+		service, user, _ := newService()
+		client := ClientInfo{IP: "10.0.0.1"}
+		early, err := service.Login("testuser", "password", client)
+		assert.NoError(t, err)
+
+		// Every new challenge needs the correct password, which must not reset the count.
+		for range testThrottlePolicy.FreeAttempts + 1 {
+			response, err := service.Login("testuser", "password", client)
+			assert.NoError(t, err)
+			_, err = service.VerifyMFA(response.MFAToken, "000000", client.IP)
+			assert.Equal(t, ErrInvalidMFACode, err)
+		}
+
+		_, err = service.Login("testuser", "password", ClientInfo{IP: "10.0.0.2"})
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+		code, _ := totp.Code(user.TOTPSecret, time.Now())
+		_, err = service.VerifyMFA(early.MFAToken, code, "10.0.0.2")
+		assert.ErrorIs(t, err, ErrTooManyAttempts, "challenges issued before the lockout are throttled too")
+	})
+
+	t.Run("expired challenge", func(t *testing.T) {
+		service, user, _ := newService()
+
+		response, _ := service.Login("testuser", "password", ClientInfo{})
+		service.mfaRepo.(*MockMFARepository).challenges[0].ExpiresAt = time.Now().Add(-time.Second)
+		code, _ := totp.Code(user.TOTPSecret, time.Now())
+		_, err := service.VerifyMFA(response.MFAToken, code, "")
+		assert.Equal(t, ErrInvalidMFAChallenge, err)
+	})
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hackathon/dto"
+	"hackathon/models"
+	"hackathon/pkg/oidc"
+	"hackathon/repositories"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrOIDCDisabled             = errors.New("single sign-on is not configured")
+	ErrInvalidOIDCState         = errors.New("invalid or expired sign-in, please start again")
+	ErrOIDCFailed               = errors.New("sign-in with the identity provider failed")
+	ErrOIDCRegistrationDisabled = errors.New("no account is linked to this identity")
+	ErrIdentityNotLinked        = errors.New("an account with this email address already exists, login with your password and link the identity from your account")
+	ErrIdentityLinked           = errors.New("identity is linked to another account")
+	ErrIdentityNotYours         = errors.New("sign in with an identity linked to your account")
+)
+
+// OIDCStateTTL is how long a user has to complete a sign-in at the identity provider.
+const OIDCStateTTL = 10 * time.Minute
+
+// maxUsernameAttempts bounds the random suffixes tried when the username of a new account is taken.
+const maxUsernameAttempts = 5
+
+type OIDCOptions struct {
+	// AutoRegister creates an account on the first sign-in of an identity that is not linked yet.
+	// Without it, users must link their identity from an existing account first.
+	AutoRegister bool
+}
+
+// OIDCResult is the outcome of a sign-in: either a login or, when it was started by a logged in
+// user, the identity linked to their account or used to reauthenticate their session.
+type OIDCResult struct {
+	Login           *dto.LoginResponse
+	Linked          *models.Identity
+	Reauthenticated *models.Identity
+}
+
+type OIDCService struct {
+	identityRepo repositories.IdentityRepository
+	userRepo     repositories.UserRepository
+	roleRepo     repositories.RoleRepository
+	auth         *AuthService
+	provider     *oidc.Provider
+	opts         OIDCOptions
+}
+
+// NewOIDCService returns the single sign-on service. A nil provider disables it.
+func NewOIDCService(identityRepo repositories.IdentityRepository, userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, auth *AuthService, provider *oidc.Provider, opts OIDCOptions) *OIDCService {
+	return &OIDCService{
+		identityRepo: identityRepo,
+		userRepo:     userRepo,
+		roleRepo:     roleRepo,
+		auth:         auth,
+		provider:     provider,
+		opts:         opts,
+	}
+}
+
+func (s *OIDCService) Enabled() bool {
+	return s.provider != nil
+}
+
+// Begin starts a sign-in and returns the authorization URL to send the browser to, and the state
+// the callback must come back with. The caller binds the state to the browser, e.g. in a cookie.
+// If linkTo is set, the identity is linked to that user instead of logging in.
+func (s *OIDCService) Begin(device string, linkTo *models.User) (string, string, error) {
+	record := &models.OIDCLoginState{Device: device}
+	if linkTo != nil {
+		record.UserID = &linkTo.ID
+	}
+	return s.begin(record)
+}
+
+// BeginReauth starts a sign-in that confirms the identity of the user for the session, in place of
+// the password they do not have. It must be completed with an identity linked to the user.
+func (s *OIDCService) BeginReauth(user *models.User, session *models.Session) (string, string, error) {
+	return s.begin(&models.OIDCLoginState{UserID: &user.ID, SessionID: &session.ID})
+}
+
+func (s *OIDCService) begin(record *models.OIDCLoginState) (string, string, error) {
+	if !s.Enabled() {
+		return "", "", ErrOIDCDisabled
+	}
+	state, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", "", err
+	}
+	record.StateHash = hashToken(state)
+	record.Nonce = nonce
+	record.Verifier = verifier
+	record.ExpiresAt = time.Now().Add(OIDCStateTTL)
+	if err := s.identityRepo.CreateLoginState(record); err != nil {
+		return "", "", err
+	}
+	return s.provider.AuthCodeURL(state, nonce, verifier), state, nil
+}
+
+// Complete finishes a sign-in with the state and code of the callback. The code is exchanged for
+// an ID token, which must carry the nonce of the sign-in.
+func (s *OIDCService) Complete(ctx context.Context, state, code string, client ClientInfo) (*OIDCResult, error) {
+	if !s.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+	record, err := s.identityRepo.FindLoginStateByHash(hashToken(state))
+	if err != nil || record.UsedAt != nil || time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+	consumed, err := s.identityRepo.ConsumeLoginState(record)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrInvalidOIDCState
+	}
+
+	token, err := s.provider.Exchange(ctx, code, record.Verifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	claims, err := s.provider.VerifyIDToken(ctx, token.IDToken, record.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+
+	if record.SessionID != nil {
+		identity, err := s.reauthenticate(*record.UserID, *record.SessionID, claims)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCResult{Reauthenticated: identity}, nil
+	}
+	if record.UserID != nil {
+		identity, err := s.link(*record.UserID, claims)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCResult{Linked: identity}, nil
+	}
+	client.Device = record.Device
+	login, err := s.login(claims, client)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCResult{Login: &login}, nil
+}
+
+// login signs in the user the identity is linked to, registering one first if allowed. Unlike
+// password logins it ignores a required password reset, since no password is involved.
+func (s *OIDCService) login(claims *oidc.Claims, client ClientInfo) (dto.LoginResponse, error) {
+	var user *models.User
+	identity, err := s.identityRepo.FindByIssuerAndSubject(s.provider.Issuer(), claims.Subject)
+	switch {
+	case err == nil:
+		user, err = s.userRepo.FindByID(identity.UserID)
+	case errors.Is(err, repositories.ErrRecordNotFound):
+		user, identity, err = s.register(claims)
+	}
+	if err != nil {
+		return dto.LoginResponse{}, err
+	}
+
+	if user.Disabled {
+		return dto.LoginResponse{}, ErrAccountDisabled
+	}
+	if s.auth.opts.LoginRequiresVerifiedEmail && user.Email != nil && !user.EmailVerified {
+		return dto.LoginResponse{}, ErrEmailNotVerified
+	}
+	if err := s.identityRepo.Touch(identity, time.Now()); err != nil {
+		return dto.LoginResponse{}, err
+	}
+
+	if user.MFAEnabled {
+		return s.auth.issueMFAChallenge(user, client)
+	}
+	tokens, err := s.auth.startSession(user, client)
+	if err != nil {
+		return dto.LoginResponse{}, err
+	}
+	return dto.LoginResponse{TokenResponse: &tokens}, nil
+}
+
+// register creates an account for an identity that is not linked yet. An existing account with
+// the same email address is never taken over; its owner has to link the identity themselves.
+func (s *OIDCService) register(claims *oidc.Claims) (*models.User, *models.Identity, error) {
+	if !s.opts.AutoRegister {
+		return nil, nil, ErrOIDCRegistrationDisabled
+	}
+	var email *string
+	if claims.Email != "" {
+		normalized := normalizeEmail(claims.Email)
+		if _, err := s.userRepo.FindByEmail(normalized); err == nil {
+			return nil, nil, ErrIdentityNotLinked
+		} else if !errors.Is(err, repositories.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		if claims.EmailVerified {
+			email = &normalized
+		}
+	}
+
+	role, err := s.roleRepo.FindByName(models.RoleUser)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The account has no usable password; the user can set one with a password reset, or change
+	// it after reauthenticating.
+	password, err := newOpaqueToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashed, err := s.auth.hashPassword(password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base := oidcUsername(claims)
+	user := &models.User{
+		Email:         email,
+		EmailVerified: email != nil,
+		Password:      hashed,
+		NoPassword:    true,
+		DisplayName:   claims.Name,
+		Roles:         []models.Role{*role},
+	}
+	for attempt := 0; ; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix, err := randomHex(3)
+			if err != nil {
+				return nil, nil, err
+			}
+			user.Username = base + "-" + suffix
+		}
+		err = s.userRepo.Create(user)
+		if !errors.Is(err, repositories.ErrDuplicateUsername) || attempt == maxUsernameAttempts-1 {
+			break
+		}
+	}
+	switch {
+	case errors.Is(err, repositories.ErrDuplicateUsername):
+		return nil, nil, ErrUserExists
+	case errors.Is(err, repositories.ErrDuplicateEmail):
+		return nil, nil, ErrIdentityNotLinked
+	case err != nil:
+		return nil, nil, err
+	}
+
+	identity := &models.Identity{UserID: user.ID, Issuer: s.provider.Issuer(), Subject: claims.Subject}
+	if claims.Email != "" {
+		identity.Email = &claims.Email
+	}
+	if err := s.identityRepo.Create(identity); err != nil {
+		return nil, nil, err
+	}
+	return user, identity, nil
+}
+
+// link links the identity to the user, unless it is linked to someone else.
+func (s *OIDCService) link(userID uint, claims *oidc.Claims) (*models.Identity, error) {
+	existing, err := s.identityRepo.FindByIssuerAndSubject(s.provider.Issuer(), claims.Subject)
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrIdentityLinked
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, repositories.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	identity := &models.Identity{UserID: userID, Issuer: s.provider.Issuer(), Subject: claims.Subject}
+	if claims.Email != "" {
+		identity.Email = &claims.Email
+	}
+	if err := s.identityRepo.Create(identity); err != nil {
+		if errors.Is(err, repositories.ErrDuplicateIdentity) {
+			return nil, ErrIdentityLinked
+		}
+		return nil, err
+	}
+	return identity, nil
+}
+
+// reauthenticate records on the session that the user just signed in again with an identity
+// linked to their account.
+func (s *OIDCService) reauthenticate(userID uint, sessionID string, claims *oidc.Claims) (*models.Identity, error) {
+	identity, err := s.identityRepo.FindByIssuerAndSubject(s.provider.Issuer(), claims.Subject)
+	if errors.Is(err, repositories.ErrRecordNotFound) || (err == nil && identity.UserID != userID) {
+		return nil, ErrIdentityNotYours
+	}
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.auth.sessionRepo.FindByID(sessionID)
+	if err != nil || session.UserID != userID || session.RevokedAt != nil {
+		return nil, ErrSessionNotFound
+	}
+	now := time.Now()
+	if err := s.identityRepo.Touch(identity, now); err != nil {
+		return nil, err
+	}
+	if err := s.auth.sessionRepo.MarkReauthenticated(session, now); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (s *OIDCService) ListIdentities(userID uint) ([]models.Identity, error) {
+	return s.identityRepo.ListByUser(userID)
+}
+
+// RunPruner deletes expired sign-in states until ctx is cancelled.
+func (s *OIDCService) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.identityRepo.DeleteLoginStatesBefore(time.Now())
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to prune OIDC login states")
+			} else if deleted > 0 {
+				log.Info().Int64("count", deleted).Msg("Pruned OIDC login states")
+			}
+		}
+	}
+}
+
+// oidcUsername picks the username of a new account: the preferred username of the identity, or
+// else the local part of its email address.
+func oidcUsername(claims *oidc.Claims) string {
+	username := strings.TrimSpace(claims.PreferredUsername)
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+	username = strings.Join(strings.Fields(username), "-")
+	if runes := []rune(username); len(runes) > 50 {
+		username = string(runes[:50])
+	}
+	if username == "" {
+		username = "user"
+	}
+	return username
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"hackathon/models"
+	"hackathon/pkg/oidc"
+	"hackathon/pkg/oidc/oidctest"
+	"hackathon/repositories"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockIdentityRepository struct {
+	identities []*models.Identity
+	states     []*models.OIDCLoginState
+}
+
+func (m *MockIdentityRepository) Create(identity *models.Identity) error {
+	for _, existing := range m.identities {
+		if existing.Issuer == identity.Issuer && existing.Subject == identity.Subject {
+			return repositories.ErrDuplicateIdentity
+		}
+	}
+	identity.ID = uint(len(m.identities) + 1)
+	identity.CreatedAt = time.Now()
+	m.identities = append(m.identities, identity)
+	return nil
+}
+
+func (m *MockIdentityRepository) FindByIssuerAndSubject(issuer, subject string) (*models.Identity, error) {
+	for _, identity := range m.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, repositories.ErrRecordNotFound
+}
+
+func (m *MockIdentityRepository) ListByUser(userID uint) ([]models.Identity, error) {
+	var identities []models.Identity
+	for _, identity := range m.identities {
+		if identity.UserID == userID {
+			identities = append(identities, *identity)
+		}
+	}
+	return identities, nil
+}
+
+func (m *MockIdentityRepository) Touch(identity *models.Identity, loginAt time.Time) error {
+	identity.LastLoginAt = &loginAt
+	return nil
+}
+
+func (m *MockIdentityRepository) CreateLoginState(state *models.OIDCLoginState) error {
+	state.ID = uint(len(m.states) + 1)
+	state.CreatedAt = time.Now()
+	m.states = append(m.states, state)
+	return nil
+}
+
+func (m *MockIdentityRepository) FindLoginStateByHash(hash string) (*models.OIDCLoginState, error) {
+	for _, state := range m.states {
+		if state.StateHash == hash {
+			copied := *state
+			return &copied, nil
+		}
+	}
+	return nil, repositories.ErrRecordNotFound
+}
+
+func (m *MockIdentityRepository) ConsumeLoginState(state *models.OIDCLoginState) (bool, error) {
+	for _, stored := range m.states {
+		if stored.ID == state.ID {
+			if stored.UsedAt != nil {
+				return false, nil
+			}
+			now := time.Now()
+			stored.UsedAt = &now
+			state.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockIdentityRepository) DeleteLoginStatesBefore(cutoff time.Time) (int64, error) {
+	var kept []*models.OIDCLoginState
+	for _, state := range m.states {
+		if !state.ExpiresAt.Before(cutoff) {
+			kept = append(kept, state)
+		}
+	}
+	deleted := int64(len(m.states) - len(kept))
+	m.states = kept
+	return deleted, nil
+}
+
+func newTestOIDCService(t *testing.T, opts OIDCOptions) (*OIDCService, *oidctest.Provider, *MockUserRepository, *MockIdentityRepository) {
+	mock, err := oidctest.New("client", "secret")
+	assert.NoError(t, err)
+	t.Cleanup(mock.Close)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       mock.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+	assert.NoError(t, err)
+
+	users := &MockUserRepository{users: make(map[string]*models.User)}
+	identities := &MockIdentityRepository{}
+	service := NewOIDCService(identities, users, newMockRoleRepository(), newTestAuthService(users), provider, opts)
+	return service, mock, users, identities
+}
+
+// signInOIDC starts a sign-in, lets the provider sign in its user the way a browser would and
+// completes the sign-in with the callback.
+func signInOIDC(t *testing.T, service *OIDCService, mock *oidctest.Provider, linkTo *models.User) (*OIDCResult, error) {
+	authURL, state, err := service.Begin("laptop", linkTo)
+	assert.NoError(t, err)
+	callback, err := mock.Authorize(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, state, callback.Query().Get("state"))
+	return service.Complete(context.Background(), state, callback.Query().Get("code"), ClientInfo{IP: "192.0.2.1"})
+}
+
+func TestOIDCService_Login(t *testing.T) {
+	t.Run("first sign-in registers an account", func(t *testing.T) {
+		service, mock, users, identities := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+
+		result, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, result.Linked)
+		assert.NotNil(t, result.Login.TokenResponse)
+		assert.NotEmpty(t, result.Login.Token)
+
+		user, err := users.FindByUsername("user")
+		assert.NoError(t, err)
+		assert.Equal(t, "user@example.com", *user.Email)
+		assert.True(t, user.EmailVerified)
+		assert.Equal(t, "Test User", user.DisplayName)
+		assert.Equal(t, models.RoleUser, user.Roles[0].Name)
+		assert.Len(t, identities.identities, 1)
+		assert.Equal(t, user.ID, identities.identities[0].UserID)
+		assert.Equal(t, mock.Issuer(), identities.identities[0].Issuer)
+		assert.Equal(t, "subject-1", identities.identities[0].Subject)
+		assert.NotNil(t, identities.identities[0].LastLoginAt)
+
+		sessions := service.auth.sessionRepo.(*MockSessionRepository).sessions
+		assert.Equal(t, "laptop", sessions[len(sessions)-1].Device)
+	})
+
+	t.Run("later sign-ins use the linked account", func(t *testing.T) {
+		service, mock, users, identities := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+
+		mock.SetUser(oidctest.User{Subject: "subject-1", Email: "renamed@example.com", EmailVerified: true, PreferredUsername: "renamed"})
+		result, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		assert.NotNil(t, result.Login.TokenResponse)
+		assert.Len(t, users.users, 1)
+		assert.Len(t, identities.identities, 1)
+	})
+
+	t.Run("taken usernames get a suffix", func(t *testing.T) {
+		service, mock, users, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		users.users["user"] = &models.User{ID: 1, Username: "user"}
+
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		assert.Len(t, users.users, 2)
+		for username := range users.users {
+			assert.True(t, username == "user" || strings.HasPrefix(username, "user-"), username)
+		}
+	})
+
+	t.Run("unverified email addresses are not stored", func(t *testing.T) {
+		service, mock, users, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		mock.SetUser(oidctest.User{Subject: "subject-2", Email: "dev@example.com", PreferredUsername: "dev"})
+
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		user, err := users.FindByUsername("dev")
+		assert.NoError(t, err)
+		assert.Nil(t, user.Email)
+		assert.False(t, user.EmailVerified)
+	})
+
+	t.Run("existing accounts are not taken over by email address", func(t *testing.T) {
+		service, mock, users, identities := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		email := "user@example.com"
+		users.users["dev"] = &models.User{ID: 1, Username: "dev", Email: &email}
+
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.Equal(t, ErrIdentityNotLinked, err)
+		assert.Empty(t, identities.identities)
+		assert.Len(t, users.users, 1)
+	})
+
+	t.Run("registration disabled", func(t *testing.T) {
+		service, mock, users, _ := newTestOIDCService(t, OIDCOptions{})
+
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.Equal(t, ErrOIDCRegistrationDisabled, err)
+		assert.Empty(t, users.users)
+	})
+
+	t.Run("disabled account", func(t *testing.T) {
+		service, mock, users, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		users.users["user"].Disabled = true
+
+		_, err = signInOIDC(t, service, mock, nil)
+		assert.Equal(t, ErrAccountDisabled, err)
+	})
+
+	t.Run("two-factor authentication is still required", func(t *testing.T) {
+		service, mock, users, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		users.users["user"].MFAEnabled = true
+
+		result, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		assert.True(t, result.Login.MFARequired)
+		assert.NotEmpty(t, result.Login.MFAToken)
+		assert.Nil(t, result.Login.TokenResponse)
+	})
+
+	t.Run("ID token with another nonce", func(t *testing.T) {
+		service, mock, users, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		mock.ClaimsHook = func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }
+
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.ErrorIs(t, err, ErrOIDCFailed)
+		assert.Empty(t, users.users)
+	})
+}
+
+func TestOIDCService_State(t *testing.T) {
+	ctx := context.Background()
+	client := ClientInfo{IP: "192.0.2.1"}
+
+	t.Run("states are single-use", func(t *testing.T) {
+		service, mock, _, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		authURL, state, err := service.Begin("", nil)
+		assert.NoError(t, err)
+		callback, err := mock.Authorize(authURL)
+		assert.NoError(t, err)
+		code := callback.Query().Get("code")
+
+		_, err = service.Complete(ctx, state, code, client)
+		assert.NoError(t, err)
+		_, err = service.Complete(ctx, state, code, client)
+		assert.Equal(t, ErrInvalidOIDCState, err)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		service, _, _, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		_, err := service.Complete(ctx, "forged", "code", client)
+		assert.Equal(t, ErrInvalidOIDCState, err)
+	})
+
+	t.Run("expired state", func(t *testing.T) {
+		service, mock, _, identities := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		authURL, state, err := service.Begin("", nil)
+		assert.NoError(t, err)
+		callback, err := mock.Authorize(authURL)
+		assert.NoError(t, err)
+		identities.states[0].ExpiresAt = time.Now().Add(-time.Second)
+
+		_, err = service.Complete(ctx, state, callback.Query().Get("code"), client)
+		assert.Equal(t, ErrInvalidOIDCState, err)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		users := &MockUserRepository{users: make(map[string]*models.User)}
+		service := NewOIDCService(&MockIdentityRepository{}, users, newMockRoleRepository(), newTestAuthService(users), nil, OIDCOptions{})
+		assert.False(t, service.Enabled())
+		_, _, err := service.Begin("", nil)
+		assert.Equal(t, ErrOIDCDisabled, err)
+		_, err = service.Complete(ctx, "state", "code", client)
+		assert.Equal(t, ErrOIDCDisabled, err)
+	})
+}
+
+func TestOIDCService_Link(t *testing.T) {
+	service, mock, users, _ := newTestOIDCService(t, OIDCOptions{})
+	email := "user@example.com"
+	dev := &models.User{ID: 1, Username: "dev", Email: &email, EmailVerified: true}
+	other := &models.User{ID: 2, Username: "other"}
+	users.users["dev"] = dev
+	users.users["other"] = other
+
+	result, err := signInOIDC(t, service, mock, dev)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Login)
+	assert.Equal(t, dev.ID, result.Linked.UserID)
+	assert.Equal(t, "user@example.com", *result.Linked.Email)
+
+	t.Run("linking again is a no-op", func(t *testing.T) {
+		again, err := signInOIDC(t, service, mock, dev)
+		assert.NoError(t, err)
+		assert.Equal(t, result.Linked.ID, again.Linked.ID)
+	})
+
+	t.Run("linked identities sign in to the account", func(t *testing.T) {
+		login, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		assert.NotNil(t, login.Login.TokenResponse)
+		identities, err := service.ListIdentities(dev.ID)
+		assert.NoError(t, err)
+		assert.Len(t, identities, 1)
+		assert.NotNil(t, identities[0].LastLoginAt)
+	})
+
+	t.Run("identity of another account", func(t *testing.T) {
+		_, err := signInOIDC(t, service, mock, other)
+		assert.Equal(t, ErrIdentityLinked, err)
+		identities, err := service.ListIdentities(other.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, identities)
+	})
+}
+
+func TestOIDCService_Reauthenticate(t *testing.T) {
+	ctx := context.Background()
+	client := ClientInfo{IP: "192.0.2.1"}
+	newAccount := func(t *testing.T) (*OIDCService, *oidctest.Provider, *models.User, *models.Session) {
+		service, mock, users, _ := newTestOIDCService(t, OIDCOptions{AutoRegister: true})
+		_, err := signInOIDC(t, service, mock, nil)
+		assert.NoError(t, err)
+		user, err := users.FindByUsername("user")
+		assert.NoError(t, err)
+		assert.True(t, user.NoPassword)
+		sessions := service.auth.sessionRepo.(*MockSessionRepository).sessions
+		return service, mock, user, sessions[len(sessions)-1]
+	}
+	reauthenticate := func(t *testing.T, service *OIDCService, mock *oidctest.Provider, user *models.User, session *models.Session) error {
+		authURL, state, err := service.BeginReauth(user, session)
+		assert.NoError(t, err)
+		callback, err := mock.Authorize(authURL)
+		assert.NoError(t, err)
+		result, err := service.Complete(ctx, state, callback.Query().Get("code"), client)
+		if err != nil {
+			return err
+		}
+		assert.Nil(t, result.Login)
+		assert.Equal(t, user.ID, result.Reauthenticated.UserID)
+		return nil
+	}
+
+	t.Run("required without a password", func(t *testing.T) {
+		service, _, user, session := newAccount(t)
+
+		_, err := service.auth.ChangePassword(user, session, "", "new-password", client)
+		assert.Equal(t, ErrReauthRequired, err)
+
+		stale := time.Now().Add(-ReauthTTL - time.Minute)
+		session.ReauthenticatedAt = &stale
+		_, err = service.auth.ChangePassword(user, session, "", "new-password", client)
+		assert.Equal(t, ErrReauthRequired, err)
+	})
+
+	t.Run("allows deleting the account", func(t *testing.T) {
+		service, mock, user, session := newAccount(t)
+		uploads, fileRepo := newTestUploadService(t)
+		jobs := &MockExportJobRepository{jobs: make(map[string]*models.ExportJob)}
+		exports := NewExportService(service.userRepo, newMockRoleRepository(), fileRepo, jobs, uploads.files.storage, 1, time.Hour)
+		accounts := NewAccountService(service.userRepo, &MockMFARepository{}, service.auth, uploads.files, uploads, exports)
+
+		assert.Equal(t, ErrReauthRequired, accounts.DeleteAccount(ctx, user, session, "", "", client))
+
+		assert.NoError(t, reauthenticate(t, service, mock, user, session))
+		stored, err := service.auth.sessionRepo.FindByID(session.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, stored.ReauthenticatedAt)
+
+		assert.NoError(t, accounts.DeleteAccount(ctx, user, stored, "", "", client))
+		assert.True(t, user.Disabled)
+		assert.NotNil(t, user.AnonymizedAt)
+	})
+
+	t.Run("allows setting a password", func(t *testing.T) {
+		service, mock, user, session := newAccount(t)
+		assert.NoError(t, reauthenticate(t, service, mock, user, session))
+		stored, err := service.auth.sessionRepo.FindByID(session.ID)
+		assert.NoError(t, err)
+
+		_, err = service.auth.ChangePassword(user, stored, "", "new-password", client)
+		assert.NoError(t, err)
+		assert.False(t, user.NoPassword)
+		_, err = service.auth.Login("user", "new-password", client)
+		assert.NoError(t, err)
+	})
+
+	t.Run("identity of another account", func(t *testing.T) {
+		service, mock, user, session := newAccount(t)
+		mock.SetUser(oidctest.User{Subject: "subject-2", Email: "other@example.com", EmailVerified: true, PreferredUsername: "other"})
+
+		assert.Equal(t, ErrIdentityNotYours, reauthenticate(t, service, mock, user, session))
+		stored, err := service.auth.sessionRepo.FindByID(session.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, stored.ReauthenticatedAt)
+	})
+
+	t.Run("accounts with a password still need it", func(t *testing.T) {
+		service, mock, user, session := newAccount(t)
+		assert.NoError(t, reauthenticate(t, service, mock, user, session))
+		stored, err := service.auth.sessionRepo.FindByID(session.ID)
+		assert.NoError(t, err)
+		_, err = service.auth.ChangePassword(user, stored, "", "new-password", client)
+		assert.NoError(t, err)
+
+		_, err = service.auth.ChangePassword(user, stored, "wrong", "other-password", client)
+		assert.Equal(t, ErrWrongPassword, err)
+	})
+}
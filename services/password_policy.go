@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hackathon/pkg/pwned"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is matched by every *PasswordPolicyError.
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// Password policy rules, reported in PasswordViolation.Rule.
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleCharacterClasses = "character_classes"
+	RuleContainsUsername = "contains_username"
+	RuleBreached         = "breached"
+)
+
+// PasswordViolation is one rule a password does not meet.
+type PasswordViolation struct {
+	Rule    string
+	Message string
+}
+
+// PasswordPolicyError lists every rule a rejected password violates.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password " + strings.Join(messages, ", ")
+}
+
+func (e *PasswordPolicyError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+// PasswordPolicy decides which new passwords are accepted. Lengths count characters, not bytes.
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength of 0 allows any length.
+	MaxLength int
+	// MinClasses is how many of lowercase letters, uppercase letters, digits and symbols a
+	// password must mix.
+	MinClasses int
+	// AllowUsername allows passwords that contain the username.
+	AllowUsername bool
+	// Breached rejects passwords known from data breaches, if set.
+	Breached pwned.List
+}
+
+// minUsernameLength is the shortest username passwords are checked for, shorter ones would reject
+// too many passwords by accident.
+const minUsernameLength = 3
+
+// Check returns a *PasswordPolicyError if the password of the user violates the policy.
+func (p PasswordPolicy) Check(username, password string) error {
+	var violations []PasswordViolation
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PasswordViolation{RuleMinLength, fmt.Sprintf("must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PasswordViolation{RuleMaxLength, fmt.Sprintf("must be at most %d characters long", p.MaxLength)})
+	}
+	if characterClasses(password) < p.MinClasses {
+		violations = append(violations, PasswordViolation{RuleCharacterClasses,
+			fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinClasses)})
+	}
+	if !p.AllowUsername && utf8.RuneCountInString(username) >= minUsernameLength &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, PasswordViolation{RuleContainsUsername, "must not contain the username"})
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, PasswordViolation{RuleBreached, "has appeared in a data breach and must not be used"})
+		}
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"hackathon/models"
+	"hackathon/pkg/pwned"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func violatedRules(err error) []string {
+	var weak *PasswordPolicyError
+	if !errors.As(err, &weak) {
+		return nil
+	}
+	rules := make([]string, len(weak.Violations))
+	for i, violation := range weak.Violations {
+		rules[i] = violation.Rule
+	}
+	return rules
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	breached, err := pwned.Load(strings.NewReader(sha1Hex("Password1!") + ":42\n"))
+	assert.NoError(t, err)
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 16, MinClasses: 3, Breached: breached}
+
+	tests := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{"valid", "Tr0ub4dor&3", nil},
+		{"too short", "Ab1!", []string{RuleMinLength}},
+		{"too long", "Abcdefgh1234567890", []string{RuleMaxLength}},
+		{"lengths count characters, not bytes", "Äbcdé1!", []string{RuleMinLength}},
+		{"too few character classes", "abcdefgh12", []string{RuleCharacterClasses}},
+		{"contains the username", "xDEVELOPERx1", []string{RuleContainsUsername}},
+		{"breached", "Password1!", []string{RuleBreached}},
+		{"every violation is reported", "dev", []string{RuleMinLength, RuleCharacterClasses}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check("developer", tt.password)
+			if tt.rules == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrWeakPassword)
+			assert.Equal(t, tt.rules, violatedRules(err))
+		})
+	}
+
+	t.Run("short usernames are not checked", func(t *testing.T) {
+		assert.NoError(t, policy.Check("al", "Always-1-al"))
+	})
+
+	t.Run("username check can be disabled", func(t *testing.T) {
+		allow := policy
+		allow.AllowUsername = true
+		assert.NoError(t, allow.Check("developer", "xDEVELOPERx1"))
+	})
+}
+
+func TestAuthService_RegisterWeakPassword(t *testing.T) {
+	repo := &MockUserRepository{users: make(map[string]*models.User)}
+	service := newTestAuthService(repo)
+	service.opts.PasswordPolicy = PasswordPolicy{MinLength: 10}
+
+	_, err := service.Register("testuser", "dev@example.com", "short")
+	assert.Equal(t, []string{RuleMinLength}, violatedRules(err))
+	assert.Empty(t, repo.users)
+}
+
+func TestPasswordService_ResetWeakPassword(t *testing.T) {
+	service, users, _, mail := newTestPasswordService()
+	service.auth.opts.PasswordPolicy = PasswordPolicy{MinLength: 10}
+	assert.NoError(t, service.ForgotPassword(context.Background(), "dev"))
+	token := resetTokenFrom(t, mail.sent[0])
+
+	err := service.ResetPassword(token, "short")
+	assert.Equal(t, []string{RuleMinLength}, violatedRules(err))
+
+	assert.NoError(t, service.ResetPassword(token, "long enough password"), "the token stays usable")
+	ok, _, err := testHasher.Verify("long enough password", users.users["dev"].Password)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
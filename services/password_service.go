@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hackathon/mailer"
+	"hackathon/models"
+	"hackathon/repositories"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// PasswordResetTTL is how long a password reset link can be used.
+const PasswordResetTTL = time.Hour
+
+// PasswordService lets users who forgot their password choose a new one through a link sent by mail.
+type PasswordService struct {
+	userRepo  repositories.UserRepository
+	resetRepo repositories.PasswordResetRepository
+	auth      *AuthService
+	mail      mailer.Mailer
+	resetURL  string
+}
+
+// NewPasswordService creates the service. resetURL is the link sent by mail, "{token}" in it is
+// replaced by the reset token.
+func NewPasswordService(userRepo repositories.UserRepository, resetRepo repositories.PasswordResetRepository, auth *AuthService, mail mailer.Mailer, resetURL string) *PasswordService {
+	return &PasswordService{userRepo: userRepo, resetRepo: resetRepo, auth: auth, mail: mail, resetURL: resetURL}
+}
+
+// ForgotPassword mails a reset link to the user, found by username or email address. Unknown
+// accounts and accounts without a verified email address are silently ignored; callers must not
+// tell the client which case applied.
+func (s *PasswordService) ForgotPassword(ctx context.Context, login string) error {
+	user, err := s.userRepo.FindByUsername(login)
+	if err != nil && strings.Contains(login, "@") {
+		user, err = s.userRepo.FindByEmail(normalizeEmail(login))
+	}
+	if err != nil {
+		return nil
+	}
+	// Reset links only go to verified addresses: a mistyped address could belong to someone else.
+	if user.Email == nil || !user.EmailVerified {
+		log.Debug().Uint("user_id", user.ID).Msg("Password reset requested for an account without verified email address")
+		return nil
+	}
+	to := *user.Email
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	stored := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(PasswordResetTTL),
+	}
+	if err := s.resetRepo.Create(stored); err != nil {
+		return err
+	}
+
+	link := strings.ReplaceAll(s.resetURL, "{token}", token)
+	return s.mail.Send(ctx, mailer.Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account %s.\n\n"+
+			"Open this link within %d minutes to choose a new password:\n\n%s\n\n"+
+			"If it wasn't you, ignore this mail. Your password stays unchanged.\n",
+			user.Username, int(PasswordResetTTL.Minutes()), link),
+	})
+}
+
+// ResetPassword sets a new password with a token sent by ForgotPassword. The token and every
+// other pending token of the user become unusable, and the user is signed out everywhere.
+func (s *PasswordService) ResetPassword(token, password string) error {
+	stored, err := s.resetRepo.FindByHash(hashToken(token))
+	if err != nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+	user, err := s.userRepo.FindByID(stored.UserID)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+	// A rejected password leaves the token usable for another try.
+	hashed, err := s.auth.newPasswordHash(user.Username, password)
+	if err != nil {
+		return err
+	}
+	consumed, err := s.resetRepo.MarkUsed(stored)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.userRepo.UpdatePassword(user, hashed); err != nil {
+		return err
+	}
+	if err := s.resetRepo.InvalidateAllForUser(user.ID); err != nil {
+		return err
+	}
+	return s.auth.RevokeToken(user.ID)
+}
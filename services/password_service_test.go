@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"hackathon/mailer"
+	"hackathon/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MockPasswordResetRepository is a mock implementation of PasswordResetRepository for testing
+type MockPasswordResetRepository struct {
+	tokens []*models.PasswordResetToken
+}
+
+func (m *MockPasswordResetRepository) Create(token *models.PasswordResetToken) error {
+	token.ID = uint(len(m.tokens) + 1)
+	token.CreatedAt = time.Now()
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *MockPasswordResetRepository) FindByHash(hash string) (*models.PasswordResetToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockPasswordResetRepository) MarkUsed(token *models.PasswordResetToken) (bool, error) {
+	stored := m.tokens[token.ID-1]
+	if stored.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	stored.UsedAt = &now
+	return true, nil
+}
+
+func (m *MockPasswordResetRepository) InvalidateAllForUser(userID uint) error {
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.UsedAt == nil {
+			token.UsedAt = &now
+		}
+	}
+	return nil
+}
+
+// MockMailer records the messages it is asked to send
+type MockMailer struct {
+	sent []mailer.Message
+}
+
+func (m *MockMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func newTestPasswordService() (*PasswordService, *MockUserRepository, *MockPasswordResetRepository, *MockMailer) {
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	email, unverified := "dev@example.com", "new@example.com"
+	users := &MockUserRepository{
+		users: map[string]*models.User{
+			"dev":        {ID: 1, Username: "dev", Email: &email, EmailVerified: true, Password: string(hashed), PasswordResetRequired: true},
+			"nomail":     {ID: 2, Username: "nomail", Password: string(hashed)},
+			"unverified": {ID: 3, Username: "unverified", Email: &unverified, Password: string(hashed)},
+		},
+	}
+	resets := &MockPasswordResetRepository{}
+	mail := &MockMailer{}
+	service := NewPasswordService(users, resets, newTestAuthService(users), mail, "https://app.example.com/reset?token={token}")
+	return service, users, resets, mail
+}
+
+// resetTokenFrom extracts the token from the link in a reset mail.
+func resetTokenFrom(t *testing.T, msg mailer.Message) string {
+	_, rest, found := strings.Cut(msg.Body, "https://app.example.com/reset?token=")
+	assert.True(t, found, "the mail contains the reset link")
+	token, _, _ := strings.Cut(rest, "\n")
+	return token
+}
+
+func TestPasswordService_ForgotPassword(t *testing.T) {
+	service, _, resets, mail := newTestPasswordService()
+	ctx := context.Background()
+
+	assert.NoError(t, service.ForgotPassword(ctx, "unknown@example.com"))
+	assert.NoError(t, service.ForgotPassword(ctx, "nomail"))
+	assert.NoError(t, service.ForgotPassword(ctx, "unverified"))
+	assert.Empty(t, mail.sent)
+	assert.Empty(t, resets.tokens)
+
+	assert.NoError(t, service.ForgotPassword(ctx, "dev"))
+	assert.NoError(t, service.ForgotPassword(ctx, "DEV@example.com"))
+	assert.Len(t, mail.sent, 2)
+	assert.Equal(t, "dev@example.com", mail.sent[0].To)
+	token := resetTokenFrom(t, mail.sent[0])
+	assert.Len(t, resets.tokens, 2)
+	assert.Equal(t, hashToken(token), resets.tokens[0].TokenHash, "only the hash is stored")
+	assert.WithinDuration(t, time.Now().Add(PasswordResetTTL), resets.tokens[0].ExpiresAt, time.Minute)
+}
+
+func TestPasswordService_ResetPassword(t *testing.T) {
+	t.Run("successful reset", func(t *testing.T) {
+		service, users, resets, mail := newTestPasswordService()
+		assert.NoError(t, service.ForgotPassword(context.Background(), "dev@example.com"))
+		assert.NoError(t, service.ForgotPassword(context.Background(), "dev@example.com"))
+		token := resetTokenFrom(t, mail.sent[1])
+
+		assert.NoError(t, service.ResetPassword(token, "new-password"))
+		user := users.users["dev"]
+		ok, _, err := testHasher.Verify("new-password", user.Password)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.False(t, user.PasswordResetRequired)
+		assert.NotZero(t, users.revokeTokensBeforeTime, "existing tokens are revoked")
+		assert.NotNil(t, resets.tokens[0].UsedAt, "other pending tokens are invalidated")
+
+		assert.Equal(t, ErrInvalidResetToken, service.ResetPassword(token, "another-password"), "tokens are single-use")
+		assert.Equal(t, ErrInvalidResetToken, service.ResetPassword(resetTokenFrom(t, mail.sent[0]), "another-password"))
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		service, users, resets, mail := newTestPasswordService()
+		assert.NoError(t, service.ForgotPassword(context.Background(), "dev@example.com"))
+		resets.tokens[0].ExpiresAt = time.Now().Add(-time.Minute)
+
+		err := service.ResetPassword(resetTokenFrom(t, mail.sent[0]), "new-password")
+		assert.Equal(t, ErrInvalidResetToken, err)
+		ok, _, err := testHasher.Verify("password", users.users["dev"].Password)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		service, _, _, _ := newTestPasswordService()
+		assert.Equal(t, ErrInvalidResetToken, service.ResetPassword("unknown", "new-password"))
+	})
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"hackathon/models"
+	"hackathon/repositories"
+	"strings"
+)
+
+var ErrInvalidAvatar = errors.New("the avatar must be one of your files")
+
+// ProfileService lets users read and edit their own account.
+type ProfileService struct {
+	userRepo repositories.UserRepository
+	fileRepo repositories.FileRepository
+	roleRepo repositories.RoleRepository
+}
+
+func NewProfileService(userRepo repositories.UserRepository, fileRepo repositories.FileRepository, roleRepo repositories.RoleRepository) *ProfileService {
+	return &ProfileService{userRepo: userRepo, fileRepo: fileRepo, roleRepo: roleRepo}
+}
+
+// GetProfile returns the user with its roles.
+func (s *ProfileService) GetProfile(user *models.User) (*models.User, error) {
+	roles, err := s.roleRepo.RolesForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.Roles = roles
+	return user, nil
+}
+
+// UpdateProfile changes the fields that are set. An avatar file ID of 0 removes the avatar.
+func (s *ProfileService) UpdateProfile(user *models.User, displayName *string, avatarFileID *uint) (*models.User, error) {
+	name, avatar := user.DisplayName, user.AvatarFileID
+	if displayName != nil {
+		name = strings.TrimSpace(*displayName)
+	}
+	if avatarFileID != nil {
+		if *avatarFileID == 0 {
+			avatar = nil
+		} else {
+			if _, err := s.fileRepo.FindByIDAndOwner(*avatarFileID, user.ID); err != nil {
+				return nil, ErrInvalidAvatar
+			}
+			avatar = avatarFileID
+		}
+	}
+	if err := s.userRepo.UpdateProfile(user, name, avatar); err != nil {
+		return nil, err
+	}
+	return s.GetProfile(user)
+}
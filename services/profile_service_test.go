@@ -0,0 +1,59 @@
+package services
+
+import (
+	"hackathon/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileService_UpdateProfile(t *testing.T) {
+	newService := func() (*ProfileService, *models.User) {
+		user := &models.User{ID: 1, Username: "dev", DisplayName: "Dev"}
+		users := &MockUserRepository{users: map[string]*models.User{"dev": user}}
+		files := &MockFileRepository{files: map[uint]*models.FileMetadata{
+			10: {ID: 10, OwnerID: 1},
+			11: {ID: 11, OwnerID: 2},
+		}}
+		roles := newMockRoleRepository()
+		roles.userRoles[1] = []string{models.RoleUser}
+		return NewProfileService(users, files, roles), user
+	}
+	uintPtr := func(v uint) *uint { return &v }
+	stringPtr := func(v string) *string { return &v }
+
+	t.Run("sets the present fields", func(t *testing.T) {
+		service, user := newService()
+
+		profile, err := service.UpdateProfile(user, nil, uintPtr(10))
+		assert.NoError(t, err)
+		assert.Equal(t, "Dev", profile.DisplayName)
+		assert.Equal(t, uint(10), *profile.AvatarFileID)
+		assert.Len(t, profile.Roles, 1)
+
+		profile, err = service.UpdateProfile(user, stringPtr("  Developer "), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "Developer", profile.DisplayName)
+		assert.Equal(t, uint(10), *profile.AvatarFileID)
+	})
+
+	t.Run("0 removes the avatar", func(t *testing.T) {
+		service, user := newService()
+		user.AvatarFileID = uintPtr(10)
+
+		profile, err := service.UpdateProfile(user, nil, uintPtr(0))
+		assert.NoError(t, err)
+		assert.Nil(t, profile.AvatarFileID)
+	})
+
+	t.Run("avatar must be one of the user's files", func(t *testing.T) {
+		service, user := newService()
+
+		_, err := service.UpdateProfile(user, stringPtr("Other"), uintPtr(11))
+		assert.Equal(t, ErrInvalidAvatar, err)
+		_, err = service.UpdateProfile(user, nil, uintPtr(99))
+		assert.Equal(t, ErrInvalidAvatar, err)
+		assert.Nil(t, user.AvatarFileID)
+		assert.Equal(t, "Dev", user.DisplayName, "nothing is changed")
+	})
+}
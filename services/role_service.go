@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"hackathon/models"
+	"hackathon/repositories"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// RoleService manages which roles users have. Role changes are picked up by the access token
+// issued on the user's next login or refresh.
+type RoleService struct {
+	roleRepo repositories.RoleRepository
+	userRepo repositories.UserRepository
+}
+
+func NewRoleService(roleRepo repositories.RoleRepository, userRepo repositories.UserRepository) *RoleService {
+	return &RoleService{roleRepo: roleRepo, userRepo: userRepo}
+}
+
+// ListRoles returns every role with its permissions.
+func (s *RoleService) ListRoles() ([]models.Role, error) {
+	return s.roleRepo.List()
+}
+
+func (s *RoleService) UserRoles(userID uint) ([]models.Role, error) {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return nil, ErrUserNotFound
+	}
+	return s.roleRepo.RolesForUser(userID)
+}
+
+// SetUserRoles replaces the roles of the user with the named roles.
+func (s *RoleService) SetUserRoles(userID uint, names []string) ([]models.Role, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return s.setRoles(user, names)
+}
+
+// GrantRole adds a role to the user.
+func (s *RoleService) GrantRole(username, role string) ([]models.Role, error) {
+	user, names, err := s.roleNames(username)
+	if err != nil {
+		return nil, err
+	}
+	return s.setRoles(user, append(names, role))
+}
+
+// RevokeRole removes a role from the user.
+func (s *RoleService) RevokeRole(username, role string) ([]models.Role, error) {
+	user, names, err := s.roleNames(username)
+	if err != nil {
+		return nil, err
+	}
+	kept := names[:0]
+	for _, name := range names {
+		if name != role {
+			kept = append(kept, name)
+		}
+	}
+	return s.setRoles(user, kept)
+}
+
+func (s *RoleService) roleNames(username string) (*models.User, []string, error) {
+	user, err := s.userRepo.FindByUsername(username)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	roles, err := s.roleRepo.RolesForUser(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, len(roles))
+	for i := range roles {
+		names[i] = roles[i].Name
+	}
+	return user, names, nil
+}
+
+func (s *RoleService) setRoles(user *models.User, names []string) ([]models.Role, error) {
+	unique := make(map[string]bool, len(names))
+	for _, name := range names {
+		unique[name] = true
+	}
+	roles := []models.Role{}
+	if len(unique) > 0 {
+		var err error
+		if roles, err = s.roleRepo.FindByNames(names); err != nil {
+			return nil, err
+		}
+		if len(roles) != len(unique) {
+			return nil, ErrRoleNotFound
+		}
+	}
+	if err := s.roleRepo.SetUserRoles(user, roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"hackathon/models"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MockRoleRepository is a mock implementation of RoleRepository for testing. It knows the
+// built-in roles.
+type MockRoleRepository struct {
+	userRoles map[uint][]string
+}
+
+func newMockRoleRepository() *MockRoleRepository {
+	return &MockRoleRepository{userRoles: make(map[uint][]string)}
+}
+
+func builtinRole(name string) models.Role {
+	role := models.Role{Name: name}
+	for _, permission := range models.BuiltinRoles[name] {
+		role.Permissions = append(role.Permissions, models.Permission{Name: permission})
+	}
+	return role
+}
+
+func (m *MockRoleRepository) List() ([]models.Role, error) {
+	return []models.Role{builtinRole(models.RoleAdmin), builtinRole(models.RoleUser)}, nil
+}
+
+func (m *MockRoleRepository) FindByName(name string) (*models.Role, error) {
+	if _, ok := models.BuiltinRoles[name]; !ok {
+		return nil, errors.New("record not found")
+	}
+	role := builtinRole(name)
+	return &role, nil
+}
+
+func (m *MockRoleRepository) FindByNames(names []string) ([]models.Role, error) {
+	seen := make(map[string]bool)
+	var roles []models.Role
+	for _, name := range names {
+		if _, ok := models.BuiltinRoles[name]; ok && !seen[name] {
+			seen[name] = true
+			roles = append(roles, builtinRole(name))
+		}
+	}
+	return roles, nil
+}
+
+func (m *MockRoleRepository) RolesForUser(userID uint) ([]models.Role, error) {
+	var roles []models.Role
+	for _, name := range m.userRoles[userID] {
+		roles = append(roles, builtinRole(name))
+	}
+	return roles, nil
+}
+
+func (m *MockRoleRepository) PermissionsForUser(userID uint) ([]string, error) {
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, name := range m.userRoles[userID] {
+		for _, permission := range models.BuiltinRoles[name] {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+func (m *MockRoleRepository) SetUserRoles(user *models.User, roles []models.Role) error {
+	names := make([]string, len(roles))
+	for i := range roles {
+		names[i] = roles[i].Name
+	}
+	m.userRoles[user.ID] = names
+	return nil
+}
+
+func TestRoleService(t *testing.T) {
+	newService := func() (*RoleService, *MockRoleRepository) {
+		users := &MockUserRepository{
+			users: map[string]*models.User{
+				"testuser": {ID: 1, Username: "testuser"},
+			},
+		}
+		roles := newMockRoleRepository()
+		roles.userRoles[1] = []string{models.RoleUser}
+		return NewRoleService(roles, users), roles
+	}
+
+	t.Run("grant and revoke", func(t *testing.T) {
+		service, roles := newService()
+
+		granted, err := service.GrantRole("testuser", models.RoleAdmin)
+		assert.NoError(t, err)
+		assert.Len(t, granted, 2)
+		permissions, _ := roles.PermissionsForUser(1)
+		assert.Contains(t, permissions, models.PermissionUsersAdmin)
+
+		granted, err = service.GrantRole("testuser", models.RoleAdmin)
+		assert.NoError(t, err)
+		assert.Len(t, granted, 2, "granting a role twice is a no-op")
+
+		granted, err = service.RevokeRole("testuser", models.RoleAdmin)
+		assert.NoError(t, err)
+		assert.Len(t, granted, 1)
+		permissions, _ = roles.PermissionsForUser(1)
+		assert.NotContains(t, permissions, models.PermissionUsersAdmin)
+	})
+
+	t.Run("set roles", func(t *testing.T) {
+		service, roles := newService()
+
+		_, err := service.SetUserRoles(1, []string{})
+		assert.NoError(t, err)
+		assert.Empty(t, roles.userRoles[1])
+
+		_, err = service.SetUserRoles(1, []string{models.RoleUser, "superuser"})
+		assert.Equal(t, ErrRoleNotFound, err)
+		assert.Empty(t, roles.userRoles[1], "roles are unchanged when a role does not exist")
+
+		_, err = service.SetUserRoles(2, []string{models.RoleUser})
+		assert.Equal(t, ErrUserNotFound, err)
+	})
+}
@@ -1,15 +1,66 @@
 package services
 
-import "hackathon/repositories"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"hackathon/mailer"
+	"hackathon/pkg/keyring"
+	"hackathon/pkg/oidc"
+	"hackathon/repositories"
+	"hackathon/storage"
+	"time"
+)
 
 type Service struct {
-	Auth *AuthService
-	File *FileService
+	Auth     *AuthService
+	File     *FileService
+	Upload   *UploadService
+	Keys     *KeyService
+	Role     *RoleService
+	User     *UserService
+	Token    *TokenService
+	Password *PasswordService
+	Email    *EmailService
+	MFA      *MFAService
+	Profile  *ProfileService
+	Export   *ExportService
+	Account  *AccountService
+	OIDC     *OIDCService
+	Throttle *LoginThrottle
+	Mail     *MailThrottle
 }
 
-func NewService(repos *repositories.Repository, jwtSecret []byte, expHours int, uploadDir string, maxSizeMB int64, allowedTypes []string) *Service {
+func NewService(repos *repositories.Repository, keys *keyring.Keyring, keysDir string, authOpts AuthOptions, store storage.Storage, maxSizeMB int64, allowedTypes []string, stagingDir string, uploadTTL time.Duration, exportSyncMaxMB int64, exportTTL time.Duration, oidcProvider *oidc.Provider, oidcOpts OIDCOptions, mail mailer.Mailer, resetURL, verifyURL string) *Service {
+	file := NewFileService(repos.File, store, maxSizeMB, allowedTypes)
+	throttle := NewLoginThrottle(repos.LoginAttempt, authOpts.UsernameThrottle, authOpts.IPThrottle)
+	auth := NewAuthService(repos.User, repos.Session, repos.RefreshToken, repos.AccessToken, repos.Role, repos.MFA, throttle, keys, authOpts)
+	upload := NewUploadService(repos.Upload, file, stagingDir, maxSizeMB, uploadTTL)
+	export := NewExportService(repos.User, repos.Role, repos.File, repos.ExportJob, store, exportSyncMaxMB, exportTTL)
 	return &Service{
-		Auth: NewAuthService(repos.User, jwtSecret, expHours),
-		File: NewFileService(repos.File, uploadDir, maxSizeMB, allowedTypes),
+		Auth:     auth,
+		File:     file,
+		Upload:   upload,
+		Keys:     NewKeyService(repos.SigningKey, keys, keysDir, authOpts.AccessTTL),
+		Role:     NewRoleService(repos.Role, repos.User),
+		User:     NewUserService(repos.User, repos.Role, auth),
+		Token:    NewTokenService(repos.AccessToken, repos.User, repos.Role),
+		Password: NewPasswordService(repos.User, repos.PasswordReset, auth, mail, resetURL),
+		Email:    NewEmailService(repos.User, keys, mail, verifyURL),
+		MFA:      NewMFAService(repos.User, repos.MFA, throttle, authOpts.MFAIssuer),
+		Profile:  NewProfileService(repos.User, repos.File, repos.Role),
+		Export:   export,
+		Account:  NewAccountService(repos.User, repos.MFA, auth, file, upload, export),
+		OIDC:     NewOIDCService(repos.Identity, repos.User, repos.Role, auth, oidcProvider, oidcOpts),
+		Throttle: throttle,
+		Mail:     NewMailThrottle(repos.LoginAttempt, authOpts.MailThrottle, authOpts.MailIPThrottle),
 	}
 }
+
+// randomHex returns n random bytes encoded as hex.
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"hackathon/models"
+	"hackathon/repositories"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidAccessToken  = errors.New("invalid, expired or revoked access token")
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	ErrInvalidScope        = errors.New("scopes must be permissions you have")
+)
+
+// PersonalTokenPrefix starts every personal access token, which tells them apart from JWTs and
+// makes leaked tokens easy to find with secret scanners.
+const PersonalTokenPrefix = "pat_"
+
+// tokenTouchInterval throttles how often the last used time of a token is written.
+const tokenTouchInterval = time.Minute
+
+// TokenService manages personal access tokens.
+type TokenService struct {
+	tokenRepo repositories.AccessTokenRepository
+	userRepo  repositories.UserRepository
+	roleRepo  repositories.RoleRepository
+}
+
+func NewTokenService(tokenRepo repositories.AccessTokenRepository, userRepo repositories.UserRepository, roleRepo repositories.RoleRepository) *TokenService {
+	return &TokenService{tokenRepo: tokenRepo, userRepo: userRepo, roleRepo: roleRepo}
+}
+
+// Create issues a token limited to the given scopes, which must be permissions the user has.
+// A nil expiresAt creates a token that does not expire. The token is returned only here.
+func (s *TokenService) Create(userID uint, name string, scopes []string, expiresAt *time.Time) (string, *models.PersonalAccessToken, error) {
+	permissions, err := s.roleRepo.PermissionsForUser(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(permissions, scope) {
+			return "", nil, ErrInvalidScope
+		}
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := PersonalTokenPrefix + secret
+	stored := &models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(PersonalTokenPrefix)+6],
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.tokenRepo.Create(stored); err != nil {
+		return "", nil, err
+	}
+	return token, stored, nil
+}
+
+// List returns the tokens of the user that can still be used.
+func (s *TokenService) List(userID uint) ([]models.PersonalAccessToken, error) {
+	return s.tokenRepo.ListActiveByUser(userID)
+}
+
+func (s *TokenService) Revoke(userID, id uint) error {
+	token, err := s.tokenRepo.FindByIDAndUser(id, userID)
+	if err != nil {
+		return ErrAccessTokenNotFound
+	}
+	return s.tokenRepo.Revoke(token)
+}
+
+// Authenticate resolves a token to its user and the permissions it grants: its scopes, limited
+// to what the user is currently allowed to do. Revoking all tokens of a user revokes these too.
+func (s *TokenService) Authenticate(token string) (*models.User, []string, error) {
+	stored, err := s.tokenRepo.FindByHash(hashToken(token))
+	if err != nil || stored.RevokedAt != nil {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	now := time.Now()
+	if stored.ExpiresAt != nil && now.After(*stored.ExpiresAt) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.userRepo.FindByID(stored.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	if user.Disabled {
+		return nil, nil, ErrAccountDisabled
+	}
+	if user.RevokeTokensBefore > 0 && stored.CreatedAt.Unix() < user.RevokeTokensBefore {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	permissions, err := s.roleRepo.PermissionsForUser(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	granted := []string{}
+	for _, scope := range strings.Split(stored.Scopes, ",") {
+		if slices.Contains(permissions, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) > tokenTouchInterval {
+		_ = s.tokenRepo.Touch(stored, now) // Best effort, the token is valid either way
+	}
+	return user, granted, nil
+}
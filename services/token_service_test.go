@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"hackathon/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MockAccessTokenRepository is a mock implementation of AccessTokenRepository for testing
+type MockAccessTokenRepository struct {
+	tokens []*models.PersonalAccessToken
+}
+
+func (m *MockAccessTokenRepository) Create(token *models.PersonalAccessToken) error {
+	token.ID = uint(len(m.tokens) + 1)
+	token.CreatedAt = time.Now()
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *MockAccessTokenRepository) FindByHash(hash string) (*models.PersonalAccessToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockAccessTokenRepository) FindByIDAndUser(id, userID uint) (*models.PersonalAccessToken, error) {
+	for _, token := range m.tokens {
+		if token.ID == id && token.UserID == userID && token.RevokedAt == nil {
+			return token, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockAccessTokenRepository) ListActiveByUser(userID uint) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.RevokedAt == nil && (token.ExpiresAt == nil || token.ExpiresAt.After(time.Now())) {
+			tokens = append(tokens, *token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *MockAccessTokenRepository) Touch(token *models.PersonalAccessToken, usedAt time.Time) error {
+	token.LastUsedAt = &usedAt
+	return nil
+}
+
+func (m *MockAccessTokenRepository) Revoke(token *models.PersonalAccessToken) error {
+	now := time.Now()
+	token.RevokedAt = &now
+	return nil
+}
+
+func (m *MockAccessTokenRepository) RevokeAllForUser(userID uint) error {
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func newTestTokenService() (*TokenService, *MockUserRepository, *MockRoleRepository) {
+	users := &MockUserRepository{
+		users: map[string]*models.User{
+			"testuser": {ID: 1, Username: "testuser"},
+		},
+	}
+	roles := newMockRoleRepository()
+	roles.userRoles[1] = []string{models.RoleUser}
+	return NewTokenService(&MockAccessTokenRepository{}, users, roles), users, roles
+}
+
+func TestTokenService_Create(t *testing.T) {
+	t.Run("token is shown once and stored hashed", func(t *testing.T) {
+		service, _, _ := newTestTokenService()
+
+		token, stored, err := service.Create(1, "ci", []string{models.PermissionFilesWrite}, nil)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(token, PersonalTokenPrefix))
+		assert.True(t, strings.HasPrefix(token, stored.Prefix))
+		assert.NotContains(t, stored.TokenHash, token)
+		assert.Equal(t, hashToken(token), stored.TokenHash)
+
+		tokens, err := service.List(1)
+		assert.NoError(t, err)
+		assert.Len(t, tokens, 1)
+	})
+
+	t.Run("scopes must be permissions of the user", func(t *testing.T) {
+		service, _, _ := newTestTokenService()
+
+		_, _, err := service.Create(1, "ci", []string{models.PermissionUsersAdmin}, nil)
+		assert.Equal(t, ErrInvalidScope, err)
+	})
+}
+
+func TestTokenService_Authenticate(t *testing.T) {
+	t.Run("grants the scopes of the token", func(t *testing.T) {
+		service, _, _ := newTestTokenService()
+		token, stored, err := service.Create(1, "ci", []string{models.PermissionFilesWrite}, nil)
+		assert.NoError(t, err)
+
+		user, permissions, err := service.Authenticate(token)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(1), user.ID)
+		assert.Equal(t, []string{models.PermissionFilesWrite}, permissions)
+		assert.NotNil(t, stored.LastUsedAt)
+	})
+
+	t.Run("scopes are limited to the current permissions of the user", func(t *testing.T) {
+		service, _, roles := newTestTokenService()
+		token, _, err := service.Create(1, "ci", []string{models.PermissionFilesRead, models.PermissionFilesWrite}, nil)
+		assert.NoError(t, err)
+
+		roles.userRoles[1] = nil
+		_, permissions, err := service.Authenticate(token)
+		assert.NoError(t, err)
+		assert.Empty(t, permissions)
+	})
+
+	t.Run("revoked, expired and unknown tokens", func(t *testing.T) {
+		service, _, _ := newTestTokenService()
+		revoked, stored, err := service.Create(1, "revoked", []string{models.PermissionFilesRead}, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Revoke(1, stored.ID))
+		assert.Equal(t, ErrAccessTokenNotFound, service.Revoke(1, stored.ID))
+
+		past := time.Now().Add(-time.Minute)
+		expired, _, err := service.Create(1, "expired", []string{models.PermissionFilesRead}, &past)
+		assert.NoError(t, err)
+
+		for _, token := range []string{revoked, expired, PersonalTokenPrefix + "unknown"} {
+			_, _, err := service.Authenticate(token)
+			assert.Equal(t, ErrInvalidAccessToken, err)
+		}
+
+		tokens, err := service.List(1)
+		assert.NoError(t, err)
+		assert.Empty(t, tokens)
+	})
+
+	t.Run("revoking all tokens of the user and disabling the account", func(t *testing.T) {
+		service, users, _ := newTestTokenService()
+		token, _, err := service.Create(1, "ci", []string{models.PermissionFilesRead}, nil)
+		assert.NoError(t, err)
+
+		users.users["testuser"].Disabled = true
+		_, _, err = service.Authenticate(token)
+		assert.Equal(t, ErrAccountDisabled, err)
+
+		users.users["testuser"].Disabled = false
+		users.users["testuser"].RevokeTokensBefore = time.Now().Add(time.Second).Unix()
+		_, _, err = service.Authenticate(token)
+		assert.Equal(t, ErrInvalidAccessToken, err)
+	})
+
+	t.Run("signing out everywhere revokes tokens created in the same second", func(t *testing.T) {
+		service, users, _ := newTestTokenService()
+		auth := newTestAuthService(users)
+		auth.accessTokenRepo = service.tokenRepo
+		token, _, err := service.Create(1, "ci", []string{models.PermissionFilesRead}, nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, auth.RevokeToken(1))
+		_, _, err = service.Authenticate(token)
+		assert.Equal(t, ErrInvalidAccessToken, err)
+	})
+
+	t.Run("other users cannot revoke the token", func(t *testing.T) {
+		service, _, _ := newTestTokenService()
+		_, stored, err := service.Create(1, "ci", []string{models.PermissionFilesRead}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, ErrAccessTokenNotFound, service.Revoke(2, stored.ID))
+	})
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hackathon/models"
+	"hackathon/repositories"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrUploadNotFound      = errors.New("upload not found")
+	ErrOffsetMismatch      = errors.New("upload offset does not match")
+	ErrUploadTooLong       = errors.New("chunk exceeds the upload length")
+	ErrChecksumMismatch    = errors.New("checksum mismatch")
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	ErrUploadAlreadyDone   = errors.New("upload is already complete")
+	ErrSaveUploadChunk     = errors.New("failed to save upload chunk")
+)
+
+// SupportedChecksums lists the Upload-Checksum algorithms accepted by Append.
+var SupportedChecksums = []string{"sha1", "sha256", "md5"}
+
+// Checksum is the expected digest of a chunk, as sent in the tus Upload-Checksum header.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// UploadService implements resumable uploads following the tus protocol. Chunks are appended to
+// a staging file on local disk; once every byte has been received the staged file goes through
+// FileService.UploadFileStream like any other upload. Uploads that receive nothing for ttl expire.
+type UploadService struct {
+	uploadRepo repositories.UploadRepository
+	files      *FileService
+	stagingDir string
+	maxSize    int64
+	ttl        time.Duration
+
+	// locks serializes chunks of the same upload within this instance, striped by upload ID.
+	locks [64]sync.Mutex
+}
+
+func NewUploadService(repo repositories.UploadRepository, files *FileService, stagingDir string, maxSizeMB int64, ttl time.Duration) *UploadService {
+	return &UploadService{
+		uploadRepo: repo,
+		files:      files,
+		stagingDir: stagingDir,
+		maxSize:    maxSizeMB * 1024 * 1024,
+		ttl:        ttl,
+	}
+}
+
+// MaxSize is the largest Upload-Length accepted.
+func (s *UploadService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Create registers a new upload of length bytes and prepares its staging file. An empty upload
+// has nothing left to receive, so it is stored right away and returned with its FileID set.
+func (s *UploadService) Create(ctx context.Context, ownerID uint, length int64, filename, metadata string) (*models.Upload, error) {
+	if length > s.maxSize {
+		return nil, ErrFileTooLarge
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.stagingDir, 0o755); err != nil {
+		return nil, ErrSaveUploadChunk
+	}
+	f, err := os.Create(s.stagingPath(id))
+	if err != nil {
+		return nil, ErrSaveUploadChunk
+	}
+	f.Close()
+
+	upload := &models.Upload{ID: id, OwnerID: ownerID, Filename: filename, Metadata: metadata, Length: length, ExpiresAt: time.Now().Add(s.ttl)}
+	if err := s.uploadRepo.Create(upload); err != nil {
+		os.Remove(s.stagingPath(id))
+		return nil, ErrSaveDB
+	}
+	if length == 0 {
+		return s.finish(ctx, upload)
+	}
+	return upload, nil
+}
+
+func (s *UploadService) Get(ownerID uint, id string) (*models.Upload, error) {
+	upload, err := s.uploadRepo.FindByIDAndOwner(id, ownerID)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// Append writes a chunk starting at offset. When the chunk completes the upload, the file is
+// validated and stored, and the returned upload has its FileID set. If checksum is given and
+// does not match, the chunk is discarded.
+func (s *UploadService) Append(ctx context.Context, ownerID uint, id string, offset int64, chunk io.Reader, checksum *Checksum) (*models.Upload, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.Get(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.FileID != nil {
+		return nil, ErrUploadAlreadyDone
+	}
+	if offset != upload.Offset {
+		return nil, ErrOffsetMismatch
+	}
+
+	var sum hash.Hash
+	if checksum != nil {
+		if sum = newChecksumHash(checksum.Algorithm); sum == nil {
+			return nil, ErrUnsupportedChecksum
+		}
+	}
+
+	f, err := os.OpenFile(s.stagingPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, ErrSaveUploadChunk
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, ErrSaveUploadChunk
+	}
+
+	var w io.Writer = f
+	if sum != nil {
+		w = io.MultiWriter(f, sum)
+	}
+	remaining := upload.Length - offset
+	written, copyErr := io.Copy(w, io.LimitReader(chunk, remaining+1))
+
+	switch {
+	case written > remaining:
+		f.Truncate(offset)
+		return nil, ErrUploadTooLong
+	case sum != nil && (copyErr != nil || !bytes.Equal(sum.Sum(nil), checksum.Sum)):
+		f.Truncate(offset)
+		if copyErr != nil {
+			return nil, ErrSaveUploadChunk
+		}
+		return nil, ErrChecksumMismatch
+	}
+
+	// Without a checksum, bytes received before an interrupted request are kept so the client
+	// can resume from there.
+	if written > 0 {
+		if err := s.uploadRepo.UpdateOffset(upload, offset+written, time.Now().Add(s.ttl)); err != nil {
+			f.Truncate(offset)
+			if errors.Is(err, repositories.ErrStaleOffset) {
+				return nil, ErrOffsetMismatch
+			}
+			return nil, ErrSaveDB
+		}
+	}
+	if copyErr != nil {
+		return upload, ErrSaveUploadChunk
+	}
+
+	if upload.Offset == upload.Length {
+		return s.finish(ctx, upload)
+	}
+	return upload, nil
+}
+
+// Terminate cancels an upload and removes its staged data.
+func (s *UploadService) Terminate(ownerID uint, id string) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.Get(ownerID, id)
+	if err != nil {
+		return err
+	}
+	return s.remove(upload)
+}
+
+// TerminateAll cancels every upload of the owner.
+func (s *UploadService) TerminateAll(ownerID uint) error {
+	uploads, err := s.uploadRepo.ListByOwner(ownerID)
+	if err != nil {
+		return err
+	}
+	for _, upload := range uploads {
+		if err := s.Terminate(ownerID, upload.ID); err != nil && !errors.Is(err, ErrUploadNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneExpired deletes expired uploads with their staged data. It returns how many were deleted.
+func (s *UploadService) PruneExpired(ctx context.Context) (int, error) {
+	now := time.Now()
+	pruned := 0
+	for {
+		uploads, err := s.uploadRepo.ListExpired(now, purgeBatchSize)
+		if err != nil {
+			return pruned, err
+		}
+		for i := range uploads {
+			if err := ctx.Err(); err != nil {
+				return pruned, err
+			}
+			unlock := s.lock(uploads[i].ID)
+			err := s.remove(&uploads[i])
+			unlock()
+			if err != nil {
+				return pruned, err
+			}
+			pruned++
+		}
+		if len(uploads) < purgeBatchSize {
+			return pruned, nil
+		}
+	}
+}
+
+// RunPruner prunes expired uploads every interval until ctx is cancelled.
+func (s *UploadService) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pruned, err := s.PruneExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to prune expired uploads")
+		} else if pruned > 0 {
+			log.Info().Int("count", pruned).Msg("Pruned expired uploads")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// remove deletes an upload and its staged data. The caller holds the lock of the upload.
+func (s *UploadService) remove(upload *models.Upload) error {
+	if err := s.uploadRepo.Delete(upload); err != nil {
+		return ErrSaveDB
+	}
+	os.Remove(s.stagingPath(upload.ID))
+	return nil
+}
+
+// finish hands the staged file to FileService. An upload whose content is rejected is removed.
+func (s *UploadService) finish(ctx context.Context, upload *models.Upload) (*models.Upload, error) {
+	f, err := os.Open(s.stagingPath(upload.ID))
+	if err != nil {
+		return nil, ErrSaveUploadChunk
+	}
+	defer f.Close()
+
+	metadata, err := s.files.UploadFileStream(ctx, f, upload.Filename, upload.Length, upload.OwnerID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidType) || errors.Is(err, ErrFileTooLarge) {
+			s.uploadRepo.Delete(upload)
+			os.Remove(s.stagingPath(upload.ID))
+		}
+		return nil, err
+	}
+	if err := s.uploadRepo.SetFileID(upload, metadata.ID); err != nil {
+		// The upload stays incomplete and the client retries it, which stores the file again.
+		if err := s.files.purge(ctx, metadata); err != nil {
+			log.Error().Err(err).Uint("file_id", metadata.ID).Msg("Failed to remove the file of an unfinished upload")
+		}
+		return nil, ErrSaveDB
+	}
+	os.Remove(s.stagingPath(upload.ID))
+	return upload, nil
+}
+
+func (s *UploadService) stagingPath(id string) string {
+	return filepath.Join(s.stagingDir, id)
+}
+
+func (s *UploadService) lock(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	mu := &s.locks[h.Sum32()%uint32(len(s.locks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "md5":
+		return md5.New()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"hackathon/models"
+	"hackathon/repositories"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MockUploadRepository is a mock implementation of UploadRepository for testing
+type MockUploadRepository struct {
+	uploads    map[string]*models.Upload
+	setFileErr error
+}
+
+func (m *MockUploadRepository) Create(upload *models.Upload) error {
+	copied := *upload
+	m.uploads[upload.ID] = &copied
+	return nil
+}
+
+func (m *MockUploadRepository) FindByIDAndOwner(id string, ownerID uint) (*models.Upload, error) {
+	if upload, exists := m.uploads[id]; exists && upload.OwnerID == ownerID {
+		copied := *upload
+		return &copied, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (m *MockUploadRepository) ListByOwner(ownerID uint) ([]models.Upload, error) {
+	var uploads []models.Upload
+	for _, upload := range m.uploads {
+		if upload.OwnerID == ownerID {
+			uploads = append(uploads, *upload)
+		}
+	}
+	return uploads, nil
+}
+
+func (m *MockUploadRepository) UpdateOffset(upload *models.Upload, newOffset int64, expiresAt time.Time) error {
+	stored := m.uploads[upload.ID]
+	if stored.Offset != upload.Offset {
+		return repositories.ErrStaleOffset
+	}
+	stored.Offset = newOffset
+	stored.ExpiresAt = expiresAt
+	upload.Offset = newOffset
+	upload.ExpiresAt = expiresAt
+	return nil
+}
+
+func (m *MockUploadRepository) SetFileID(upload *models.Upload, fileID uint) error {
+	if m.setFileErr != nil {
+		return m.setFileErr
+	}
+	m.uploads[upload.ID].FileID = &fileID
+	upload.FileID = &fileID
+	return nil
+}
+
+func (m *MockUploadRepository) ListExpired(now time.Time, limit int) ([]models.Upload, error) {
+	var uploads []models.Upload
+	for _, upload := range m.uploads {
+		if upload.ExpiresAt.Before(now) && len(uploads) < limit {
+			uploads = append(uploads, *upload)
+		}
+	}
+	return uploads, nil
+}
+
+func (m *MockUploadRepository) Delete(upload *models.Upload) error {
+	delete(m.uploads, upload.ID)
+	return nil
+}
+
+func newTestUploadService(t *testing.T) (*UploadService, *MockFileRepository) {
+	storageDir, err := ioutil.TempDir("", "tus-storage-test")
+	assert.NoError(t, err)
+	stagingDir, err := ioutil.TempDir("", "tus-staging-test")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(storageDir)
+		os.RemoveAll(stagingDir)
+	})
+
+	fileRepo := &MockFileRepository{}
+	files := newTestFileService(fileRepo, storageDir, 1, []string{"text/plain; charset=utf-8"})
+	repo := &MockUploadRepository{uploads: make(map[string]*models.Upload)}
+	return NewUploadService(repo, files, stagingDir, 1, time.Hour), fileRepo
+}
+
+func TestUploadService_ResumableUpload(t *testing.T) {
+	ctx := context.Background()
+	service, fileRepo := newTestUploadService(t)
+	content := "hello resumable world"
+
+	upload, err := service.Create(ctx, 1, int64(len(content)), "hello.txt", "")
+	assert.NoError(t, err)
+
+	upload, err = service.Append(ctx, 1, upload.ID, 0, strings.NewReader(content[:5]), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), upload.Offset)
+	assert.Nil(t, upload.FileID)
+
+	t.Run("wrong offset is rejected", func(t *testing.T) {
+		_, err := service.Append(ctx, 1, upload.ID, 0, strings.NewReader(content), nil)
+		assert.Equal(t, ErrOffsetMismatch, err)
+	})
+
+	t.Run("other users cannot see the upload", func(t *testing.T) {
+		_, err := service.Get(2, upload.ID)
+		assert.Equal(t, ErrUploadNotFound, err)
+	})
+
+	upload, err = service.Append(ctx, 1, upload.ID, 5, strings.NewReader(content[5:]), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), upload.Offset)
+	assert.NotNil(t, upload.FileID)
+
+	metadata := fileRepo.files[*upload.FileID]
+	assert.Equal(t, "hello.txt", metadata.Filename)
+	assert.Equal(t, int64(len(content)), metadata.Size)
+	assert.Equal(t, uint(1), metadata.OwnerID)
+
+	_, err = os.Stat(service.stagingPath(upload.ID))
+	assert.True(t, os.IsNotExist(err), "staging file is removed once the upload is stored")
+
+	_, err = service.Append(ctx, 1, upload.ID, upload.Offset, strings.NewReader("more"), nil)
+	assert.Equal(t, ErrUploadAlreadyDone, err)
+}
+
+func TestUploadService_Checksum(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestUploadService(t)
+	content := "checksummed content"
+
+	upload, err := service.Create(ctx, 1, int64(len(content)), "sum.txt", "")
+	assert.NoError(t, err)
+
+	wrong := sha1.Sum([]byte("something else"))
+	_, err = service.Append(ctx, 1, upload.ID, 0, strings.NewReader(content), &Checksum{Algorithm: "sha1", Sum: wrong[:]})
+	assert.Equal(t, ErrChecksumMismatch, err)
+
+	upload, err = service.Get(1, upload.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), upload.Offset, "a chunk with a bad checksum is discarded")
+
+	_, err = service.Append(ctx, 1, upload.ID, 0, strings.NewReader(content), &Checksum{Algorithm: "crc32", Sum: wrong[:]})
+	assert.Equal(t, ErrUnsupportedChecksum, err)
+
+	right := sha1.Sum([]byte(content))
+	upload, err = service.Append(ctx, 1, upload.ID, 0, strings.NewReader(content), &Checksum{Algorithm: "sha1", Sum: right[:]})
+	assert.NoError(t, err)
+	assert.NotNil(t, upload.FileID)
+}
+
+func TestUploadService_Limits(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestUploadService(t)
+
+	_, err := service.Create(ctx, 1, 2*1024*1024, "huge.txt", "")
+	assert.Equal(t, ErrFileTooLarge, err)
+
+	upload, err := service.Create(ctx, 1, 4, "short.txt", "")
+	assert.NoError(t, err)
+	_, err = service.Append(ctx, 1, upload.ID, 0, strings.NewReader("too long"), nil)
+	assert.Equal(t, ErrUploadTooLong, err)
+}
+
+func TestUploadService_EmptyUpload(t *testing.T) {
+	ctx := context.Background()
+	service, fileRepo := newTestUploadService(t)
+
+	upload, err := service.Create(ctx, 1, 0, "empty.txt", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, upload.FileID, "an empty upload is complete as soon as it is created")
+	assert.Equal(t, int64(0), fileRepo.files[*upload.FileID].Size)
+
+	_, err = os.Stat(service.stagingPath(upload.ID))
+	assert.True(t, os.IsNotExist(err))
+	_, err = service.Append(ctx, 1, upload.ID, 0, strings.NewReader(""), nil)
+	assert.Equal(t, ErrUploadAlreadyDone, err)
+}
+
+func TestUploadService_FinishRetry(t *testing.T) {
+	ctx := context.Background()
+	service, fileRepo := newTestUploadService(t)
+	repo := service.uploadRepo.(*MockUploadRepository)
+	content := "finished twice"
+
+	upload, err := service.Create(ctx, 1, int64(len(content)), "retry.txt", "")
+	assert.NoError(t, err)
+	repo.setFileErr = errors.New("connection reset")
+	_, err = service.Append(ctx, 1, upload.ID, 0, strings.NewReader(content), nil)
+	assert.Equal(t, ErrSaveDB, err)
+	assert.Empty(t, fileRepo.files, "the file is removed while the upload is unfinished")
+
+	repo.setFileErr = nil
+	upload, err = service.Append(ctx, 1, upload.ID, int64(len(content)), strings.NewReader(""), nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, upload.FileID)
+	assert.Len(t, fileRepo.files, 1)
+	assert.Equal(t, int64(1), fileRepo.blobs[fileRepo.files[*upload.FileID].Digest])
+}
+
+func TestUploadService_Terminate(t *testing.T) {
+	service, _ := newTestUploadService(t)
+
+	upload, err := service.Create(context.Background(), 1, 10, "cancel.txt", "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, ErrUploadNotFound, service.Terminate(2, upload.ID))
+	assert.NoError(t, service.Terminate(1, upload.ID))
+
+	_, err = service.Get(1, upload.ID)
+	assert.Equal(t, ErrUploadNotFound, err)
+	_, err = os.Stat(service.stagingPath(upload.ID))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUploadService_PruneExpired(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestUploadService(t)
+	repo := service.uploadRepo.(*MockUploadRepository)
+
+	stale, err := service.Create(ctx, 1, 10, "stale.txt", "")
+	assert.NoError(t, err)
+	active, err := service.Create(ctx, 1, 10, "active.txt", "")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), stale.ExpiresAt, time.Minute)
+	repo.uploads[stale.ID].ExpiresAt = time.Now().Add(-time.Minute)
+	repo.uploads[active.ID].ExpiresAt = time.Now().Add(-time.Minute)
+
+	// Receiving a chunk moves the expiry forward.
+	active, err = service.Append(ctx, 1, active.ID, 0, strings.NewReader("hello"), nil)
+	assert.NoError(t, err)
+	assert.True(t, active.ExpiresAt.After(time.Now()))
+
+	pruned, err := service.PruneExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	_, err = service.Get(1, stale.ID)
+	assert.Equal(t, ErrUploadNotFound, err)
+	_, err = os.Stat(service.stagingPath(stale.ID))
+	assert.True(t, os.IsNotExist(err))
+	_, err = service.Get(1, active.ID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"hackathon/dto"
+	"hackathon/models"
+	"hackathon/pkg/pagination"
+	"hackathon/repositories"
+)
+
+var (
+	ErrDisableSelf    = errors.New("you cannot disable your own account")
+	ErrAccountDeleted = errors.New("the account has been deleted")
+)
+
+// UserService is the account management used by administrators.
+type UserService struct {
+	userRepo repositories.UserRepository
+	roleRepo repositories.RoleRepository
+	auth     *AuthService
+}
+
+func NewUserService(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, auth *AuthService) *UserService {
+	return &UserService{userRepo: userRepo, roleRepo: roleRepo, auth: auth}
+}
+
+// ListUsers returns one page of users ordered by ID, optionally filtered by username.
+func (s *UserService) ListUsers(req dto.ListUsersRequest) (dto.Page[models.User], error) {
+	var page dto.Page[models.User]
+	limit := pagination.Limit(req.Limit)
+
+	query := repositories.UserListQuery{UsernameContains: req.Q, Limit: limit + 1}
+	if req.Cursor != "" {
+		cursor, err := pagination.Decode(req.Cursor)
+		if err != nil || cursor.Sort != "id" {
+			return page, pagination.ErrInvalidCursor
+		}
+		query.AfterID = cursor.ID
+	}
+
+	users, err := s.userRepo.List(query)
+	if err != nil {
+		return page, err
+	}
+	total, err := s.userRepo.Count(query)
+	if err != nil {
+		return page, err
+	}
+
+	if len(users) > limit {
+		users = users[:limit]
+		page.NextCursor = pagination.Encode(pagination.Cursor{Sort: "id", Order: "asc", ID: users[limit-1].ID})
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	page.Items = users
+	page.TotalEstimate = total
+	return page, nil
+}
+
+// GetUser returns the user with its roles.
+func (s *UserService) GetUser(id uint) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.Roles, err = s.roleRepo.RolesForUser(id); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DisableUser blocks the account and signs it out everywhere. Admins cannot disable themselves.
+func (s *UserService) DisableUser(id, adminID uint) (*models.User, error) {
+	if id == adminID {
+		return nil, ErrDisableSelf
+	}
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if err := s.userRepo.UpdateDisabled(user, true); err != nil {
+		return nil, err
+	}
+	return user, s.auth.RevokeToken(id)
+}
+
+// EnableUser unblocks the account. Deleted accounts stay disabled for good.
+func (s *UserService) EnableUser(id uint) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.AnonymizedAt != nil {
+		return nil, ErrAccountDeleted
+	}
+	if err := s.userRepo.UpdateDisabled(user, false); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ForcePasswordReset signs the user out everywhere and blocks login until the password is reset.
+func (s *UserService) ForcePasswordReset(id uint) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.AnonymizedAt != nil {
+		return nil, ErrAccountDeleted
+	}
+	if err := s.userRepo.UpdatePasswordResetRequired(user, true); err != nil {
+		return nil, err
+	}
+	return user, s.auth.RevokeToken(id)
+}
+
+// RevokeTokens signs the user out everywhere.
+func (s *UserService) RevokeTokens(id uint) error {
+	if _, err := s.userRepo.FindByID(id); err != nil {
+		return ErrUserNotFound
+	}
+	return s.auth.RevokeToken(id)
+}
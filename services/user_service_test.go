@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"hackathon/dto"
+	"hackathon/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestUserService(repo *MockUserRepository) (*UserService, *AuthService) {
+	auth := newTestAuthService(repo)
+	return NewUserService(repo, auth.roleRepo, auth), auth
+}
+
+func TestUserService_ListUsers(t *testing.T) {
+	repo := &MockUserRepository{users: make(map[string]*models.User)}
+	for i := 1; i <= 5; i++ {
+		name := fmt.Sprintf("user%d", i)
+		repo.users[name] = &models.User{ID: uint(i), Username: name}
+	}
+	repo.users["admin"] = &models.User{ID: 6, Username: "admin"}
+	service, _ := newTestUserService(repo)
+
+	page, err := service.ListUsers(dto.ListUsersRequest{Q: "USER", Limit: 3})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 3)
+	assert.Equal(t, int64(5), page.TotalEstimate)
+	assert.NotEmpty(t, page.NextCursor)
+
+	page, err = service.ListUsers(dto.ListUsersRequest{Q: "user", Limit: 3, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, "user4", page.Items[0].Username)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = service.ListUsers(dto.ListUsersRequest{Cursor: "garbage"})
+	assert.Error(t, err)
+}
+
+func TestUserService_AccountStatus(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	newService := func() (*UserService, *AuthService, *MockUserRepository) {
+		repo := &MockUserRepository{
+			users: map[string]*models.User{
+				"admin":    {ID: 1, Username: "admin", Password: string(hashedPassword)},
+				"testuser": {ID: 2, Username: "testuser", Password: string(hashedPassword)},
+			},
+		}
+		users, auth := newTestUserService(repo)
+		return users, auth, repo
+	}
+
+	t.Run("disabled users cannot login or refresh", func(t *testing.T) {
+		users, auth, repo := newService()
+		login, err := auth.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+
+		user, err := users.DisableUser(2, 1)
+		assert.NoError(t, err)
+		assert.True(t, user.Disabled)
+		assert.True(t, repo.revokeTokensBeforeTime > 0, "existing tokens are revoked")
+
+		_, err = auth.Login("testuser", "password", ClientInfo{})
+		assert.Equal(t, ErrAccountDisabled, err)
+		_, err = auth.Refresh(login.RefreshToken)
+		assert.Error(t, err)
+
+		_, err = users.EnableUser(2)
+		assert.NoError(t, err)
+		_, err = auth.Login("testuser", "password", ClientInfo{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("admins cannot disable themselves", func(t *testing.T) {
+		users, _, _ := newService()
+		_, err := users.DisableUser(1, 1)
+		assert.Equal(t, ErrDisableSelf, err)
+		_, err = users.DisableUser(3, 1)
+		assert.Equal(t, ErrUserNotFound, err)
+	})
+
+	t.Run("forced password reset blocks login", func(t *testing.T) {
+		users, auth, _ := newService()
+		user, err := users.ForcePasswordReset(2)
+		assert.NoError(t, err)
+		assert.True(t, user.PasswordResetRequired)
+
+		_, err = auth.Login("testuser", "password", ClientInfo{})
+		assert.Equal(t, ErrPasswordResetNeeded, err)
+	})
+
+	t.Run("deleted accounts stay disabled", func(t *testing.T) {
+		users, _, repo := newService()
+		assert.NoError(t, repo.Anonymize(repo.users["testuser"], "deleted-1", "unusable"))
+
+		_, err := users.EnableUser(2)
+		assert.Equal(t, ErrAccountDeleted, err)
+		_, err = users.ForcePasswordReset(2)
+		assert.Equal(t, ErrAccountDeleted, err)
+		assert.True(t, repo.users["deleted-1"].Disabled)
+		assert.False(t, repo.users["deleted-1"].PasswordResetRequired)
+	})
+
+	t.Run("view user with roles", func(t *testing.T) {
+		users, auth, _ := newService()
+		auth.roleRepo.(*MockRoleRepository).userRoles[1] = []string{models.RoleAdmin}
+
+		user, err := users.GetUser(1)
+		assert.NoError(t, err)
+		assert.Len(t, user.Roles, 1)
+		assert.Equal(t, models.RoleAdmin, user.Roles[0].Name)
+
+		_, err = users.GetUser(9)
+		assert.Equal(t, ErrUserNotFound, err)
+	})
+}
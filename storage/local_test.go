@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal_RoundTrip(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "local-storage-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewLocal(tmpDir)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	content := "hello storage"
+	assert.NoError(t, store.Put(ctx, "a/b/object.txt", strings.NewReader(content), int64(len(content)), "text/plain"))
+	assert.NoError(t, store.Put(ctx, "a/other.txt", strings.NewReader("x"), 1, "text/plain"))
+
+	info, err := store.Stat(ctx, "a/b/object.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size)
+
+	reader, err := store.Get(ctx, "a/b/object.txt")
+	assert.NoError(t, err)
+	_, err = reader.Seek(6, io.SeekStart)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "storage", string(data))
+	reader.Close()
+
+	objects, err := store.List(ctx, "a/b/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+	assert.Equal(t, "a/b/object.txt", objects[0].Key)
+
+	objects, err = store.List(ctx, "a/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 2)
+
+	assert.NoError(t, store.Delete(ctx, "a/b/object.txt"))
+	assert.NoError(t, store.Delete(ctx, "a/b/object.txt"), "deleting a missing object is not an error")
+
+	_, err = store.Get(ctx, "a/b/object.txt")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.Stat(ctx, "a/b/object.txt")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestLocal_RejectsUnsafeKeys(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "local-storage-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewLocal(tmpDir)
+	assert.NoError(t, err)
+
+	for _, key := range []string{"", "../escape", "a/../../escape", "/etc/passwd", "a\\b", "nul\x00byte", "a//b", "./a"} {
+		err := store.Put(context.Background(), key, strings.NewReader("x"), 1, "")
+		assert.Equal(t, ErrInvalidKey, err, "key %q", key)
+	}
+}
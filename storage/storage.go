@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hackathon/config"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage is a flat key/value blob store. Keys use "/" as separator regardless of the driver.
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens an object for reading. The returned reader supports seeking so
+	// callers can serve byte ranges; it must be closed by the caller.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// New creates the storage driver selected by cfg.Driver.
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch cfg.Driver {
+	case "", "local":
+		return NewLocal(cfg.UploadDir)
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:     cfg.S3Endpoint,
+			Region:       cfg.S3Region,
+			Bucket:       cfg.S3Bucket,
+			AccessKey:    cfg.S3AccessKey,
+			SecretKey:    cfg.S3SecretKey,
+			UsePathStyle: cfg.S3UsePathStyle,
+			Timeout:      time.Duration(cfg.S3TimeoutSeconds) * time.Second,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}